@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Achievement is a badge declared in the game definition. A player earns
+// it once Rule has matched Rule.Count settled rounds.
+type Achievement struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Icon        string          `json:"icon"`
+	Rule        AchievementRule `json:"rule"`
+}
+
+// AchievementRule matches one kind of settled event. Empty fields match
+// anything.
+type AchievementRule struct {
+	Event    string `json:"event"`              // "spin", "bonus" or "gamble"
+	Symbol   string `json:"symbol,omitempty"`   // winning symbol name, or the line's a gamble staked
+	MinMatch int    `json:"minMatch,omitempty"` // symbols matched on the payline
+	MinWin   int    `json:"minWin,omitempty"`   // coins paid
+	Count    int    `json:"count,omitempty"`    // matching events needed, default 1
+}
+
+// settledEvent is a spin, finished bonus or won gamble as the rules see
+// it.
+type settledEvent struct {
+	kind       string
+	symbol     string
+	matchCount int
+	win        int
+}
+
+func (r AchievementRule) matches(ev settledEvent) bool {
+	return r.Event == ev.kind &&
+		(r.Symbol == "" || r.Symbol == ev.symbol) &&
+		(r.MinMatch == 0 || ev.matchCount >= r.MinMatch) &&
+		(r.MinWin == 0 || ev.win >= r.MinWin)
+}
+
+func (r AchievementRule) needed() int {
+	if r.Count < 1 {
+		return 1
+	}
+	return r.Count
+}
+
+func validateAchievements(g *GameDefinition) error {
+	seen := map[string]bool{}
+	for _, a := range g.Achievements {
+		if a.ID == "" || seen[a.ID] {
+			return fmt.Errorf("achievement ids must be unique and non-empty (%q)", a.ID)
+		}
+		seen[a.ID] = true
+		switch a.Rule.Event {
+		case "spin", "bonus", "gamble":
+		default:
+			return fmt.Errorf("achievement %q: event must be spin, bonus or gamble", a.ID)
+		}
+		if a.Rule.Symbol != "" && g.symbolByName(a.Rule.Symbol) == nil {
+			return fmt.Errorf("achievement %q: unknown symbol %q", a.ID, a.Rule.Symbol)
+		}
+	}
+	return nil
+}
+
+// Badges are what a player has earned and their progress towards the rest.
+type Badges struct {
+	Earned   map[string]time.Time
+	Progress map[string]int
+	// Earned since the page last heard about it, shown as toasts
+	unseen []string
+}
+
+// awardBadges runs ev through every achievement rule for p. Callers must
+// hold s.mu.
+func (s *Store) awardBadges(p *Player, ev settledEvent, now time.Time) {
+	if p.Badges.Earned == nil {
+		p.Badges.Earned = map[string]time.Time{}
+		p.Badges.Progress = map[string]int{}
+	}
+	for _, a := range s.game.Achievements {
+		if _, done := p.Badges.Earned[a.ID]; done || !a.Rule.matches(ev) {
+			continue
+		}
+		p.Badges.Progress[a.ID]++
+		if p.Badges.Progress[a.ID] >= a.Rule.needed() {
+			p.Badges.Earned[a.ID] = now
+			p.Badges.unseen = append(p.Badges.unseen, a.ID)
+		}
+	}
+}
+
+// takeNewBadges returns badges p hasn't been told about yet. Callers must
+// hold s.mu.
+func (s *Store) takeNewBadges(p *Player) []Achievement {
+	var out []Achievement
+	for _, id := range p.Badges.unseen {
+		for _, a := range s.game.Achievements {
+			if a.ID == id {
+				out = append(out, a)
+			}
+		}
+	}
+	p.Badges.unseen = nil
+	return out
+}
+
+type BadgeView struct {
+	Achievement
+	Earned   bool       `json:"earned"`
+	EarnedAt *time.Time `json:"earnedAt,omitempty"`
+	Progress int        `json:"progress"`
+	Needed   int        `json:"needed"`
+}
+
+func (s *server) handleAchievements(w http.ResponseWriter, r *http.Request) {
+	views := []BadgeView{}
+	s.store.Update(playerID(r), func(p *Player) error {
+		for _, a := range s.store.game.Achievements {
+			v := BadgeView{Achievement: a, Progress: p.Badges.Progress[a.ID], Needed: a.Rule.needed()}
+			if at, ok := p.Badges.Earned[a.ID]; ok {
+				v.Earned, v.EarnedAt = true, &at
+			}
+			views = append(views, v)
+		}
+		return nil
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"achievements": views})
+}
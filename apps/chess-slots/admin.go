@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	adminAuditSize   = 1000
+	adminLedgerShown = 100
+	adminRoundsShown = 50
+)
+
+var (
+	errNoPlayer = errors.New("no such player")
+	errNoRound  = errors.New("no such round")
+)
+
+// Admin guards the operator API with a bearer token and keeps the audit
+// trail of what was done through it.
+type Admin struct {
+	token []byte
+
+	mu     sync.Mutex
+	nextID int
+	audit  []AuditEntry
+}
+
+// AuditEntry is one admin request: who, from where, what and the outcome.
+// The token is shared, so the actor is only what the caller says in
+// X-Admin-User and is not checked; ActorSource says where it came from.
+type AuditEntry struct {
+	ID    int       `json:"id"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	// "header" for an unverified X-Admin-User name, "default" for a
+	// token holder who gave none ("admin"), and "refused" for a request
+	// without the token ("anonymous"), whatever name it claimed
+	ActorSource string         `json:"actorSource"`
+	IP          string         `json:"ip"`
+	Action      string         `json:"action"`
+	Target      string         `json:"target,omitempty"`
+	Details     map[string]any `json:"details,omitempty"`
+	Status      int            `json:"status"`
+}
+
+// newAdmin is nil without a token, which leaves the admin area off.
+func newAdmin(token string) *Admin {
+	if token == "" {
+		return nil
+	}
+	return &Admin{token: []byte(token)}
+}
+
+func (a *Admin) authorized(r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), a.token) == 1
+}
+
+func (a *Admin) record(e AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextID++
+	e.ID = a.nextID
+	a.audit = append(a.audit, e)
+	if len(a.audit) > adminAuditSize {
+		a.audit = a.audit[len(a.audit)-adminAuditSize:]
+	}
+}
+
+type auditKey struct{}
+
+// auditFrom is the entry the current admin request will be recorded as,
+// for handlers to name their target and add details.
+func auditFrom(ctx context.Context) *AuditEntry {
+	e, _ := ctx.Value(auditKey{}).(*AuditEntry)
+	if e == nil {
+		return &AuditEntry{}
+	}
+	return e
+}
+
+// adminOnly checks the token, then runs h and records it in the audit
+// trail and the log, whether it succeeded, failed or was refused. Operators can name
+// themselves in X-Admin-User; a refused request is recorded as anonymous.
+func (s *server) adminOnly(action string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &AuditEntry{
+			Time:   time.Now(),
+			IP:     clientIP(r),
+			Action: action,
+		}
+		if !s.admin.authorized(r) {
+			entry.Actor, entry.ActorSource = "anonymous", "refused"
+			if claimed := adminActor(r); claimed != "" {
+				entry.Details = map[string]any{"claimedActor": claimed}
+			}
+			entry.Status = http.StatusUnauthorized
+			s.admin.record(*entry)
+			slog.WarnContext(r.Context(), "admin request refused", "action", action, "ip", entry.IP)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chess-slots admin"`)
+			writeError(w, http.StatusUnauthorized, "admin token required")
+			return
+		}
+		entry.Actor, entry.ActorSource = adminActor(r), "header"
+		if entry.Actor == "" {
+			entry.Actor, entry.ActorSource = "admin", "default"
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+		entry.Status = rec.status
+		s.admin.record(*entry)
+		slog.InfoContext(r.Context(), "admin action",
+			"actor", entry.Actor, "actorSource", entry.ActorSource, "action", entry.Action, "target", entry.Target,
+			"details", entry.Details, "status", entry.Status)
+	}
+}
+
+// tokenOnly answers 401 unless the request carries the admin token, like
+// adminOnly but without audit entries: Prometheus scrapes every few
+// seconds and would bury the operators' actions.
+func (s *server) tokenOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.admin.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chess-slots admin"`)
+			writeError(w, http.StatusUnauthorized, "admin token required")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		h(w, r)
+	}
+}
+
+// adminActor is the name the caller gives in X-Admin-User, cut to 64
+// bytes, or "". Nothing checks it.
+func adminActor(r *http.Request) string {
+	actor := strings.TrimSpace(r.Header.Get("X-Admin-User"))
+	if len(actor) > 64 {
+		actor = actor[:64]
+	}
+	return actor
+}
+
+func (s *server) serveAdminPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "admin.html", adminPage{Base: s.base, Boot: map[string]any{"base": s.base}})
+}
+
+type adminPage struct {
+	Base string
+	Boot map[string]any
+}
+
+type PlayerSummary struct {
+	ID           string    `json:"id"`
+	Balance      int       `json:"balance"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastActivity time.Time `json:"lastActivity"`
+	Rounds       int       `json:"rounds"`
+}
+
+// handleAdminPlayers lists players, most recently active first. q filters
+// by ID prefix.
+func (s *server) handleAdminPlayers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	auditFrom(r.Context()).Details = map[string]any{"q": q}
+	var players []PlayerSummary
+	total := 0
+	s.store.read(func() {
+		total = len(s.store.players)
+		rounds := map[string]int{}
+		for _, rd := range s.store.rounds {
+			rounds[rd.PlayerID]++
+		}
+		for _, p := range s.store.players {
+			if !strings.HasPrefix(p.ID, q) {
+				continue
+			}
+			players = append(players, PlayerSummary{
+				ID:           p.ID,
+				Balance:      p.Balance,
+				CreatedAt:    p.CreatedAt,
+				LastActivity: p.Safety.Session.LastActivity,
+				Rounds:       rounds[p.ID],
+			})
+		}
+	})
+	sort.Slice(players, func(i, j int) bool { return players[i].LastActivity.After(players[j].LastActivity) })
+	if len(players) > limit {
+		players = players[:limit]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"players": players, "total": total})
+}
+
+type adminPlayerView struct {
+	PlayerSummary
+	ExcludedUntil   *time.Time `json:"excludedUntil,omitempty"`
+	AutoplayRunning bool       `json:"autoplayRunning"`
+	BonusOpen       bool       `json:"bonusOpen"`
+	// Times flagged as a possible bot, and whether a challenge is pending
+	BotFlags         int           `json:"botFlags"`
+	ChallengePending bool          `json:"challengePending"`
+	Ledger           []LedgerEntry `json:"ledger"`
+	RecentRounds     []Round       `json:"recentRounds"`
+}
+
+// handleAdminPlayer shows a player with their latest ledger entries and
+// rounds, newest first.
+func (s *server) handleAdminPlayer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	auditFrom(r.Context()).Target = id
+	var view adminPlayerView
+	found := false
+	s.store.read(func() {
+		p, ok := s.store.players[id]
+		if !ok {
+			return
+		}
+		found = true
+		view = s.store.adminPlayerView(p)
+	})
+	if !found {
+		writeErr(w, errNoPlayer)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// adminPlayerView gathers what an operator sees of p. Callers must hold
+// s.mu.
+func (s *Store) adminPlayerView(p *Player) adminPlayerView {
+	view := adminPlayerView{
+		PlayerSummary: PlayerSummary{
+			ID:           p.ID,
+			Balance:      p.Balance,
+			CreatedAt:    p.CreatedAt,
+			LastActivity: p.Safety.Session.LastActivity,
+		},
+		AutoplayRunning:  p.Autoplay != nil && p.Autoplay.Running,
+		BonusOpen:        p.Bonus != nil && !p.Bonus.Finished(),
+		BotFlags:         p.Pace.Flags,
+		ChallengePending: p.Pace.Challenge != nil,
+		Ledger:           []LedgerEntry{},
+		RecentRounds:     []Round{},
+	}
+	if until := p.Safety.ExcludedUntil; time.Now().Before(until) {
+		view.ExcludedUntil = &until
+	}
+	for i := len(s.ledger) - 1; i >= 0 && len(view.Ledger) < adminLedgerShown; i-- {
+		if s.ledger[i].PlayerID == p.ID {
+			view.Ledger = append(view.Ledger, s.ledger[i])
+		}
+	}
+	for i := len(s.rounds) - 1; i >= 0; i-- {
+		if s.rounds[i].PlayerID != p.ID {
+			continue
+		}
+		view.Rounds++
+		if len(view.RecentRounds) < adminRoundsShown {
+			view.RecentRounds = append(view.RecentRounds, s.rounds[i])
+		}
+	}
+	return view
+}
+
+// handleAdminAdjust credits or debits a player. The reason is required
+// and goes into the ledger with the entry.
+func (s *server) handleAdminAdjust(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	audit := auditFrom(r.Context())
+	audit.Target = id
+	var req struct {
+		Amount int    `json:"amount"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	audit.Details = map[string]any{"amount": req.Amount, "reason": req.Reason}
+	if req.Amount == 0 || req.Reason == "" {
+		writeError(w, http.StatusBadRequest, `expected {"amount": non-zero coins, "reason": "..."}`)
+		return
+	}
+	var entry LedgerEntry
+	var err error
+	s.store.read(func() {
+		p, ok := s.store.players[id]
+		switch {
+		case !ok:
+			err = errNoPlayer
+		case p.Balance+req.Amount < 0:
+			err = errInsufficientFunds
+		default:
+			// An offer staked on the old balance could overdraw the new one
+			p.Gamble = nil
+			s.store.post(p, "admin_adjustment", req.Amount, "")
+			s.store.ledger[len(s.store.ledger)-1].Reason = req.Reason
+			entry = s.store.ledger[len(s.store.ledger)-1]
+		}
+	})
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	audit.Details["balance"] = entry.Balance
+	writeJSON(w, http.StatusOK, map[string]any{"entry": entry})
+}
+
+func (s *server) handleAdminRound(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	auditFrom(r.Context()).Target = id
+	var round *Round
+	var ledger []LedgerEntry
+	s.store.read(func() {
+		for i := len(s.store.rounds) - 1; i >= 0; i-- {
+			if s.store.rounds[i].ID == id {
+				rd := s.store.rounds[i]
+				round = &rd
+				break
+			}
+		}
+		for _, e := range s.store.ledger {
+			if e.RoundID == id {
+				ledger = append(ledger, e)
+			}
+		}
+	})
+	if round == nil {
+		writeErr(w, errNoRound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"round": round, "ledger": ledger})
+}
+
+// GameRTP compares what a game has paid back since the server started
+// with what its paytable should. Only paid spins and the free spins they
+// won count.
+type GameRTP struct {
+	Game     string `json:"game"`
+	Name     string `json:"name"`
+	Spins    int    `json:"spins"`
+	Wagered  int    `json:"wagered"`
+	Won      int    `json:"won"`
+	BonusWon int    `json:"bonusWon"`
+	// Paid by free spins, and the progressive jackpots its spins won
+	FreeSpinsWon int `json:"freeSpinsWon"`
+	JackpotWon   int `json:"jackpotWon"`
+	// Everything won / wagered, and won / wagered
+	LiveRTP     float64 `json:"liveRtp"`
+	LiveBaseRTP float64 `json:"liveBaseRtp"`
+	// From the paytable, without the pick bonus
+	TheoreticalBaseRTP float64 `json:"theoreticalBaseRtp"`
+}
+
+func (s *server) handleAdminRTP(w http.ResponseWriter, r *http.Request) {
+	byGame := map[string]*GameRTP{}
+	var games []GameRTP
+	for _, g := range s.store.games.games {
+		byGame[g.ID] = &GameRTP{Game: g.ID, Name: g.Name, TheoreticalBaseRTP: g.paytable().BaseRTP}
+	}
+	s.store.read(func() {
+		roundGame := map[string]string{}
+		for _, rd := range s.store.rounds {
+			stats, ok := byGame[rd.GameID]
+			if !ok || rd.TournamentID != "" || rd.DuelID != "" {
+				continue
+			}
+			roundGame[rd.ID] = rd.GameID
+			if rd.Free {
+				stats.FreeSpinsWon += rd.Win
+				continue
+			}
+			stats.Spins++
+			stats.Wagered += rd.Bet
+			stats.Won += rd.Win
+		}
+		for _, e := range s.store.ledger {
+			game, ok := roundGame[e.RoundID]
+			switch {
+			case !ok:
+			case e.Kind == "bonus":
+				byGame[game].BonusWon += e.Amount
+			case e.Kind == "jackpot":
+				byGame[game].JackpotWon += e.Amount
+			}
+		}
+	})
+	for _, g := range s.store.games.games {
+		stats := byGame[g.ID]
+		if stats.Wagered > 0 {
+			stats.LiveRTP = float64(stats.Won+stats.BonusWon+stats.FreeSpinsWon+stats.JackpotWon) / float64(stats.Wagered)
+			stats.LiveBaseRTP = float64(stats.Won) / float64(stats.Wagered)
+		}
+		games = append(games, *stats)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"games": games})
+}
+
+func (s *server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, s.maintenance.view())
+		return
+	}
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Message string `json:"message"`
+	}
+	if err := decodeJSON(r, &req); err != nil || req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, `expected {"enabled": true|false, "message": "..."}`)
+		return
+	}
+	auditFrom(r.Context()).Details = map[string]any{"enabled": *req.Enabled, "message": req.Message}
+	view := s.maintenance.set(*req.Enabled, strings.TrimSpace(req.Message))
+	slog.WarnContext(r.Context(), "maintenance mode changed", "enabled", view.Enabled)
+	s.publishStatus()
+	writeJSON(w, http.StatusOK, view)
+}
+
+// handleAdminFeatures shows the kill switches, or flips the ones named in
+// the body, e.g. {"tournaments": false}.
+func (s *server) handleAdminFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var changes map[string]bool
+		if err := decodeJSON(r, &changes); err != nil || len(changes) == 0 {
+			writeError(w, http.StatusBadRequest, `expected {"<feature>": true|false, ...}`)
+			return
+		}
+		details := map[string]any{}
+		for name, on := range changes {
+			details[name] = on
+		}
+		auditFrom(r.Context()).Details = details
+		if err := s.store.switches.set(changes); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.WarnContext(r.Context(), "feature switches changed", "changes", changes)
+		s.publishStatus()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"features": s.store.switches.all()})
+}
+
+// handleAdminAudit lists the audit trail, newest first.
+func (s *server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > adminAuditSize {
+		limit = 100
+	}
+	s.admin.mu.Lock()
+	entries := make([]AuditEntry, 0, min(limit, len(s.admin.audit)))
+	for i := len(s.admin.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, s.admin.audit[i])
+	}
+	s.admin.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
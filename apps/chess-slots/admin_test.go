@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAuditActor(t *testing.T) {
+	const token = "0123456789abcdef0123"
+	tests := []struct {
+		name    string
+		token   string
+		user    string
+		status  int
+		actor   string
+		source  string
+		claimed string
+	}{
+		{name: "named", token: token, user: "alice", status: http.StatusOK, actor: "alice", source: "header"},
+		{name: "unnamed", token: token, status: http.StatusOK, actor: "admin", source: "default"},
+		{name: "long name cut", token: token, user: strings.Repeat("a", 80), status: http.StatusOK, actor: strings.Repeat("a", 64), source: "header"},
+		{name: "no token", status: http.StatusUnauthorized, actor: "anonymous", source: "refused"},
+		{name: "wrong token claiming a name", token: "not-the-token-at-all", user: "alice", status: http.StatusUnauthorized, actor: "anonymous", source: "refused", claimed: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.AdminToken = token
+			srv := newServer(newTestStore(t), cfg)
+			r := httptest.NewRequest("GET", srv.base+"/admin/api/rtp", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.user != "" {
+				r.Header.Set("X-Admin-User", tt.user)
+			}
+			w := httptest.NewRecorder()
+			srv.routes().ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if len(srv.admin.audit) != 1 {
+				t.Fatalf("%d audit entries, want 1", len(srv.admin.audit))
+			}
+			e := srv.admin.audit[0]
+			if e.Actor != tt.actor || e.ActorSource != tt.source || e.Status != tt.status {
+				t.Errorf("recorded actor %q (%s) status %d, want %q (%s) status %d",
+					e.Actor, e.ActorSource, e.Status, tt.actor, tt.source, tt.status)
+			}
+			if claimed, _ := e.Details["claimedActor"].(string); claimed != tt.claimed {
+				t.Errorf("claimedActor = %q, want %q", claimed, tt.claimed)
+			}
+		})
+	}
+}
+
+func TestMetricsNeedToken(t *testing.T) {
+	const token = "0123456789abcdef0123"
+	tests := []struct {
+		name   string
+		admin  bool // ADMIN_TOKEN set
+		path   string
+		token  string
+		status int
+	}{
+		{name: "no admin area", path: "/metrics", token: token, status: http.StatusNotFound},
+		{name: "no token", admin: true, path: "/metrics", status: http.StatusUnauthorized},
+		{name: "wrong token", admin: true, path: "/metrics", token: "not-the-token-at-all", status: http.StatusUnauthorized},
+		{name: "token", admin: true, path: "/metrics", token: token, status: http.StatusOK},
+		{name: "under the base path, no token", admin: true, path: defaultBasePath + "/metrics", status: http.StatusUnauthorized},
+		{name: "under the base path", admin: true, path: defaultBasePath + "/metrics", token: token, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			if tt.admin {
+				cfg.AdminToken = token
+			}
+			srv := newServer(newTestStore(t), cfg)
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			srv.routes().ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if srv.admin != nil && len(srv.admin.audit) != 0 {
+				t.Errorf("a scrape left %d audit entries", len(srv.admin.audit))
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	playerCookie  = "chess_slots_player"
+	schedulerTick = 5 * time.Second
+)
+
+type server struct {
+	store *Store
+	cfg   *Config
+	// Every route and link is under this path ("" for the root)
+	base string
+	// Closed when shutdown begins
+	closing     chan struct{}
+	maintenance Maintenance
+	admin       *Admin
+	// Spin rate limits, and what they and the bot heuristics caught
+	playerLimit *rateLimiter
+	ipLimit     *rateLimiter
+	abuse       AbuseLog
+	// New sessions per client IP
+	sessionLimit *rateLimiter
+}
+
+func newServer(store *Store, cfg *Config) *server {
+	store.switches = newSwitches(cfg.Features)
+	rl := cfg.RateLimit
+	return &server{
+		store:       store,
+		cfg:         cfg,
+		base:        cfg.BasePath,
+		closing:     make(chan struct{}),
+		admin:       newAdmin(cfg.AdminToken),
+		playerLimit: newRateLimiter(rl.PlayerRate, rl.PlayerBurst),
+		ipLimit:     newRateLimiter(rl.IPRate, rl.IPBurst),
+
+		sessionLimit: newRateLimiter(1/sessionIPEvery.Seconds(), sessionIPBurst),
+	}
+}
+
+// runScheduler drives everything that happens on a clock: tournaments
+// opening and settling, duels timing out, and idle players and rate
+// limit buckets being forgotten. It stops at shutdown.
+func (s *server) runScheduler() {
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.store.read(func() {
+				s.store.tickTournaments(now)
+				s.store.tickDuels(now)
+				s.store.expirePlayers(now)
+				s.store.publishJackpot()
+			})
+			s.playerLimit.prune(now)
+			s.ipLimit.prune(now)
+			s.sessionLimit.prune(now)
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// shuttingDown reports whether shutdown has begun.
+func (s *server) shuttingDown() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// writeErr responds with err's status. Limit errors carry a code (and when
+// they lift) so the page can explain what happened.
+func writeErr(w http.ResponseWriter, err error) {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		writeJSON(w, http.StatusForbidden, limitErr)
+		return
+	}
+	setRetryAfter(w, err)
+	writeError(w, errorStatus(err), err.Error())
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errors.New("invalid JSON body")
+	}
+	return nil
+}
+
+// decodeOptionalJSON is decodeJSON for endpoints whose body may be left
+// out entirely.
+func decodeOptionalJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("invalid JSON body")
+	}
+	return nil
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInsufficientFunds), errors.Is(err, errBonusActive), errors.Is(err, errNoBonus),
+		errors.Is(err, errAutoplayRunning), errors.Is(err, errAlreadyClaimed), errors.Is(err, errRefillNotReady),
+		errors.Is(err, errNotRegistered), errors.Is(err, errTournamentShut), errors.Is(err, errNotRunning),
+		errors.Is(err, errOutOfCredits), errors.Is(err, errDuelsOff), errors.Is(err, errInDuel), errors.Is(err, errNoDuel),
+		errors.Is(err, errDuelWaiting), errors.Is(err, errDuelStarted), errors.Is(err, errDuelSpinsSpent),
+		errors.Is(err, errNoGamble):
+		return http.StatusConflict
+	case errors.Is(err, errNoTournament), errors.Is(err, errNoSpectateLink), errors.Is(err, errUnknownGame),
+		errors.Is(err, errNoPlayer), errors.Is(err, errNoRound), errors.Is(err, errNoPaytable),
+		errors.Is(err, errNoExperiment):
+		return http.StatusNotFound
+	case errors.Is(err, errNoSession):
+		return http.StatusUnauthorized
+	case errors.Is(err, errTooManyClaims), errors.Is(err, errRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errFeatureDisabled), errors.Is(err, errMaintenance):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errInvalidSquare), errors.Is(err, errAlreadyPicked), errors.Is(err, errWSProtocol),
+		errors.Is(err, errGamblePick):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type stateResponse struct {
+	Balance  int            `json:"balance"`
+	SpinCost int            `json:"spinCost"`
+	Bonus    *PickBonusView `json:"bonus"`
+	Autoplay *Autoplay      `json:"autoplay"`
+	Rewards  RewardsStatus  `json:"rewards"`
+	// Free spins left to play, and the last win while it can be gambled
+	FreeSpins *FreeSpins   `json:"freeSpins"`
+	Gamble    *GambleOffer `json:"gamble"`
+	// Badges earned since the page last checked
+	Achievements []Achievement `json:"achievements,omitempty"`
+}
+
+func bonusView(p *Player) *PickBonusView {
+	if p.Bonus == nil {
+		return nil
+	}
+	v := p.Bonus.view()
+	return &v
+}
+
+func (s *server) handleState(w http.ResponseWriter, r *http.Request) {
+	var resp stateResponse
+	s.store.Update(playerID(r), func(p *Player) error {
+		resp = stateResponse{
+			Balance:      p.Balance,
+			SpinCost:     s.store.game.SpinCost,
+			Bonus:        bonusView(p),
+			Autoplay:     autoplayView(p.Autoplay, -1),
+			Rewards:      s.store.rewardsStatus(p, time.Now()),
+			FreeSpins:    p.FreeSpins,
+			Gamble:       p.Gamble,
+			Achievements: s.store.takeNewBadges(p),
+		}
+		return nil
+	})
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGame returns the definition of ?id=, or of the house game, with
+// the paytable this player's spins use.
+func (s *server) handleGame(w http.ResponseWriter, r *http.Request) {
+	g, err := s.store.games.get(r.URL.Query().Get("id"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	pt, _, _ := g.assign(playerID(r))
+	writeJSON(w, http.StatusOK, g.withPaytable(pt))
+}
+
+// handleGames lists the lobby.
+func (s *server) handleGames(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"games": s.store.games.summaries()})
+}
+
+type spinResponse struct {
+	RoundID string `json:"roundId"`
+	SpinResult
+	Balance int `json:"balance"`
+	// The progressive pool won, besides the line prize
+	Jackpot      int            `json:"jackpot,omitempty"`
+	Bonus        *PickBonusView `json:"bonus"`
+	FreeSpins    *FreeSpins     `json:"freeSpins"`
+	Gamble       *GambleOffer   `json:"gamble"`
+	Achievements []Achievement  `json:"achievements,omitempty"`
+}
+
+func (s *server) handleSpin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Game string `json:"game"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp, err := s.playSpin(playerID(r), clientIP(r), req.Game)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// playSpin is one paid spin of gameID (empty for the house game) for the
+// REST and WebSocket APIs alike.
+func (s *server) playSpin(playerID, ip, gameID string) (spinResponse, error) {
+	g, err := s.store.games.get(gameID)
+	if err != nil {
+		return spinResponse{}, err
+	}
+	var resp spinResponse
+	err = s.store.Update(playerID, func(p *Player) error {
+		if p.Autoplay != nil && p.Autoplay.Running {
+			return errAutoplayRunning
+		}
+		if err := s.admitSpin(p, g, ip); err != nil {
+			return err
+		}
+		round, err := s.store.spin(p, g)
+		if err != nil {
+			return err
+		}
+		resp = spinResponse{
+			RoundID:      round.ID,
+			SpinResult:   round.Result,
+			Balance:      p.Balance,
+			Jackpot:      round.Jackpot,
+			Bonus:        bonusView(p),
+			FreeSpins:    p.FreeSpins,
+			Gamble:       p.Gamble,
+			Achievements: s.store.takeNewBadges(p),
+		}
+		return nil
+	})
+	return resp, err
+}
+
+type pickResponse struct {
+	Pick         Pick          `json:"pick"`
+	Bonus        PickBonusView `json:"bonus"`
+	Balance      int           `json:"balance"`
+	Achievements []Achievement `json:"achievements,omitempty"`
+}
+
+func (s *server) handlePick(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Square *int `json:"square"`
+	}
+	if err := decodeJSON(r, &req); err != nil || req.Square == nil {
+		writeError(w, http.StatusBadRequest, "expected {\"square\": 0-63}")
+		return
+	}
+	resp, err := s.playPick(playerID(r), *req.Square)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) playPick(playerID string, square int) (pickResponse, error) {
+	var resp pickResponse
+	err := s.store.Update(playerID, func(p *Player) error {
+		pick, view, err := s.store.pickSquare(p, square)
+		if err != nil {
+			return err
+		}
+		resp = pickResponse{Pick: pick, Bonus: view, Balance: p.Balance, Achievements: s.store.takeNewBadges(p)}
+		return nil
+	})
+	return resp, err
+}
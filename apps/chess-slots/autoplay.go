@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const autoplayMaxSpins = 100
+
+var errAutoplayRunning = errors.New("autoplay is already running")
+
+// AutoplayRules are the player's stop conditions. Zero disables a limit.
+// Autoplay always stops when a bonus triggers, since the player has to
+// make the picks.
+// Spins are paced by the chosen presentation mode so the page has time to
+// play each one back.
+type AutoplayRules struct {
+	Spins          int    `json:"spins"`
+	LossLimit      int    `json:"lossLimit"`
+	SingleWinLimit int    `json:"singleWinLimit"`
+	BalanceFloor   int    `json:"balanceFloor"`
+	Mode           string `json:"mode"`
+	// The game to play; empty is the house game
+	Game string `json:"game,omitempty"`
+}
+
+func (r AutoplayRules) validate(g *GameDefinition) error {
+	if r.Spins < 1 || r.Spins > autoplayMaxSpins {
+		return fmt.Errorf("spins must be between 1 and %d", autoplayMaxSpins)
+	}
+	if r.LossLimit < 0 || r.SingleWinLimit < 0 || r.BalanceFloor < 0 {
+		return errors.New("limits cannot be negative")
+	}
+	if _, ok := g.Presentation[r.Mode]; r.Mode != "" && !ok {
+		return fmt.Errorf("unknown mode %q", r.Mode)
+	}
+	return nil
+}
+
+type Autoplay struct {
+	ID           string         `json:"id"`
+	Rules        AutoplayRules  `json:"rules"`
+	Running      bool           `json:"running"`
+	Played       int            `json:"played"`
+	Net          int            `json:"net"`
+	StopReason   string         `json:"stopReason,omitempty"`
+	Rounds       []spinResponse `json:"rounds"`
+	startBalance int
+	game         *GameDefinition
+	stop         chan struct{}
+}
+
+// autoplayStep plays the next autoplay spin for p and applies the stop
+// conditions. Callers must hold s.mu.
+func (s *Store) autoplayStep(p *Player, ap *Autoplay) {
+	// A free spin costs nothing, so the floor can't stop it
+	if !s.freeSpin(p, ap.game) && p.Balance-ap.game.SpinCost < ap.Rules.BalanceFloor {
+		s.stopAutoplay(ap, "balance floor reached")
+		return
+	}
+	round, err := s.spin(p, ap.game)
+	if err != nil {
+		metrics.recordWalletError(err)
+		s.stopAutoplay(ap, err.Error())
+		return
+	}
+	ap.Played++
+	ap.Net = p.Balance - ap.startBalance
+	ap.Rounds = append(ap.Rounds, spinResponse{
+		RoundID:      round.ID,
+		SpinResult:   round.Result,
+		Balance:      p.Balance,
+		Jackpot:      round.Jackpot,
+		Bonus:        bonusView(p),
+		FreeSpins:    p.FreeSpins,
+		Achievements: s.takeNewBadges(p),
+	})
+
+	switch {
+	case round.Result.BonusTriggered:
+		s.stopAutoplay(ap, "bonus triggered")
+	case round.Result.FreeSpinsWon > 0:
+		s.stopAutoplay(ap, "free spins won")
+	case ap.Rules.SingleWinLimit > 0 && round.Win >= ap.Rules.SingleWinLimit:
+		s.stopAutoplay(ap, "single win limit reached")
+	case ap.Rules.LossLimit > 0 && -ap.Net >= ap.Rules.LossLimit:
+		s.stopAutoplay(ap, "loss limit reached")
+	case ap.Played >= ap.Rules.Spins:
+		s.stopAutoplay(ap, "completed")
+	}
+}
+
+// stopAutoplay ends ap and wakes its runner. Callers must hold s.mu.
+func (s *Store) stopAutoplay(ap *Autoplay, reason string) {
+	if ap == nil || !ap.Running {
+		return
+	}
+	ap.Running = false
+	ap.StopReason = reason
+	close(ap.stop)
+}
+
+func (s *server) runAutoplay(playerID string, ap *Autoplay) {
+	interval := ap.game.presentation(ap.Rules.Mode).AutoplayIntervalMs
+	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.store.Update(playerID, func(p *Player) error {
+			switch {
+			case !ap.Running:
+			case s.maintenance.check() != nil:
+				s.store.stopAutoplay(ap, "maintenance")
+			case !s.featureOn("autoplay"):
+				s.store.stopAutoplay(ap, "autoplay turned off")
+			default:
+				s.store.autoplayStep(p, ap)
+			}
+			return nil
+		})
+		select {
+		case <-ap.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// autoplayView copies ap with only the rounds after the client's cursor.
+func autoplayView(ap *Autoplay, after int) *Autoplay {
+	if ap == nil {
+		return nil
+	}
+	v := *ap
+	if after < 0 || after > len(ap.Rounds) {
+		after = len(ap.Rounds)
+	}
+	v.Rounds = append([]spinResponse{}, ap.Rounds[after:]...)
+	return &v
+}
+
+func (s *server) autoplayStatus(w http.ResponseWriter, r *http.Request) {
+	after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+	var view *Autoplay
+	s.store.Update(playerID(r), func(p *Player) error {
+		view = autoplayView(p.Autoplay, after)
+		return nil
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"autoplay": view})
+}
+
+func (s *server) startAutoplay(w http.ResponseWriter, r *http.Request) {
+	var rules AutoplayRules
+	if err := decodeJSON(r, &rules); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	g, err := s.store.games.get(rules.Game)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if err := rules.validate(g); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id := playerID(r)
+	var ap, view *Autoplay
+	err = s.store.Update(id, func(p *Player) error {
+		if p.Autoplay != nil && p.Autoplay.Running {
+			return errAutoplayRunning
+		}
+		if p.Bonus != nil && !p.Bonus.Finished() {
+			return errBonusActive
+		}
+		if p.Balance < g.SpinCost {
+			return errInsufficientFunds
+		}
+		if err := p.Safety.checkBet(g.SpinCost, time.Now()); err != nil {
+			return err
+		}
+		ap = &Autoplay{
+			ID:           newID(),
+			Rules:        rules,
+			Running:      true,
+			Rounds:       []spinResponse{},
+			startBalance: p.Balance,
+			game:         g,
+			stop:         make(chan struct{}),
+		}
+		p.Autoplay = ap
+		view = autoplayView(ap, 0)
+		return nil
+	})
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	go s.runAutoplay(id, ap)
+	writeJSON(w, http.StatusAccepted, map[string]any{"autoplay": view})
+}
+
+func (s *server) cancelAutoplay(w http.ResponseWriter, r *http.Request) {
+	var view *Autoplay
+	s.store.Update(playerID(r), func(p *Player) error {
+		s.store.stopAutoplay(p.Autoplay, "cancelled")
+		view = autoplayView(p.Autoplay, -1)
+		return nil
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"autoplay": view})
+}
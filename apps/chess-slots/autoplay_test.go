@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// wantAutoplayStop replays ap's rounds through its rules and returns the
+// reason it should have stopped with, and after how many spins. The first
+// free rounds were free spins, which the floor doesn't stop.
+func wantAutoplayStop(ap *Autoplay, cost, free int) (string, int) {
+	balance := ap.startBalance
+	for i, r := range ap.Rounds {
+		if i >= free && balance-cost < ap.Rules.BalanceFloor {
+			return "balance floor reached", i
+		}
+		balance = r.Balance
+		switch {
+		case r.BonusTriggered:
+			return "bonus triggered", i + 1
+		case r.FreeSpinsWon > 0:
+			return "free spins won", i + 1
+		case ap.Rules.SingleWinLimit > 0 && r.Payout >= ap.Rules.SingleWinLimit:
+			return "single win limit reached", i + 1
+		case ap.Rules.LossLimit > 0 && ap.startBalance-balance >= ap.Rules.LossLimit:
+			return "loss limit reached", i + 1
+		case i+1 >= ap.Rules.Spins:
+			return "completed", i + 1
+		}
+	}
+	return "balance floor reached", len(ap.Rounds)
+}
+
+func TestAutoplayStops(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   AutoplayRules
+		balance int
+		free    int // free spins held at the start
+	}{
+		{name: "spins only", rules: AutoplayRules{Spins: 20}},
+		{name: "loss limit", rules: AutoplayRules{Spins: 100, LossLimit: 30}},
+		{name: "single win limit", rules: AutoplayRules{Spins: 100, SingleWinLimit: 20}},
+		{name: "balance floor", rules: AutoplayRules{Spins: 100, BalanceFloor: 480}},
+		{name: "floor before the first spin", rules: AutoplayRules{Spins: 10, BalanceFloor: 100}, balance: 100},
+		{name: "free spins below the floor", rules: AutoplayRules{Spins: 10, BalanceFloor: 500}, balance: 100, free: 3},
+		{name: "every rule", rules: AutoplayRules{Spins: 50, LossLimit: 60, SingleWinLimit: 50, BalanceFloor: 450}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			g := s.games.house()
+			// Spins are random, so check the rules hold over many runs
+			for run := 0; run < 50; run++ {
+				id := "p" + strconv.Itoa(run)
+				s.createPlayer(id)
+				p := s.players[id]
+				if tt.balance > 0 {
+					p.Balance = tt.balance
+				}
+				if tt.free > 0 {
+					p.FreeSpins = &FreeSpins{GameID: g.ID, Left: tt.free}
+				}
+				ap := &Autoplay{Rules: tt.rules, Running: true, startBalance: p.Balance, game: g, stop: make(chan struct{})}
+				p.Autoplay = ap
+				for i := 0; ap.Running && i <= tt.rules.Spins; i++ {
+					s.autoplayStep(p, ap)
+				}
+				if ap.Running {
+					t.Fatalf("still running after %d spins of %d", ap.Played, tt.rules.Spins)
+				}
+				reason, played := wantAutoplayStop(ap, g.SpinCost, tt.free)
+				if ap.StopReason != reason || ap.Played != played {
+					t.Fatalf("stopped with %q after %d spins, want %q after %d", ap.StopReason, ap.Played, reason, played)
+				}
+				if ap.Net != p.Balance-ap.startBalance {
+					t.Errorf("net = %d, want %d", ap.Net, p.Balance-ap.startBalance)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoplayRulesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules AutoplayRules
+		ok    bool
+	}{
+		{name: "fine", rules: AutoplayRules{Spins: 10, Mode: "turbo"}, ok: true},
+		{name: "no spins", rules: AutoplayRules{}},
+		{name: "too many spins", rules: AutoplayRules{Spins: autoplayMaxSpins + 1}},
+		{name: "negative limit", rules: AutoplayRules{Spins: 10, LossLimit: -1}},
+		{name: "unknown mode", rules: AutoplayRules{Spins: 10, Mode: "warp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestStore(t).games.house()
+			if err := tt.rules.validate(g); (err == nil) != tt.ok {
+				t.Errorf("got error %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
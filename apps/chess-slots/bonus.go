@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	boardSize       = 8
+	boardSquares    = boardSize * boardSize
+	capturedSquares = 8
+)
+
+var (
+	errNoBonus       = errors.New("no bonus game in progress")
+	errInvalidSquare = errors.New("square must be between 0 and 63")
+	errAlreadyPicked = errors.New("square already picked")
+)
+
+// Coin prizes are in multiples of the triggering bet; repeats make the
+// small prizes more common.
+var coinPrizes = []int{1, 1, 1, 2, 2, 2, 3, 3, 5, 5, 10, 20}
+
+// Multiplier squares add to the feature multiplier, which starts at 1.
+var multiplierPrizes = []int{1, 1, 1, 1, 2, 2}
+
+type PickSquare struct {
+	Kind  string `json:"kind"` // "coins", "multiplier" or "captured"
+	Value int    `json:"value,omitempty"`
+}
+
+func (sq PickSquare) code() string {
+	switch sq.Kind {
+	case "coins":
+		return fmt.Sprintf("c%d", sq.Value)
+	case "multiplier":
+		return fmt.Sprintf("m%d", sq.Value)
+	default:
+		return "x"
+	}
+}
+
+type Pick struct {
+	Square int        `json:"square"`
+	Prize  PickSquare `json:"prize"`
+}
+
+// PickBonus is the Pick-a-Piece feature. The whole board is dealt when the
+// bonus triggers and only its SHA-256 commitment is shown until the feature
+// ends, at which point the salt and board are revealed so the player can
+// check that nothing moved underneath them.
+type PickBonus struct {
+	RoundID    string
+	GameID     string
+	Bet        int
+	Commitment string
+	Picks      []Pick
+	Coins      int
+	Multiplier int
+	Captured   bool
+
+	board [boardSquares]PickSquare
+	salt  string
+}
+
+type PickBonusView struct {
+	RoundID    string `json:"roundId"`
+	Commitment string `json:"commitment"`
+	Picks      []Pick `json:"picks"`
+	Coins      int    `json:"coins"`
+	Multiplier int    `json:"multiplier"`
+	Finished   bool   `json:"finished"`
+	Captured   bool   `json:"captured"`
+	Payout     int    `json:"payout"`
+	Salt       string `json:"salt,omitempty"`
+	Board      string `json:"board,omitempty"`
+}
+
+func newPickBonus(roundID, gameID string, bet int) *PickBonus {
+	b := &PickBonus{RoundID: roundID, GameID: gameID, Bet: bet, Multiplier: 1, Picks: []Pick{}, salt: newID()}
+
+	i := 0
+	for ; i < capturedSquares; i++ {
+		b.board[i] = PickSquare{Kind: "captured"}
+	}
+	for _, m := range multiplierPrizes {
+		b.board[i] = PickSquare{Kind: "multiplier", Value: m}
+		i++
+	}
+	for ; i < boardSquares; i++ {
+		b.board[i] = PickSquare{Kind: "coins", Value: coinPrizes[randIntn(len(coinPrizes))]}
+	}
+	for i := boardSquares - 1; i > 0; i-- {
+		j := randIntn(i + 1)
+		b.board[i], b.board[j] = b.board[j], b.board[i]
+	}
+
+	b.Commitment = commitBoard(b.salt, b.encodeBoard())
+	return b
+}
+
+// encodeBoard is the canonical text the commitment is computed over:
+// one code per square, row by row, e.g. "c2,x,m1,...".
+func (b *PickBonus) encodeBoard() string {
+	codes := make([]string, boardSquares)
+	for i, sq := range b.board {
+		codes[i] = sq.code()
+	}
+	return strings.Join(codes, ",")
+}
+
+func commitBoard(salt, board string) string {
+	sum := sha256.Sum256([]byte(salt + "|" + board))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *PickBonus) Finished() bool {
+	return b.Captured || len(b.Picks) == boardSquares-capturedSquares
+}
+
+func (b *PickBonus) Payout() int {
+	return b.Coins * b.Multiplier
+}
+
+func (b *PickBonus) pick(square int) (Pick, error) {
+	if b.Finished() {
+		return Pick{}, errNoBonus
+	}
+	if square < 0 || square >= boardSquares {
+		return Pick{}, errInvalidSquare
+	}
+	for _, p := range b.Picks {
+		if p.Square == square {
+			return Pick{}, errAlreadyPicked
+		}
+	}
+
+	prize := b.board[square]
+	switch prize.Kind {
+	case "coins":
+		b.Coins += prize.Value * b.Bet
+	case "multiplier":
+		b.Multiplier += prize.Value
+	case "captured":
+		b.Captured = true
+	}
+	p := Pick{Square: square, Prize: prize}
+	b.Picks = append(b.Picks, p)
+	return p, nil
+}
+
+func (b *PickBonus) view() PickBonusView {
+	v := PickBonusView{
+		RoundID:    b.RoundID,
+		Commitment: b.Commitment,
+		Picks:      b.Picks,
+		Coins:      b.Coins,
+		Multiplier: b.Multiplier,
+		Finished:   b.Finished(),
+		Captured:   b.Captured,
+	}
+	if v.Finished {
+		v.Payout = b.Payout()
+		v.Salt = b.salt
+		v.Board = b.encodeBoard()
+	}
+	return v
+}
+
+// pickSquare reveals one square of p's bonus board and pays the feature
+// out once it ends. Callers must hold s.mu.
+func (s *Store) pickSquare(p *Player, square int) (Pick, PickBonusView, error) {
+	if p.Bonus == nil || p.Bonus.Finished() {
+		return Pick{}, PickBonusView{}, errNoBonus
+	}
+	b := p.Bonus
+	pick, err := b.pick(square)
+	if err != nil {
+		return Pick{}, PickBonusView{}, err
+	}
+	if b.Finished() {
+		if payout := b.Payout(); payout > 0 {
+			s.post(p, "bonus", payout, b.RoundID)
+			metrics.recordBonusWin(b.GameID, payout)
+			s.leaderboard.record(p.ID, b.Bet, payout, false, time.Now())
+			s.publishBigWin(p.ID, b.Bet, BigWinEvent{Win: payout, Bonus: true})
+		}
+		s.awardBadges(p, settledEvent{kind: "bonus", win: b.Payout()}, time.Now())
+		s.publishSpectate(p, "bonus", SpectateBonus{Payout: b.Payout(), Balance: p.Balance})
+		p.Bonus = nil
+	}
+	return pick, b.view(), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fixedBonus deals a known board: captured on 0-7, the multipliers on
+// 8-13 and 2x the bet on every other square.
+func fixedBonus(bet int) *PickBonus {
+	b := &PickBonus{RoundID: "r1", GameID: "chess-slots", Bet: bet, Multiplier: 1, salt: "salt"}
+	i := 0
+	for ; i < capturedSquares; i++ {
+		b.board[i] = PickSquare{Kind: "captured"}
+	}
+	for _, m := range multiplierPrizes {
+		b.board[i] = PickSquare{Kind: "multiplier", Value: m}
+		i++
+	}
+	for ; i < boardSquares; i++ {
+		b.board[i] = PickSquare{Kind: "coins", Value: 2}
+	}
+	b.Commitment = commitBoard(b.salt, b.encodeBoard())
+	return b
+}
+
+func TestPickBonusPicks(t *testing.T) {
+	tests := []struct {
+		name       string
+		picks      []int
+		err        error // from the last pick
+		finished   bool
+		payout     int
+		multiplier int
+	}{
+		{name: "coins", picks: []int{14}, payout: 20, multiplier: 1},
+		{name: "captured ends it", picks: []int{14, 0}, finished: true, payout: 20, multiplier: 1},
+		{name: "multiplier applies to coins", picks: []int{12, 14, 15}, payout: 120, multiplier: 3},
+		{name: "captured first pays nothing", picks: []int{3}, finished: true, payout: 0, multiplier: 1},
+		{name: "below the board", picks: []int{-1}, err: errInvalidSquare, multiplier: 1},
+		{name: "past the board", picks: []int{64}, err: errInvalidSquare, multiplier: 1},
+		{name: "same square twice", picks: []int{20, 20}, err: errAlreadyPicked, payout: 20, multiplier: 1},
+		{name: "after it ends", picks: []int{0, 20}, err: errNoBonus, finished: true, multiplier: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fixedBonus(10)
+			var err error
+			for _, sq := range tt.picks {
+				_, err = b.pick(sq)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("last pick: got error %v, want %v", err, tt.err)
+			}
+			if b.Finished() != tt.finished {
+				t.Errorf("finished = %v, want %v", b.Finished(), tt.finished)
+			}
+			if b.Payout() != tt.payout {
+				t.Errorf("payout = %d, want %d", b.Payout(), tt.payout)
+			}
+			if b.Multiplier != tt.multiplier {
+				t.Errorf("multiplier = %d, want %d", b.Multiplier, tt.multiplier)
+			}
+		})
+	}
+}
+
+func TestPickBonusClearsEverySafeSquare(t *testing.T) {
+	b := fixedBonus(1)
+	for sq := capturedSquares; sq < boardSquares; sq++ {
+		if _, err := b.pick(sq); err != nil {
+			t.Fatalf("square %d: %v", sq, err)
+		}
+	}
+	if !b.Finished() || b.Captured {
+		t.Fatalf("finished = %v, captured = %v; want a finished board without a capture", b.Finished(), b.Captured)
+	}
+	// 50 coin squares of 2, times 1 plus the multipliers
+	if want := 100 * 9; b.Payout() != want {
+		t.Errorf("payout = %d, want %d", b.Payout(), want)
+	}
+}
+
+func TestPickBonusReveal(t *testing.T) {
+	b := newPickBonus("r1", "chess-slots", 10)
+	if v := b.view(); v.Salt != "" || v.Board != "" {
+		t.Fatalf("an open bonus shows its salt %q or board %q", v.Salt, v.Board)
+	}
+	for sq, square := range b.board {
+		if square.Kind == "captured" {
+			if _, err := b.pick(sq); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	v := b.view()
+	if !v.Finished {
+		t.Fatal("picking a captured square should end the bonus")
+	}
+
+	counts := map[string]int{}
+	for _, code := range strings.Split(v.Board, ",") {
+		counts[code[:1]]++
+	}
+	if counts["x"] != capturedSquares || counts["m"] != len(multiplierPrizes) || counts["c"] != boardSquares-capturedSquares-len(multiplierPrizes) {
+		t.Errorf("revealed board has %v squares by kind", counts)
+	}
+
+	altered := "c20" + v.Board[strings.Index(v.Board, ","):]
+	if altered == v.Board {
+		altered = "x" + v.Board[strings.Index(v.Board, ","):]
+	}
+	tests := []struct {
+		name  string
+		salt  string
+		board string
+		match bool
+	}{
+		{name: "revealed board", salt: v.Salt, board: v.Board, match: true},
+		{name: "altered square", salt: v.Salt, board: altered},
+		{name: "other salt", salt: v.Salt + "0", board: v.Board},
+		{name: "no salt", salt: "", board: v.Board},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commitBoard(tt.salt, tt.board) == v.Commitment; got != tt.match {
+				t.Errorf("matches the commitment = %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
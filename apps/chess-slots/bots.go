@@ -0,0 +1,277 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// How many gaps between spins the bot heuristics look at
+	paceWindow = 20
+	// A longer gap is a pause, not play, and starts the window again
+	paceActiveGap = 30 * time.Second
+	// Gaps steadier than this (standard deviation over mean) are a timer
+	paceRegularCV = 0.03
+	// This many gaps shorter than the reels take to stop, or than anyone
+	// clicks in instant mode, are a script
+	paceTooFastGaps = 5
+	paceHumanGap    = 150 * time.Millisecond
+	// Answers quicker than this are a script, right or wrong
+	challengeMinSolve = time.Second
+	abuseLogSize      = 1000
+)
+
+func challengeError() *LimitError {
+	return &LimitError{Code: "challenge", Message: "Are you still there? Answer the challenge to keep playing."}
+}
+
+// PlayPace is how a player has been spinning, for the bot heuristics.
+type PlayPace struct {
+	last time.Time
+	gaps []time.Duration
+	// Times this player has been flagged
+	Flags int `json:"flags"`
+	// Set while the player has to answer a challenge before spinning again
+	Challenge *Challenge `json:"challenge,omitempty"`
+}
+
+// Challenge asks the player to tap one of a few chess pieces by name.
+// It is cosmetic, not a CAPTCHA: the name comes in plain text and the six
+// glyphs never change, so a script written for it passes every time. It
+// only stops a script that knows nothing but spinning, slows a flagged
+// player down by challengeMinSolve, and puts the flag and each answer in
+// the abuse log for an operator to look at. The rate limits are what
+// actually bound a bot.
+type Challenge struct {
+	Pieces []string `json:"pieces"`
+	Ask    string   `json:"ask"`
+	answer int
+	issued time.Time
+}
+
+var challengePieces = []struct{ glyph, name string }{
+	{"♔", "king"}, {"♕", "queen"}, {"♖", "rook"}, {"♗", "bishop"}, {"♘", "knight"}, {"♙", "pawn"},
+}
+
+func newChallenge(now time.Time) *Challenge {
+	order := make([]int, len(challengePieces))
+	for i := range order {
+		j := randIntn(i + 1)
+		order[i], order[j] = order[j], i
+	}
+	c := &Challenge{answer: randIntn(4), issued: now}
+	for _, i := range order[:4] {
+		c.Pieces = append(c.Pieces, challengePieces[i].glyph)
+	}
+	c.Ask = challengePieces[order[c.answer]].name
+	return c
+}
+
+// botReason names what about gaps looks automated, or is "".
+func botReason(gaps []time.Duration, fastest time.Duration) string {
+	tooFast := 0
+	for _, gap := range gaps {
+		if gap < fastest {
+			tooFast++
+		}
+	}
+	if tooFast >= paceTooFastGaps {
+		return "impossible_rate"
+	}
+	if len(gaps) < paceWindow {
+		return ""
+	}
+	var sum, sumSq float64
+	for _, gap := range gaps {
+		sum += gap.Seconds()
+		sumSq += gap.Seconds() * gap.Seconds()
+	}
+	mean := sum / float64(len(gaps))
+	stddev := math.Sqrt(math.Max(0, sumSq/float64(len(gaps))-mean*mean))
+	if stddev/mean < paceRegularCV {
+		return "regular_timing"
+	}
+	return ""
+}
+
+// admitSpin runs before each spin a player asks for, over REST or the
+// WebSocket: the rate limits per player and per IP, then the pace check.
+// Autoplay is paced by the server and skips it. Callers must hold
+// s.store.mu.
+func (s *server) admitSpin(p *Player, g *GameDefinition, ip string) error {
+	now := time.Now()
+	if err := s.rateLimit(p.ID, ip, now); err != nil {
+		return err
+	}
+	return s.checkPace(p, g, ip, now)
+}
+
+func (s *server) rateLimit(playerID, ip string, now time.Time) error {
+	limits := []struct {
+		scope, key string
+		limiter    *rateLimiter
+	}{{"player", playerID, s.playerLimit}, {"ip", ip, s.ipLimit}}
+	for _, l := range limits {
+		ok, wait, first := l.limiter.take(l.key, now)
+		if ok {
+			continue
+		}
+		metrics.rateLimited.add(1, l.scope)
+		if first {
+			s.abuse.record(AbuseEvent{Kind: "rate_limited", PlayerID: playerID, IP: ip, Reason: l.scope + " limit"})
+		}
+		return &RateLimitError{Scope: l.scope, RetryAfter: wait}
+	}
+	return nil
+}
+
+// checkPace records a spin and looks for play nobody could manage through
+// the page: gaps shorter than the reels take to stop, or gaps so even
+// they must come from a timer. Either sets a challenge the player has to
+// answer before spinning again.
+func (s *server) checkPace(p *Player, g *GameDefinition, ip string, now time.Time) error {
+	pace := &p.Pace
+	if pace.Challenge != nil {
+		return challengeError()
+	}
+	if !pace.last.IsZero() {
+		if gap := now.Sub(pace.last); gap > paceActiveGap {
+			pace.gaps = pace.gaps[:0]
+		} else {
+			pace.gaps = append(pace.gaps, gap)
+			if len(pace.gaps) > paceWindow {
+				pace.gaps = pace.gaps[len(pace.gaps)-paceWindow:]
+			}
+		}
+	}
+	pace.last = now
+	reason := botReason(pace.gaps, max(g.fastestSpin(), paceHumanGap))
+	if reason == "" {
+		return nil
+	}
+	pace.gaps = nil
+	pace.Flags++
+	pace.Challenge = newChallenge(now)
+	metrics.botFlags.add(1, reason)
+	s.abuse.record(AbuseEvent{Kind: "bot_flagged", PlayerID: p.ID, IP: ip, Reason: reason})
+	slog.Warn("player flagged as a possible bot", "player", p.ID, "ip", ip, "reason", reason)
+	return challengeError()
+}
+
+// handleChallenge shows the player's pending challenge (null when there
+// is none), or takes an answer: {"answer": index into pieces}. A wrong or
+// too quick answer gets a new challenge.
+func (s *server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	id := playerID(r)
+	var req struct {
+		Answer *int `json:"answer"`
+	}
+	if r.Method == http.MethodPost {
+		if err := decodeJSON(r, &req); err != nil || req.Answer == nil {
+			writeError(w, http.StatusBadRequest, `expected {"answer": 0-3}`)
+			return
+		}
+	}
+	var resp struct {
+		Passed    bool       `json:"passed"`
+		Challenge *Challenge `json:"challenge"`
+	}
+	s.store.Update(id, func(p *Player) error {
+		c := p.Pace.Challenge
+		if c == nil || req.Answer == nil {
+			resp.Challenge = c
+			return nil
+		}
+		now := time.Now()
+		event := AbuseEvent{Kind: "challenge_passed", PlayerID: p.ID, IP: clientIP(r)}
+		switch {
+		case now.Sub(c.issued) < challengeMinSolve:
+			event.Kind, event.Reason = "challenge_failed", "answered in "+now.Sub(c.issued).Round(time.Millisecond).String()
+		case *req.Answer != c.answer:
+			event.Kind, event.Reason = "challenge_failed", "wrong piece"
+		}
+		s.abuse.record(event)
+		if event.Kind == "challenge_failed" {
+			p.Pace.Challenge = newChallenge(now)
+			resp.Challenge = p.Pace.Challenge
+			return nil
+		}
+		p.Pace.Challenge = nil
+		p.Pace.last = time.Time{}
+		resp.Passed = true
+		return nil
+	})
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// AbuseEvent is a rate limit starting to refuse someone, a player being
+// flagged as a possible bot, or a challenge answered.
+type AbuseEvent struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	PlayerID string    `json:"playerId,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// AbuseLog keeps the latest abuse events in memory for the admin area.
+type AbuseLog struct {
+	mu     sync.Mutex
+	nextID int
+	events []AbuseEvent
+}
+
+func (l *AbuseLog) record(e AbuseEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	e.ID = l.nextID
+	e.Time = time.Now()
+	l.events = append(l.events, e)
+	if len(l.events) > abuseLogSize {
+		l.events = l.events[len(l.events)-abuseLogSize:]
+	}
+}
+
+// latest is up to limit events, newest first.
+func (l *AbuseLog) latest(limit int) []AbuseEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := make([]AbuseEvent, 0, min(limit, len(l.events)))
+	for i := len(l.events) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, l.events[i])
+	}
+	return events
+}
+
+// FlaggedPlayer is a player who has a challenge to answer.
+type FlaggedPlayer struct {
+	PlayerID string    `json:"playerId"`
+	Flags    int       `json:"flags"`
+	Since    time.Time `json:"since"`
+}
+
+// handleAdminAbuse lists the abuse events, newest first, and the players
+// who still have a challenge to answer.
+func (s *server) handleAdminAbuse(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > abuseLogSize {
+		limit = 100
+	}
+	flagged := []FlaggedPlayer{}
+	s.store.read(func() {
+		for _, p := range s.store.players {
+			if c := p.Pace.Challenge; c != nil {
+				flagged = append(flagged, FlaggedPlayer{PlayerID: p.ID, Flags: p.Pace.Flags, Since: c.issued})
+			}
+		}
+	})
+	sort.Slice(flagged, func(i, j int) bool { return flagged[i].Since.After(flagged[j].Since) })
+	writeJSON(w, http.StatusOK, map[string]any{"events": s.abuse.latest(limit), "flagged": flagged})
+}
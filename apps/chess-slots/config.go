@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Config is every setting the server reads at startup. Each comes from,
+// in increasing priority: its default, the config file, its environment
+// variable, then its command-line flag.
+type Config struct {
+	Port     string
+	BasePath string
+	// Comma-separated game definition paths; empty for the embedded games
+	Games string
+	// How often to check those files for a new paytable; 0 turns it off
+	GamesWatch time.Duration
+	// Where players, rounds and the ledger live: "memory", or
+	// "file:<path>" for a JSON snapshot saved every few seconds
+	Storage    string
+	AdminToken string
+	// Proxies in front that each append to X-Forwarded-For; 0 ignores
+	// the header and takes the connection's address
+	TrustedProxies int
+	LogLevel       slog.Level
+	Timeouts       serverTimeouts
+	// Feature name -> on; see featureNames
+	Features  map[string]bool
+	RateLimit RateLimitConfig
+}
+
+// RateLimitConfig bounds how fast spins may come in, per player and per
+// client IP. Rates are spins per second; bursts are how many may come at
+// once after a pause.
+type RateLimitConfig struct {
+	PlayerRate  float64
+	PlayerBurst int
+	IPRate      float64
+	IPBurst     int
+}
+
+// featureNames are the features that can be turned off. Turned off, their
+// routes answer 503 and the page hides them; with "bonus" or "freespins"
+// off, scatters no longer award them, and with "jackpot" off spins
+// neither fund nor win the pool.
+var featureNames = []string{"autoplay", "bonus", "duels", "freespins", "gamble", "jackpot", "rewards", "spectate", "tournaments", "websocket"}
+
+func defaultConfig() *Config {
+	c := &Config{
+		Port:       "8080",
+		BasePath:   defaultBasePath,
+		GamesWatch: 5 * time.Second,
+		Storage:    "memory",
+		LogLevel:   slog.LevelInfo,
+		Timeouts:   defaultTimeouts,
+		Features:   map[string]bool{},
+		RateLimit:  RateLimitConfig{PlayerRate: 5, PlayerBurst: 10, IPRate: 20, IPBurst: 40},
+	}
+	for _, name := range featureNames {
+		c.Features[name] = true
+	}
+	return c
+}
+
+// setting is one configurable value: its key in the config file (dotted
+// for nested objects), its environment variable and its flag. Secrets
+// have no flag, since flags show up in the process list.
+type setting struct {
+	key, env, flag string
+	help           string
+	secret         bool
+	value          flag.Value
+	source         string
+}
+
+func (c *Config) settings() []*setting {
+	s := []*setting{
+		{key: "port", env: "PORT", flag: "port", help: "HTTP port", value: (*stringValue)(&c.Port)},
+		{key: "basePath", env: "BASE_PATH", flag: "base-path", help: "path every page and API route is under (empty for the root)", value: (*stringValue)(&c.BasePath)},
+		{key: "games", env: "GAME_DEFINITION", flag: "games", help: "comma-separated game definition files (default: the embedded games)", value: (*stringValue)(&c.Games)},
+		{key: "gamesWatch", env: "GAME_WATCH_INTERVAL", flag: "games-watch", help: "how often to check game files for a new paytable (0 turns it off)", value: (*durationValue)(&c.GamesWatch)},
+		{key: "storage", env: "STORAGE_DSN", flag: "storage", help: "storage for players and the ledger: memory or file:<path>", value: (*stringValue)(&c.Storage)},
+		{key: "trustedProxies", env: "TRUSTED_PROXIES", flag: "trusted-proxies", help: "proxies in front that append to X-Forwarded-For (0 ignores the header)", value: (*intValue)(&c.TrustedProxies)},
+		{key: "adminToken", env: "ADMIN_TOKEN", help: "bearer token for the admin API (unset turns it off)", secret: true, value: (*stringValue)(&c.AdminToken)},
+		{key: "logLevel", env: "LOG_LEVEL", flag: "log-level", help: "debug, info, warn or error", value: (*levelValue)(&c.LogLevel)},
+		{key: "http.readHeaderTimeout", env: "HTTP_READ_HEADER_TIMEOUT", flag: "read-header-timeout", help: "time to read request headers", value: (*durationValue)(&c.Timeouts.ReadHeader)},
+		{key: "http.readTimeout", env: "HTTP_READ_TIMEOUT", flag: "read-timeout", help: "time to read a whole request", value: (*durationValue)(&c.Timeouts.Read)},
+		{key: "http.writeTimeout", env: "HTTP_WRITE_TIMEOUT", flag: "write-timeout", help: "time to write a response (streams are exempt)", value: (*durationValue)(&c.Timeouts.Write)},
+		{key: "http.idleTimeout", env: "HTTP_IDLE_TIMEOUT", flag: "idle-timeout", help: "keep-alive time between requests", value: (*durationValue)(&c.Timeouts.Idle)},
+		{key: "http.shutdownTimeout", env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", help: "time to drain after SIGTERM", value: (*durationValue)(&c.Timeouts.Shutdown)},
+		{key: "rateLimit.playerRate", env: "RATE_LIMIT_PLAYER_RATE", flag: "player-rate", help: "spins per second per player", value: (*floatValue)(&c.RateLimit.PlayerRate)},
+		{key: "rateLimit.playerBurst", env: "RATE_LIMIT_PLAYER_BURST", flag: "player-burst", help: "spins a player may make at once", value: (*intValue)(&c.RateLimit.PlayerBurst)},
+		{key: "rateLimit.ipRate", env: "RATE_LIMIT_IP_RATE", flag: "ip-rate", help: "spins per second per client IP", value: (*floatValue)(&c.RateLimit.IPRate)},
+		{key: "rateLimit.ipBurst", env: "RATE_LIMIT_IP_BURST", flag: "ip-burst", help: "spins one IP may make at once", value: (*intValue)(&c.RateLimit.IPBurst)},
+	}
+	for _, name := range featureNames {
+		s = append(s, &setting{
+			key:   "features." + name,
+			env:   "FEATURE_" + strings.ToUpper(name),
+			flag:  "feature-" + name,
+			help:  "turn " + name + " on or off",
+			value: &featureValue{features: c.Features, name: name},
+		})
+	}
+	for _, st := range s {
+		st.source = "default"
+	}
+	return s
+}
+
+// loadConfig builds the configuration from args (without the program
+// name), the environment and the file named by -config or CONFIG_FILE.
+func loadConfig(args []string) (*Config, []*setting, error) {
+	c := defaultConfig()
+	settings := c.settings()
+
+	fs := flag.NewFlagSet("chess-slots", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON config file")
+	// Flags win over everything, so hold on to them until the rest is read
+	fromFlags := map[*setting]string{}
+	for _, st := range settings {
+		if st.flag == "" {
+			continue
+		}
+		fs.Var(&pendingFlag{st: st, set: fromFlags}, st.flag, st.help)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "Usage: chess-slots [flags]\n       chess-slots config print [flags]")
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("config: %w (see -h)", err)
+	}
+	if fs.NArg() > 0 {
+		return nil, nil, fmt.Errorf("config: unexpected argument %q", fs.Arg(0))
+	}
+
+	var errs []error
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: %w", err)
+		}
+		for _, st := range settings {
+			if v, ok := values[st.key]; ok {
+				delete(values, st.key)
+				errs = append(errs, st.apply(v, "file "+*configFile))
+			}
+		}
+		for _, key := range sortedKeys(values) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting in %s", key, *configFile))
+		}
+	}
+	for _, st := range settings {
+		// LookupEnv, so BASE_PATH= can set an empty base path
+		if v, ok := os.LookupEnv(st.env); ok {
+			errs = append(errs, st.apply(v, "env "+st.env))
+		}
+	}
+	for _, st := range settings {
+		if v, ok := fromFlags[st]; ok {
+			errs = append(errs, st.apply(v, "flag -"+st.flag))
+		}
+	}
+	errs = append(errs, c.validate()...)
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return c, settings, nil
+}
+
+func (st *setting) apply(v, source string) error {
+	if err := st.value.Set(v); err != nil {
+		return fmt.Errorf("%s (from %s): %w", st.key, source, err)
+	}
+	st.source = source
+	return nil
+}
+
+// readConfigFile flattens a JSON object into dotted keys with string
+// values, so {"http": {"readTimeout": "30s"}} sets http.readTimeout.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var root map[string]any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := map[string]string{}
+	var flatten func(prefix string, m map[string]any)
+	flatten = func(prefix string, m map[string]any) {
+		for k, v := range m {
+			switch v := v.(type) {
+			case map[string]any:
+				flatten(prefix+k+".", v)
+			case float64:
+				values[prefix+k] = strconv.FormatFloat(v, 'f', -1, 64)
+			case nil:
+				values[prefix+k] = ""
+			default:
+				values[prefix+k] = fmt.Sprint(v)
+			}
+		}
+	}
+	flatten("", root)
+	return values, nil
+}
+
+// validate checks the values together and cleans up the base path.
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		fail("port", "want a port number from 1 to 65535, got %q", c.Port)
+	}
+	c.BasePath = cleanBasePath(c.BasePath)
+	if strings.ContainsAny(c.BasePath, " {}?#%") {
+		fail("basePath", "%q may not contain spaces, braces, ?, # or %%", c.BasePath)
+	}
+	for _, path := range strings.Split(c.Games, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail("games", "%v", err)
+		}
+	}
+	if c.GamesWatch < 0 {
+		fail("gamesWatch", "must be 0 (off) or positive")
+	}
+	if path := storeFile(c.Storage); path != "" {
+		if err := checkStoreDir(path); err != nil {
+			fail("storage", "%v", err)
+		}
+	} else if c.Storage != "memory" {
+		fail("storage", "want memory or file:<path>, got %q", c.Storage)
+	}
+	if c.TrustedProxies < 0 {
+		fail("trustedProxies", "must be 0 (none) or positive")
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		fail("adminToken", "must be at least 16 characters")
+	}
+	t := c.Timeouts
+	for key, d := range map[string]time.Duration{
+		"http.readHeaderTimeout": t.ReadHeader, "http.readTimeout": t.Read,
+		"http.idleTimeout": t.Idle, "http.shutdownTimeout": t.Shutdown,
+	} {
+		if d <= 0 {
+			fail(key, "must be positive")
+		}
+	}
+	if t.Write < 0 {
+		fail("http.writeTimeout", "must be 0 (none) or positive")
+	}
+	rl := c.RateLimit
+	if rl.PlayerRate <= 0 || rl.IPRate <= 0 {
+		fail("rateLimit", "rates must be positive")
+	}
+	if rl.PlayerBurst < 1 || rl.IPBurst < 1 {
+		fail("rateLimit", "bursts must be at least 1")
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+// printConfig writes every setting's effective value and where it came
+// from. Secrets only say whether they are set.
+func printConfig(w io.Writer, settings []*setting) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE\tENV\tFLAG")
+	for _, st := range settings {
+		v := strconv.Quote(st.value.String())
+		if st.secret {
+			v = "(unset)"
+			if st.value.String() != "" {
+				v = "(set, hidden)"
+			}
+		}
+		fl := "-"
+		if st.flag != "" {
+			fl = "-" + st.flag
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", st.key, v, st.source, st.env, fl)
+	}
+	tw.Flush()
+}
+
+// runConfigCommand handles "chess-slots config print [flags]".
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: chess-slots config print [flags]")
+		return 2
+	}
+	_, settings, err := loadConfig(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	printConfig(os.Stdout, settings)
+	return 0
+}
+
+// pendingFlag records a flag's raw value for loadConfig to apply last.
+type pendingFlag struct {
+	st  *setting
+	set map[*setting]string
+}
+
+func (f *pendingFlag) String() string {
+	if f.st == nil {
+		return ""
+	}
+	return f.st.value.String()
+}
+
+func (f *pendingFlag) Set(v string) error {
+	// Parse now so a bad flag is reported by name
+	if err := f.st.value.Set(v); err != nil {
+		return err
+	}
+	f.set[f.st] = v
+	return nil
+}
+
+func (f *pendingFlag) IsBoolFlag() bool {
+	b, ok := f.st.value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("want a whole number, got %q", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type floatValue float64
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'f', -1, 64) }
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("want a number, got %q", s)
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("want a duration such as 30s, got %q", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+type levelValue slog.Level
+
+func (v *levelValue) String() string { return strings.ToLower(slog.Level(*v).String()) }
+func (v *levelValue) Set(s string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return fmt.Errorf("want debug, info, warn or error, got %q", s)
+	}
+	*v = levelValue(l)
+	return nil
+}
+
+type featureValue struct {
+	features map[string]bool
+	name     string
+}
+
+func (v *featureValue) String() string   { return strconv.FormatBool(v.features[v.name]) }
+func (v *featureValue) IsBoolFlag() bool { return true }
+func (v *featureValue) Set(s string) error {
+	on, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("want true or false, got %q", s)
+	}
+	v.features[v.name] = on
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// unsetEnv clears an environment variable for the length of the test.
+func unsetEnv(t *testing.T, key string) {
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		env        string
+		flag       string
+		want       string
+		wantSource string
+	}{
+		{name: "default", want: "8080", wantSource: "default"},
+		{name: "file over default", file: "9001", want: "9001", wantSource: "file"},
+		{name: "env over file", file: "9001", env: "9002", want: "9002", wantSource: "env PORT"},
+		{name: "flag over env", file: "9001", env: "9002", flag: "9003", want: "9003", wantSource: "flag -port"},
+		{name: "flag over file", file: "9001", flag: "9003", want: "9003", wantSource: "flag -port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "PORT")
+			unsetEnv(t, "CONFIG_FILE")
+			unsetEnv(t, "HTTP_READ_TIMEOUT")
+			var args []string
+			path := filepath.Join(t.TempDir(), "config.json")
+			if tt.file != "" {
+				data := `{"port": "` + tt.file + `", "http": {"readTimeout": "5s"}}`
+				if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "-config", path)
+			}
+			if tt.env != "" {
+				t.Setenv("PORT", tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "-port", tt.flag)
+			}
+			if tt.wantSource == "file" {
+				tt.wantSource = "file " + path
+			}
+
+			cfg, settings, err := loadConfig(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Port != tt.want {
+				t.Errorf("port = %q, want %q", cfg.Port, tt.want)
+			}
+			for _, st := range settings {
+				if st.key == "port" && st.source != tt.wantSource {
+					t.Errorf("port source = %q, want %q", st.source, tt.wantSource)
+				}
+			}
+			// Nested keys in the file are read too
+			if tt.file != "" && cfg.Timeouts.Read != 5*time.Second {
+				t.Errorf("http.readTimeout = %v, want 5s from the file", cfg.Timeouts.Read)
+			}
+		})
+	}
+}
+
+func TestLoadConfigStorage(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		storage string
+		ok      bool
+	}{
+		{name: "memory", storage: "memory", ok: true},
+		{name: "file not yet saved", storage: "file:" + filepath.Join(dir, "store.json"), ok: true},
+		{name: "missing directory", storage: "file:" + filepath.Join(dir, "missing", "store.json")},
+		{name: "no path", storage: "file:"},
+		{name: "unknown backend", storage: "postgres://localhost/slots"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "STORAGE_DSN")
+			unsetEnv(t, "CONFIG_FILE")
+			_, _, err := loadConfig([]string{"-storage", tt.storage})
+			if (err == nil) != tt.ok {
+				t.Errorf("got error %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+	// Checking the directory leaves nothing behind in it
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left in the store directory", len(entries))
+	}
+}
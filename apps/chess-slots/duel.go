@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	errDuelsOff       = errors.New("duels are not available")
+	errInDuel         = errors.New("you are already in a duel")
+	errNoDuel         = errors.New("you are not in a duel")
+	errDuelWaiting    = errors.New("still waiting for an opponent")
+	errDuelStarted    = errors.New("the duel has already started")
+	errDuelSpinsSpent = errors.New("you have used all your duel spins")
+)
+
+// DuelPolicy configures head-to-head duels. Both players pay BuyIn into
+// the pot, then each gets Spins spins at the normal spin cost; the higher
+// total win takes the pot. Zero Spins turns duels off.
+type DuelPolicy struct {
+	Spins int `json:"spins"`
+	BuyIn int `json:"buyIn"`
+	// A player who doesn't spin for this long abandons and loses
+	IdleTimeoutSeconds int `json:"idleTimeoutSeconds"`
+	// Waiting for an opponent gives up (and refunds) after this long
+	QueueTimeoutSeconds int `json:"queueTimeoutSeconds"`
+}
+
+func (d DuelPolicy) validate() error {
+	if d.Spins == 0 {
+		return nil
+	}
+	if d.Spins < 0 || d.BuyIn <= 0 || d.IdleTimeoutSeconds <= 0 || d.QueueTimeoutSeconds <= 0 {
+		return errors.New("spins, buyIn and both timeouts must be positive")
+	}
+	return nil
+}
+
+type DuelSide struct {
+	PlayerID   string
+	SpinsUsed  int
+	Total      int
+	LastActive time.Time
+	LastSpin   *SpinResult
+	Abandoned  bool
+}
+
+// Duel moves from "waiting" (queued for an opponent) to "active" and
+// ends "finished", or "cancelled" if nobody turned up.
+type Duel struct {
+	ID        string
+	Status    string
+	Sides     [2]*DuelSide // Sides[1] is nil while waiting
+	CreatedAt time.Time
+	// Winner is a player ID, or empty for a draw (both refunded)
+	Winner string
+}
+
+func (d *Duel) side(playerID string) (you, opponent *DuelSide) {
+	if d.Sides[0].PlayerID == playerID {
+		return d.Sides[0], d.Sides[1]
+	}
+	return d.Sides[1], d.Sides[0]
+}
+
+// queueDuel takes p's buy-in and pairs them with the player already
+// waiting, or makes them the one waiting. Callers must hold s.mu.
+func (s *Store) queueDuel(p *Player, now time.Time) error {
+	pol := s.game.Duel
+	if pol.Spins == 0 {
+		return errDuelsOff
+	}
+	if p.Duel != nil && (p.Duel.Status == "waiting" || p.Duel.Status == "active") {
+		return errInDuel
+	}
+	if p.Balance < pol.BuyIn {
+		return errInsufficientFunds
+	}
+	if err := p.Safety.checkBet(pol.BuyIn, now); err != nil {
+		return err
+	}
+
+	// The buy-in may come out of the win on offer, so the offer goes
+	p.Gamble = nil
+	side := &DuelSide{PlayerID: p.ID, LastActive: now}
+	if d := s.duelWaiting; d != nil {
+		s.post(p, "duel_buyin", -pol.BuyIn, d.ID)
+		d.Sides[1] = side
+		d.Sides[0].LastActive = now
+		d.Status = "active"
+		s.duelWaiting = nil
+		s.duels[d.ID] = d
+		p.Duel = d
+		s.publishDuel(d)
+		return nil
+	}
+	d := &Duel{ID: newID(), Status: "waiting", Sides: [2]*DuelSide{side}, CreatedAt: now}
+	s.post(p, "duel_buyin", -pol.BuyIn, d.ID)
+	s.duelWaiting = d
+	p.Duel = d
+	return nil
+}
+
+// cancelDuel takes p out of the queue and refunds the buy-in. Callers must
+// hold s.mu.
+func (s *Store) cancelDuel(p *Player, now time.Time) error {
+	d := p.Duel
+	if d == nil || d.Status != "waiting" {
+		if d != nil && d.Status == "active" {
+			return errDuelStarted
+		}
+		return errNoDuel
+	}
+	s.post(p, "duel_refund", s.game.Duel.BuyIn, d.ID)
+	d.Status = "cancelled"
+	s.duelWaiting = nil
+	s.publishDuel(d)
+	return nil
+}
+
+// duelSpin plays one of p's duel spins. The buy-in already paid for them;
+// the win only scores. Scatters don't start the pick bonus in a duel.
+// Callers must hold s.mu.
+func (s *Store) duelSpin(p *Player, now time.Time) (Round, error) {
+	d := p.Duel
+	switch {
+	case d == nil || d.Status == "finished" || d.Status == "cancelled":
+		return Round{}, errNoDuel
+	case d.Status == "waiting":
+		return Round{}, errDuelWaiting
+	}
+	you, _ := d.side(p.ID)
+	if you.SpinsUsed >= s.game.Duel.Spins {
+		return Round{}, errDuelSpinsSpent
+	}
+	if err := p.Safety.checkBet(0, now); err != nil {
+		return Round{}, err
+	}
+
+	round := Round{ID: newID(), PlayerID: p.ID, GameID: s.game.ID, DuelID: d.ID, Time: now, Bet: s.game.SpinCost}
+	round.Result = s.game.spin()
+	round.Result.BonusTriggered = false
+	round.Win = round.Result.Payout
+	s.rounds = keepLast(append(s.rounds, round), roundsKept)
+	metrics.recordSpin(s.game.ID, "duel", round.Bet, round.Win)
+
+	you.SpinsUsed++
+	you.Total += round.Win
+	you.LastActive = now
+	you.LastSpin = &round.Result
+	if d.Sides[0].SpinsUsed == s.game.Duel.Spins && d.Sides[1].SpinsUsed == s.game.Duel.Spins {
+		s.settleDuel(d, now)
+	}
+	s.publishDuel(d)
+	return round, nil
+}
+
+// tickDuels gives up on a lonely queue and forfeits idle players. Callers
+// must hold s.mu.
+func (s *Store) tickDuels(now time.Time) {
+	pol := s.game.Duel
+	if d := s.duelWaiting; d != nil && now.Sub(d.CreatedAt) >= time.Duration(pol.QueueTimeoutSeconds)*time.Second {
+		if p, ok := s.players[d.Sides[0].PlayerID]; ok {
+			s.cancelDuel(p, now)
+		}
+	}
+	idle := time.Duration(pol.IdleTimeoutSeconds) * time.Second
+	for _, d := range s.duels {
+		abandoned := false
+		for _, side := range d.Sides {
+			if side.SpinsUsed < pol.Spins && now.Sub(side.LastActive) >= idle {
+				side.Abandoned = true
+				abandoned = true
+			}
+		}
+		if abandoned {
+			s.settleDuel(d, now)
+			s.publishDuel(d)
+		}
+	}
+}
+
+// settleDuel pays the pot to the winner, or refunds both buy-ins on a draw
+// or when both players walked away. Callers must hold s.mu.
+func (s *Store) settleDuel(d *Duel, now time.Time) {
+	a, b := d.Sides[0], d.Sides[1]
+	switch {
+	case a.Abandoned && b.Abandoned:
+	case a.Abandoned:
+		d.Winner = b.PlayerID
+	case b.Abandoned:
+		d.Winner = a.PlayerID
+	case a.Total > b.Total:
+		d.Winner = a.PlayerID
+	case b.Total > a.Total:
+		d.Winner = b.PlayerID
+	}
+	buyIn := s.game.Duel.BuyIn
+	for _, side := range d.Sides {
+		p, ok := s.players[side.PlayerID]
+		switch {
+		case !ok:
+		case d.Winner == "":
+			s.post(p, "duel_refund", buyIn, d.ID)
+		case d.Winner == side.PlayerID:
+			s.post(p, "duel_pot", 2*buyIn, d.ID)
+		}
+	}
+	d.Status = "finished"
+	delete(s.duels, d.ID)
+}
+
+type DuelSideView struct {
+	Name      string      `json:"name"`
+	SpinsLeft int         `json:"spinsLeft"`
+	Total     int         `json:"total"`
+	Abandoned bool        `json:"abandoned,omitempty"`
+	LastSpin  *SpinResult `json:"lastSpin"`
+}
+
+type DuelView struct {
+	ID       string        `json:"id"`
+	Status   string        `json:"status"`
+	Spins    int           `json:"spins"`
+	BuyIn    int           `json:"buyIn"`
+	Pot      int           `json:"pot"`
+	You      DuelSideView  `json:"you"`
+	Opponent *DuelSideView `json:"opponent"`
+	// When the queue gives up, or when you forfeit if you don't spin
+	Deadline *time.Time `json:"deadline,omitempty"`
+	Result   string     `json:"result,omitempty"` // won, lost or draw
+}
+
+func (s *Store) duelView(d *Duel, playerID string) *DuelView {
+	if d == nil {
+		return nil
+	}
+	pol := s.game.Duel
+	sideView := func(side *DuelSide) DuelSideView {
+		return DuelSideView{
+			Name:      displayName(side.PlayerID),
+			SpinsLeft: pol.Spins - side.SpinsUsed,
+			Total:     side.Total,
+			Abandoned: side.Abandoned,
+			LastSpin:  side.LastSpin,
+		}
+	}
+	you, opponent := d.side(playerID)
+	v := &DuelView{ID: d.ID, Status: d.Status, Spins: pol.Spins, BuyIn: pol.BuyIn, Pot: pol.BuyIn, You: sideView(you)}
+	switch d.Status {
+	case "waiting":
+		deadline := d.CreatedAt.Add(time.Duration(pol.QueueTimeoutSeconds) * time.Second)
+		v.Deadline = &deadline
+	case "active":
+		if you.SpinsUsed < pol.Spins {
+			deadline := you.LastActive.Add(time.Duration(pol.IdleTimeoutSeconds) * time.Second)
+			v.Deadline = &deadline
+		}
+	case "finished":
+		switch d.Winner {
+		case "":
+			v.Result = "draw"
+		case playerID:
+			v.Result = "won"
+		default:
+			v.Result = "lost"
+		}
+	}
+	if opponent != nil {
+		o := sideView(opponent)
+		v.Opponent = &o
+		v.Pot = 2 * pol.BuyIn
+	}
+	return v
+}
+
+// publishDuel sends each player their own view of the duel, so both see
+// the other's reels as they spin. Callers must hold s.mu.
+func (s *Store) publishDuel(d *Duel) {
+	for _, side := range d.Sides {
+		if side != nil {
+			s.events.publishTo(side.PlayerID, "duel", s.duelView(d, side.PlayerID))
+		}
+	}
+}
+
+func (s *server) handleDuel(w http.ResponseWriter, r *http.Request) {
+	var view *DuelView
+	var err error
+	update := func(fn func(p *Player, now time.Time) error) {
+		err = s.store.Update(playerID(r), func(p *Player) error {
+			if err := fn(p, time.Now()); err != nil {
+				return err
+			}
+			view = s.store.duelView(p.Duel, p.ID)
+			return nil
+		})
+	}
+	switch r.Method {
+	case http.MethodGet:
+		update(func(p *Player, now time.Time) error { return nil })
+	case http.MethodPost:
+		update(s.store.queueDuel)
+	case http.MethodDelete:
+		update(s.store.cancelDuel)
+	}
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"duel": view})
+}
+
+type duelSpinResponse struct {
+	spinResponse
+	Duel *DuelView `json:"duel"`
+}
+
+func (s *server) handleDuelSpin(w http.ResponseWriter, r *http.Request) {
+	var resp duelSpinResponse
+	err := s.store.Update(playerID(r), func(p *Player) error {
+		if err := s.admitSpin(p, s.store.game, clientIP(r)); err != nil {
+			return err
+		}
+		round, err := s.store.duelSpin(p, time.Now())
+		if err != nil {
+			return err
+		}
+		resp = duelSpinResponse{
+			spinResponse: spinResponse{RoundID: round.ID, SpinResult: round.Result, Balance: p.Balance},
+			Duel:         s.store.duelView(p.Duel, p.ID),
+		}
+		return nil
+	})
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
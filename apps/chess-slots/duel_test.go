@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettleDuel(t *testing.T) {
+	tests := []struct {
+		name         string
+		totals       [2]int
+		abandoned    [2]bool
+		winner       string
+		aGain, bGain int // against the balance before the buy-ins
+	}{
+		{name: "a wins", totals: [2]int{50, 20}, winner: "a", aGain: 1, bGain: -1},
+		{name: "b wins", totals: [2]int{0, 5}, winner: "b", aGain: -1, bGain: 1},
+		{name: "draw", totals: [2]int{30, 30}},
+		{name: "a walked away", totals: [2]int{90, 0}, abandoned: [2]bool{true, false}, winner: "b", aGain: -1, bGain: 1},
+		{name: "both walked away", totals: [2]int{90, 0}, abandoned: [2]bool{true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			buyIn := s.game.Duel.BuyIn
+			now := time.Now()
+			s.createPlayer("a")
+			s.createPlayer("b")
+			a, b := s.players["a"], s.players["b"]
+			before := a.Balance
+			for _, p := range []*Player{a, b} {
+				if err := s.queueDuel(p, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d := a.Duel
+			if d.Status != "active" || b.Duel != d {
+				t.Fatalf("duel = %+v, want both players in one active duel", d)
+			}
+			for i, side := range d.Sides {
+				side.Total = tt.totals[i]
+				side.Abandoned = tt.abandoned[i]
+			}
+
+			s.settleDuel(d, now)
+			if d.Status != "finished" || d.Winner != tt.winner || s.duels[d.ID] != nil {
+				t.Errorf("duel ended %s with winner %q, want finished with %q", d.Status, d.Winner, tt.winner)
+			}
+			if got, want := a.Balance, before+tt.aGain*buyIn; got != want {
+				t.Errorf("a's balance = %d, want %d", got, want)
+			}
+			if got, want := b.Balance, before+tt.bGain*buyIn; got != want {
+				t.Errorf("b's balance = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestDuelSpinSettles(t *testing.T) {
+	s := newTestStore(t)
+	pol := s.game.Duel
+	now := time.Now()
+	s.createPlayer("a")
+	s.createPlayer("b")
+	a, b := s.players["a"], s.players["b"]
+	for _, p := range []*Player{a, b} {
+		if err := s.queueDuel(p, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := a.Duel
+	before := a.Balance + b.Balance
+	for i := 0; i < pol.Spins; i++ {
+		for _, p := range []*Player{a, b} {
+			if _, err := s.duelSpin(p, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if d.Status != "finished" {
+		t.Fatalf("status after every spin = %q, want finished", d.Status)
+	}
+	// The pot goes back out whole, to one player or split
+	if a.Balance+b.Balance != before+2*pol.BuyIn {
+		t.Errorf("balances %d + %d, want %d between them", a.Balance, b.Balance, before+2*pol.BuyIn)
+	}
+	if _, err := s.duelSpin(a, now); err != errNoDuel {
+		t.Errorf("spin after the end: got %v, want %v", err, errNoDuel)
+	}
+}
+
+func TestTickDuels(t *testing.T) {
+	pol := DuelPolicy{Spins: 3, BuyIn: 50, IdleTimeoutSeconds: 60, QueueTimeoutSeconds: 30}
+	tests := []struct {
+		name   string
+		after  time.Duration
+		paired bool
+		status string
+	}{
+		{name: "queue still waiting", after: 10 * time.Second, status: "waiting"},
+		{name: "queue gives up", after: 30 * time.Second, status: "cancelled"},
+		{name: "active, not idle yet", after: 59 * time.Second, paired: true, status: "active"},
+		{name: "both idle", after: time.Minute, paired: true, status: "finished"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.game.Duel = pol
+			now := time.Now()
+			s.createPlayer("a")
+			s.createPlayer("b")
+			a := s.players["a"]
+			before := a.Balance
+			if err := s.queueDuel(a, now); err != nil {
+				t.Fatal(err)
+			}
+			if tt.paired {
+				if err := s.queueDuel(s.players["b"], now); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s.tickDuels(now.Add(tt.after))
+			if a.Duel.Status != tt.status {
+				t.Errorf("status = %q, want %q", a.Duel.Status, tt.status)
+			}
+			// Giving up on the queue, and both walking away, refund the buy-in
+			if tt.status == "cancelled" || tt.status == "finished" {
+				if a.Balance != before {
+					t.Errorf("balance = %d, want %d back", a.Balance, before)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Events a client can fall behind by before it is dropped; the browser
+	// reconnects and catches up from the replay buffer.
+	eventClientBuffer = 64
+	// Recent events kept for new and reconnecting clients
+	eventReplaySize  = 100
+	eventHeartbeat   = 15 * time.Second
+	defaultBigWinMul = 20
+)
+
+// Event is one message on the live stream.
+type Event struct {
+	ID int
+	// "big_win", "jackpot", "tournament", "announcement" or "duel";
+	// spectators get "snapshot", "round", "bonus" and "ended"
+	Type string
+	Data []byte
+	// Only this audience gets the event: a player ID, or a spectator
+	// link's audience. Empty means everyone.
+	To string
+}
+
+// Hub fans events out to every connected page. Publishing never blocks:
+// a client whose buffer is full is disconnected instead.
+type Hub struct {
+	mu      sync.Mutex
+	nextID  int
+	clients map[chan Event]string // client -> audience
+	recent  []Event
+	closed  bool
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: map[chan Event]string{}}
+}
+
+func (h *Hub) publish(typ string, v any) {
+	h.publishTo("", typ, v)
+}
+
+// publishTo sends an event to one audience, or to everyone when audience
+// is empty.
+func (h *Hub) publishTo(audience, typ string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	ev := Event{ID: h.nextID, Type: typ, Data: data, To: audience}
+	h.recent = append(h.recent, ev)
+	if len(h.recent) > eventReplaySize {
+		h.recent = h.recent[len(h.recent)-eventReplaySize:]
+	}
+	h.send(ev)
+}
+
+// publishLive sends everyone a current value, such as the jackpot, that
+// is not kept for replay: a reconnecting page gets it afresh instead, so
+// frequent updates don't push big wins out of the buffer.
+func (h *Hub) publishLive(typ string, v any) {
+	ev := liveEvent(typ, v)
+	if ev == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.send(*ev)
+}
+
+// liveEvent is an event outside the replay buffer (ID 0), or nil if v
+// can't be encoded.
+func liveEvent(typ string, v any) *Event {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return &Event{Type: typ, Data: data}
+}
+
+// send fans ev out to its audience. Callers must hold h.mu.
+func (h *Hub) send(ev Event) {
+	for c, id := range h.clients {
+		if ev.To != "" && ev.To != id {
+			continue
+		}
+		select {
+		case c <- ev:
+		default:
+			delete(h.clients, c)
+			close(c)
+		}
+	}
+}
+
+// subscribe registers a client and returns the buffered events after
+// lastID it missed. A new client (lastID 0) only gets the recent big wins
+// so its ticker isn't empty.
+func (h *Hub) subscribe(audience string, lastID int) (chan Event, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := make(chan Event, eventClientBuffer)
+	if h.closed {
+		close(c)
+		return c, nil
+	}
+	h.clients[c] = audience
+	var missed []Event
+	for _, ev := range h.recent {
+		if ev.To != "" && ev.To != audience {
+			continue
+		}
+		if ev.ID > lastID && (lastID > 0 || ev.Type == "big_win") {
+			missed = append(missed, ev)
+		}
+	}
+	return c, missed
+}
+
+// count is how many clients are listening to audience.
+func (h *Hub) count(audience string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, a := range h.clients {
+		if a == audience {
+			n++
+		}
+	}
+	return n
+}
+
+// close ends every stream, as if each client were dropped, and refuses
+// new ones. Browsers reconnect to another instance.
+func (h *Hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+func (h *Hub) unsubscribe(c chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+type BigWinEvent struct {
+	Name       string  `json:"name"`
+	Game       string  `json:"game,omitempty"`
+	Symbol     string  `json:"symbol,omitempty"`
+	MatchCount int     `json:"matchCount,omitempty"`
+	Win        int     `json:"win"`
+	Multiplier float64 `json:"multiplier"`
+	Bonus      bool    `json:"bonus,omitempty"`
+	// The spin won the progressive pool
+	Jackpot bool `json:"jackpot,omitempty"`
+	// The progressive pool it won, besides Win
+	Pool int `json:"pool,omitempty"`
+}
+
+// publishBigWin announces a win of at least the game's big win multiplier,
+// and every jackpot.
+func (s *Store) publishBigWin(playerID string, bet int, ev BigWinEvent) {
+	ev.Name = displayName(playerID)
+	ev.Multiplier = float64(ev.Win) / float64(bet)
+	mul := s.game.BigWinMultiplier
+	if mul == 0 {
+		mul = defaultBigWinMul
+	}
+	if ev.Jackpot || ev.Win >= bet*mul {
+		s.events.publish("big_win", ev)
+	}
+}
+
+// publishTournament sends a tournament's top places to every page. Callers
+// must hold s.mu.
+func (s *Store) publishTournament(t *Tournament, now time.Time) {
+	s.events.publish("tournament", t.view("", 5, now))
+}
+
+func (s *Store) announce(message string) {
+	s.events.publish("announcement", map[string]string{"message": message})
+}
+
+// handleEvents streams events to the page over Server-Sent Events. A
+// reconnecting browser sends Last-Event-ID and gets what it missed.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s.streamEvents(w, r, playerID(r), s.jackpotHello())
+}
+
+// streamEvents sends audience's events until the client goes away, is
+// dropped as a slow consumer or gets an "ended" event. hello, if set, is
+// sent first.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request, audience string, hello *Event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	// The stream outlives the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	lastID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	c, missed := s.store.events.subscribe(audience, lastID)
+	defer s.store.events.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if hello != nil {
+		writeEvent(w, *hello)
+	}
+	for _, ev := range missed {
+		writeEvent(w, ev)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-c:
+			if !ok {
+				// Dropped as a slow consumer, or shutting down
+				return
+			}
+			writeEvent(w, ev)
+			if ev.Type == "ended" {
+				flusher.Flush()
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent leaves out the id of events that aren't in the replay buffer
+// (ID 0) so they don't reset the browser's Last-Event-ID.
+func writeEvent(w http.ResponseWriter, ev Event) {
+	if ev.ID > 0 {
+		fmt.Fprintf(w, "id: %d\n", ev.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, ev.Data)
+}
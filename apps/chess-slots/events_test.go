@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestHubReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience string
+		lastID   int
+		want     []int // IDs of the events replayed
+	}{
+		{name: "new page gets big wins only", want: []int{1, 4}},
+		{name: "new page, its own big win too", audience: "p1", want: []int{1, 4, 5}},
+		{name: "reconnect gets what it missed", lastID: 2, want: []int{3, 4}},
+		{name: "player's own events", audience: "p1", lastID: 1, want: []int{2, 3, 4, 5}},
+		{name: "another player's are left out", audience: "p2", lastID: 1, want: []int{3, 4}},
+		{name: "up to date", lastID: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			h.publish("big_win", BigWinEvent{Win: 100})
+			h.publishTo("p1", "duel", map[string]string{})
+			h.publish("announcement", map[string]string{"message": "hello"})
+			h.publish("big_win", BigWinEvent{Win: 200})
+			h.publishTo("p1", "big_win", BigWinEvent{Win: 300})
+
+			_, missed := h.subscribe(tt.audience, tt.lastID)
+			var got []int
+			for _, ev := range missed {
+				got = append(got, ev.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("replayed %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("replayed %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHubReplayBuffer(t *testing.T) {
+	h := NewHub()
+	for i := 0; i < eventReplaySize+20; i++ {
+		h.publish("announcement", map[string]string{})
+	}
+	_, missed := h.subscribe("", 1)
+	if len(missed) != eventReplaySize || missed[0].ID != 21 {
+		t.Errorf("replayed %d events from %d, want the last %d", len(missed), missed[0].ID, eventReplaySize)
+	}
+}
+
+func TestHubSend(t *testing.T) {
+	tests := []struct {
+		name      string
+		audience  string
+		to        string
+		published int
+		received  int
+		dropped   bool
+	}{
+		{name: "to everyone", audience: "p1", published: 3, received: 3},
+		{name: "to this player", audience: "p1", to: "p1", published: 3, received: 3},
+		{name: "to another player", audience: "p1", to: "p2", published: 3},
+		{name: "slow consumer dropped", audience: "p1", published: eventClientBuffer + 1, received: eventClientBuffer, dropped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			c, _ := h.subscribe(tt.audience, 0)
+			for i := 0; i < tt.published; i++ {
+				h.publishTo(tt.to, "announcement", map[string]string{})
+			}
+			received, open := 0, true
+			for open {
+				select {
+				case _, ok := <-c:
+					if ok {
+						received++
+					}
+					open = ok
+				default:
+					open = false
+				}
+			}
+			if received != tt.received {
+				t.Errorf("received %d events, want %d", received, tt.received)
+			}
+			if dropped := h.count(tt.audience) == 0; dropped != tt.dropped {
+				t.Errorf("dropped = %v, want %v", dropped, tt.dropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var errNoExperiment = errors.New("this game has no experiment")
+
+// Experiment splits a game's players between paytable versions. A player's
+// variant follows from the experiment ID and their player ID alone, so it
+// is the same on every spin and every instance.
+type Experiment struct {
+	ID        string     `json:"id"`
+	Variants  []Variant  `json:"variants"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+}
+
+// Variant is one arm of an experiment: a paytable version and its share
+// of players, relative to the other variants' weights.
+type Variant struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Weight  int    `json:"weight"`
+
+	paytable *Paytable
+}
+
+// variantFor hashes playerID into one of the variants by weight.
+func (e *Experiment) variantFor(playerID string) *Variant {
+	sum := sha256.Sum256([]byte(e.ID + ":" + playerID))
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	n := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for i := range e.Variants {
+		if n < e.Variants[i].Weight {
+			return &e.Variants[i]
+		}
+		n -= e.Variants[i].Weight
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
+
+// experimentRunning is whether g's players are split between variants.
+func (g *GameDefinition) experimentRunning() bool {
+	e := g.paytables.experiment.Load()
+	return e != nil && e.EndedAt == nil
+}
+
+// assign picks the paytable playerID's paid spins use: their variant's
+// while an experiment runs, and the current version otherwise.
+func (g *GameDefinition) assign(playerID string) (pt *Paytable, experiment, variant string) {
+	if e := g.paytables.experiment.Load(); e != nil && e.EndedAt == nil {
+		v := e.variantFor(playerID)
+		return v.paytable, e.ID, v.Name
+	}
+	return g.paytable(), "", ""
+}
+
+// startExperiment checks e against the loaded versions and puts new paid
+// spins on it, replacing any experiment before it.
+func (g *GameDefinition) startExperiment(e *Experiment) error {
+	if e.ID == "" {
+		return errors.New("the experiment needs an id")
+	}
+	if len(e.Variants) < 2 {
+		return errors.New("an experiment needs at least two variants")
+	}
+	names := map[string]bool{}
+	for i := range e.Variants {
+		v := &e.Variants[i]
+		switch {
+		case v.Name == "" || names[v.Name]:
+			return fmt.Errorf("variant %d needs a name of its own", i+1)
+		case v.Weight <= 0:
+			return fmt.Errorf("variant %q needs a positive weight", v.Name)
+		}
+		names[v.Name] = true
+		pt, err := g.paytableVersion(v.Version)
+		if err != nil {
+			return fmt.Errorf("variant %q: %w", v.Name, err)
+		}
+		v.paytable = pt
+	}
+	e.StartedAt = time.Now()
+	g.paytables.experiment.Store(e)
+	return nil
+}
+
+// restoreExperiment puts back the experiment a store file kept, on the
+// versions restorePaytables put back.
+func (g *GameDefinition) restoreExperiment(e *Experiment) error {
+	for i := range e.Variants {
+		v := &e.Variants[i]
+		pt, err := g.paytableVersion(v.Version)
+		if err != nil {
+			return fmt.Errorf("variant %q: %w", v.Name, err)
+		}
+		v.paytable = pt
+	}
+	g.paytables.experiment.Store(e)
+	return nil
+}
+
+// stopExperiment puts everyone back on the current version. The
+// experiment stays for its report until another one starts.
+func (g *GameDefinition) stopExperiment() (*Experiment, error) {
+	e := g.paytables.experiment.Load()
+	if e == nil || e.EndedAt != nil {
+		return nil, errNoExperiment
+	}
+	ended := *e
+	now := time.Now()
+	ended.EndedAt = &now
+	g.paytables.experiment.Store(&ended)
+	return &ended, nil
+}
+
+// CohortStats is how one variant's players have played: paid spins of the
+// game under the experiment, bonus wins included. A session is a run of
+// spins with no gap over sessionIdleTimeout, as for play limits.
+type CohortStats struct {
+	Variant            string  `json:"variant"`
+	Version            int     `json:"version"`
+	Weight             int     `json:"weight"`
+	Players            int     `json:"players"`
+	Spins              int     `json:"spins"`
+	Wagered            int     `json:"wagered"`
+	Won                int     `json:"won"`
+	BonusWon           int     `json:"bonusWon"`
+	RTP                float64 `json:"rtp"`
+	TheoreticalBaseRTP float64 `json:"theoreticalBaseRtp"`
+	Sessions           int     `json:"sessions"`
+	AvgSessionSeconds  float64 `json:"avgSessionSeconds"`
+	SpinsPerSession    float64 `json:"spinsPerSession"`
+}
+
+// cohortReport works out each variant's stats from the rounds and ledger.
+// Callers must hold s.mu.
+func (s *Store) cohortReport(g *GameDefinition, e *Experiment) []CohortStats {
+	stats := make([]CohortStats, len(e.Variants))
+	index := map[string]int{}
+	for i, v := range e.Variants {
+		stats[i] = CohortStats{Variant: v.Name, Version: v.Version, Weight: v.Weight, TheoreticalBaseRTP: v.paytable.BaseRTP}
+		index[v.Name] = i
+	}
+	// Each player's spins in order, for their sessions
+	type play struct {
+		cohort int
+		times  []time.Time
+	}
+	players := map[string]*play{}
+	cohortOf := map[string]int{} // round ID -> cohort, for bonus wins
+	for _, r := range s.rounds {
+		i, ok := index[r.Variant]
+		if r.GameID != g.ID || r.Experiment != e.ID || !ok {
+			continue
+		}
+		c := &stats[i]
+		c.Spins++
+		c.Wagered += r.Bet
+		c.Won += r.Win
+		cohortOf[r.ID] = i
+		p := players[r.PlayerID]
+		if p == nil {
+			p = &play{cohort: i}
+			players[r.PlayerID] = p
+			c.Players++
+		}
+		p.times = append(p.times, r.Time)
+	}
+	for _, entry := range s.ledger {
+		if i, ok := cohortOf[entry.RoundID]; ok && entry.Kind == "bonus" {
+			stats[i].BonusWon += entry.Amount
+		}
+	}
+	sessionTime := make([]time.Duration, len(stats))
+	for _, p := range players {
+		c := &stats[p.cohort]
+		start := p.times[0]
+		for j := 1; j <= len(p.times); j++ {
+			if j == len(p.times) || p.times[j].Sub(p.times[j-1]) > sessionIdleTimeout {
+				c.Sessions++
+				sessionTime[p.cohort] += p.times[j-1].Sub(start)
+				if j < len(p.times) {
+					start = p.times[j]
+				}
+			}
+		}
+	}
+	for i := range stats {
+		c := &stats[i]
+		if c.Wagered > 0 {
+			c.RTP = float64(c.Won+c.BonusWon) / float64(c.Wagered)
+		}
+		if c.Sessions > 0 {
+			c.AvgSessionSeconds = sessionTime[i].Seconds() / float64(c.Sessions)
+			c.SpinsPerSession = float64(c.Spins) / float64(c.Sessions)
+		}
+	}
+	return stats
+}
+
+// publishExperiment tells open pages of g that the paytable they play by
+// may have changed. Each player's differs, so pages fetch their own.
+func (s *server) publishExperiment(g *GameDefinition) {
+	s.store.events.publish("paytable", map[string]any{"game": g.ID, "refetch": true})
+}
+
+func (s *server) experimentGame(w http.ResponseWriter, r *http.Request) (*GameDefinition, bool) {
+	id := r.PathValue("id")
+	g, err := s.store.games.get(id)
+	if err != nil || id == "" {
+		writeErr(w, errUnknownGame)
+		return nil, false
+	}
+	auditFrom(r.Context()).Target = g.ID
+	return g, true
+}
+
+// handleAdminExperiment reports on a game's experiment, as JSON or, with
+// ?format=csv, one CSV row per variant.
+func (s *server) handleAdminExperiment(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.experimentGame(w, r)
+	if !ok {
+		return
+	}
+	e := g.paytables.experiment.Load()
+	if e == nil {
+		writeErr(w, errNoExperiment)
+		return
+	}
+	var cohorts []CohortStats
+	s.store.read(func() {
+		cohorts = s.store.cohortReport(g, e)
+	})
+	if r.URL.Query().Get("format") != "csv" {
+		writeJSON(w, http.StatusOK, map[string]any{"game": g.ID, "experiment": e, "cohorts": cohorts})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", g.ID+"-"+e.ID+".csv"))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"experiment", "variant", "paytable_version", "weight", "players", "spins", "wagered", "won", "bonus_won",
+		"rtp", "theoretical_base_rtp", "sessions", "avg_session_seconds", "spins_per_session"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, c := range cohorts {
+		cw.Write([]string{e.ID, c.Variant, strconv.Itoa(c.Version), strconv.Itoa(c.Weight), strconv.Itoa(c.Players),
+			strconv.Itoa(c.Spins), strconv.Itoa(c.Wagered), strconv.Itoa(c.Won), strconv.Itoa(c.BonusWon),
+			f(c.RTP), f(c.TheoreticalBaseRTP), strconv.Itoa(c.Sessions), f(c.AvgSessionSeconds), f(c.SpinsPerSession)})
+	}
+	cw.Flush()
+}
+
+// handleAdminStartExperiment starts an experiment on a game:
+// {"id", "variants": [{"name", "version", "weight"}, ...]}.
+func (s *server) handleAdminStartExperiment(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.experimentGame(w, r)
+	if !ok {
+		return
+	}
+	var e Experiment
+	if err := decodeJSON(r, &e); err != nil {
+		writeError(w, http.StatusBadRequest, `expected {"id", "variants": [{"name", "version", "weight"}, ...]}`)
+		return
+	}
+	auditFrom(r.Context()).Details = map[string]any{"id": e.ID, "variants": e.Variants}
+	if err := g.startExperiment(&e); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.WarnContext(r.Context(), "experiment started", "game", g.ID, "experiment", e.ID, "variants", len(e.Variants))
+	s.publishExperiment(g)
+	writeJSON(w, http.StatusCreated, map[string]any{"experiment": &e})
+}
+
+// handleAdminStopExperiment ends a game's experiment; its report stays.
+func (s *server) handleAdminStopExperiment(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.experimentGame(w, r)
+	if !ok {
+		return
+	}
+	e, err := g.stopExperiment()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	auditFrom(r.Context()).Details = map[string]any{"id": e.ID}
+	slog.WarnContext(r.Context(), "experiment stopped", "game", g.ID, "experiment", e.ID)
+	s.publishExperiment(g)
+	writeJSON(w, http.StatusOK, map[string]any{"experiment": e})
+}
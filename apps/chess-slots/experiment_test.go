@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVariantFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{name: "even", weights: []int{1, 1}},
+		{name: "three to one", weights: []int{3, 1}},
+		{name: "three ways", weights: []int{2, 1, 1}},
+	}
+	const players = 4000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Experiment{ID: "exp-" + tt.name}
+			total := 0
+			for i, w := range tt.weights {
+				e.Variants = append(e.Variants, Variant{Name: strconv.Itoa(i), Weight: w})
+				total += w
+			}
+			counts := map[string]int{}
+			for i := range players {
+				id := "player-" + strconv.Itoa(i)
+				v := e.variantFor(id)
+				if again := e.variantFor(id); again != v {
+					t.Fatalf("%s got %s, then %s", id, v.Name, again.Name)
+				}
+				counts[v.Name]++
+			}
+			for i, w := range tt.weights {
+				want := players * w / total
+				if got := counts[strconv.Itoa(i)]; got < want*9/10 || got > want*11/10 {
+					t.Errorf("variant %d has %d players, want about %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStartExperiment(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		variants []Variant
+		ok       bool
+	}{
+		{name: "two variants", id: "e1", variants: []Variant{{Name: "a", Version: 1, Weight: 1}, {Name: "b", Version: 1, Weight: 1}}, ok: true},
+		{name: "no id", variants: []Variant{{Name: "a", Version: 1, Weight: 1}, {Name: "b", Version: 1, Weight: 1}}},
+		{name: "one variant", id: "e1", variants: []Variant{{Name: "a", Version: 1, Weight: 1}}},
+		{name: "same name twice", id: "e1", variants: []Variant{{Name: "a", Version: 1, Weight: 1}, {Name: "a", Version: 1, Weight: 1}}},
+		{name: "no weight", id: "e1", variants: []Variant{{Name: "a", Version: 1, Weight: 1}, {Name: "b", Version: 1}}},
+		{name: "version not loaded", id: "e1", variants: []Variant{{Name: "a", Version: 1, Weight: 1}, {Name: "b", Version: 2, Weight: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestStore(t).games.house()
+			err := g.startExperiment(&Experiment{ID: tt.id, Variants: tt.variants})
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want ok = %v", err, tt.ok)
+			}
+			if g.experimentRunning() != tt.ok {
+				t.Errorf("running = %v, want %v", g.experimentRunning(), tt.ok)
+			}
+		})
+	}
+}
+
+// experimentStore has experiment e1 running on the house game, with the
+// rounds below in it.
+func experimentStore(t *testing.T) (*Store, *Experiment) {
+	t.Helper()
+	s := newTestStore(t)
+	g := s.games.house()
+	e := &Experiment{ID: "e1", Variants: []Variant{{Name: "a", Version: 1, Weight: 1}, {Name: "b", Version: 1, Weight: 1}}}
+	if err := g.startExperiment(e); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	round := func(id, player, variant string, at time.Duration, win int) Round {
+		return Round{ID: id, PlayerID: player, GameID: g.ID, Time: start.Add(at), Bet: 10, Win: win, Experiment: "e1", Variant: variant}
+	}
+	s.rounds = []Round{
+		// p1: one session of two minutes, then another after a long gap
+		round("r1", "p1", "a", 0, 0),
+		round("r2", "p1", "a", time.Minute, 30),
+		round("r3", "p1", "a", 2*time.Minute, 0),
+		round("r4", "p1", "a", 3*time.Hour, 10),
+		// p2: one spin
+		round("r5", "p2", "b", 0, 0),
+		// Not in the experiment, or in another game
+		{ID: "r6", PlayerID: "p2", GameID: g.ID, Time: start, Bet: 10, Win: 500},
+		{ID: "r7", PlayerID: "p2", GameID: "knights-quest", Time: start, Bet: 10, Win: 500, Experiment: "e1", Variant: "b"},
+	}
+	s.ledger = []LedgerEntry{
+		{PlayerID: "p2", Kind: "bonus", Amount: 25, RoundID: "r5"},
+		{PlayerID: "p2", Kind: "bonus", Amount: 99, RoundID: "r6"},
+	}
+	return s, e
+}
+
+func TestCohortReport(t *testing.T) {
+	s, e := experimentStore(t)
+	stats := s.cohortReport(s.games.house(), e)
+	want := []CohortStats{
+		{Variant: "a", Players: 1, Spins: 4, Wagered: 40, Won: 40, RTP: 1, Sessions: 2, AvgSessionSeconds: 60, SpinsPerSession: 2},
+		{Variant: "b", Players: 1, Spins: 1, Wagered: 10, BonusWon: 25, RTP: 2.5, Sessions: 1, SpinsPerSession: 1},
+	}
+	for i, w := range want {
+		got := stats[i]
+		got.Version, got.Weight, got.TheoreticalBaseRTP = 0, 0, 0
+		if got != w {
+			t.Errorf("variant %s:\n got %+v\nwant %+v", w.Variant, got, w)
+		}
+	}
+}
+
+func TestExperimentCSV(t *testing.T) {
+	const token = "0123456789abcdef0123"
+	s, _ := experimentStore(t)
+	cfg := defaultConfig()
+	cfg.AdminToken = token
+	srv := newServer(s, cfg)
+	r := httptest.NewRequest("GET", srv.base+"/admin/api/games/chess-slots/experiment?format=csv", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.routes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		row  int
+		want []string
+	}{
+		{row: 0, want: []string{"experiment", "variant", "paytable_version", "weight", "players", "spins", "wagered", "won", "bonus_won", "rtp"}},
+		{row: 1, want: []string{"e1", "a", "1", "1", "1", "4", "40", "40", "0", "1.0000"}},
+		{row: 2, want: []string{"e1", "b", "1", "1", "1", "1", "10", "0", "25", "2.5000"}},
+	}
+	if len(rows) != len(tests) {
+		t.Fatalf("%d rows, want %d", len(rows), len(tests))
+	}
+	for _, tt := range tests {
+		if got := rows[tt.row][:len(tt.want)]; strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("row %d = %v, want %v", tt.row, got, tt.want)
+		}
+	}
+}
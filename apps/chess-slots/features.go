@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+var errFeatureDisabled = errors.New("turned off")
+
+// Switches hold which features are on. They start from the config and an
+// operator can flip them while the server runs.
+type Switches struct {
+	mu sync.RWMutex
+	on map[string]bool
+}
+
+func newSwitches(features map[string]bool) *Switches {
+	sw := &Switches{on: map[string]bool{}}
+	for _, name := range featureNames {
+		sw.on[name] = features[name]
+	}
+	return sw
+}
+
+func (sw *Switches) enabled(name string) bool {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	return sw.on[name]
+}
+
+// all copies every switch.
+func (sw *Switches) all() map[string]bool {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	m := make(map[string]bool, len(sw.on))
+	for name, on := range sw.on {
+		m[name] = on
+	}
+	return m
+}
+
+// set flips the named switches, all or none.
+func (sw *Switches) set(changes map[string]bool) error {
+	for name := range changes {
+		if !slices.Contains(featureNames, name) {
+			return fmt.Errorf("no feature called %q", name)
+		}
+	}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for name, on := range changes {
+		sw.on[name] = on
+	}
+	return nil
+}
+
+// featureOn reports whether the named feature (see featureNames) is on.
+func (s *server) featureOn(name string) bool {
+	return s.store.switches.enabled(name)
+}
+
+// checkFeature is nil when name is on, and otherwise an error that
+// answers 503.
+func (s *server) checkFeature(name string) error {
+	if s.featureOn(name) {
+		return nil
+	}
+	return fmt.Errorf("%s is %w", name, errFeatureDisabled)
+}
+
+// requireFeature answers 503 instead of calling h while name is off.
+func (s *server) requireFeature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.checkFeature(name); err != nil {
+			writeErr(w, err)
+			return
+		}
+		h(w, r)
+	}
+}
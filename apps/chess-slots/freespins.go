@@ -0,0 +1,60 @@
+package main
+
+import "errors"
+
+// FreeSpinsPolicy awards free spins for scatters: Scatters or more
+// anywhere on the grid give Spins spins of the same game, played at its
+// spin cost without a bet. No spins turns them off.
+type FreeSpinsPolicy struct {
+	Scatters int `json:"scatters"`
+	Spins    int `json:"spins"`
+}
+
+func (f FreeSpinsPolicy) validate() error {
+	if f.Spins < 0 || f.Spins > 100 || (f.Spins > 0 && f.Scatters < 1) {
+		return errors.New("spins must be from 0 to 100, and need at least 1 scatter")
+	}
+	return nil
+}
+
+// FreeSpins are the spins a player has won and not yet played. They are
+// for one game at a time: scatters elsewhere award none until they are
+// used up.
+type FreeSpins struct {
+	GameID string `json:"gameId"`
+	Left   int    `json:"left"`
+	// Coins the spins have paid so far
+	Won int `json:"won"`
+}
+
+// freeSpin reports whether p's next spin of g is free. Callers must hold
+// s.mu.
+func (s *Store) freeSpin(p *Player, g *GameDefinition) bool {
+	return p.FreeSpins != nil && p.FreeSpins.GameID == g.ID && s.switches.enabled("freespins")
+}
+
+// useFreeSpin counts off a free spin that paid win. Callers must hold
+// s.mu.
+func (s *Store) useFreeSpin(p *Player, win int) {
+	p.FreeSpins.Left--
+	p.FreeSpins.Won += win
+	if p.FreeSpins.Left <= 0 {
+		p.FreeSpins = nil
+	}
+}
+
+// awardFreeSpins gives p the free spins round's scatters won, if any, and
+// returns how many. Callers must hold s.mu.
+func (s *Store) awardFreeSpins(p *Player, g *GameDefinition, round Round) int {
+	f := g.FreeSpins
+	if f.Spins == 0 || round.Result.Scatters < f.Scatters || !s.switches.enabled("freespins") {
+		return 0
+	}
+	if p.FreeSpins == nil {
+		p.FreeSpins = &FreeSpins{GameID: g.ID}
+	} else if p.FreeSpins.GameID != g.ID {
+		return 0
+	}
+	p.FreeSpins.Left += f.Spins
+	return f.Spins
+}
@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+func TestAwardFreeSpins(t *testing.T) {
+	policy := FreeSpinsPolicy{Scatters: 4, Spins: 10}
+	tests := []struct {
+		name     string
+		scatters int
+		held     *FreeSpins
+		off      bool
+		awarded  int
+		left     int
+	}{
+		{name: "too few scatters", scatters: 3},
+		{name: "enough scatters", scatters: 4, awarded: 10, left: 10},
+		{name: "more than enough", scatters: 6, awarded: 10, left: 10},
+		{name: "added to the same game's", scatters: 4, held: &FreeSpins{GameID: "chess-slots", Left: 3}, awarded: 10, left: 13},
+		{name: "another game's still held", scatters: 4, held: &FreeSpins{GameID: "knights-quest", Left: 3}, left: 3},
+		{name: "switched off", scatters: 4, off: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.switches.set(map[string]bool{"freespins": !tt.off})
+			g := s.games.house()
+			g.FreeSpins = policy
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			p.FreeSpins = tt.held
+
+			round := Round{Result: SpinResult{Scatters: tt.scatters}}
+			if got := s.awardFreeSpins(p, g, round); got != tt.awarded {
+				t.Errorf("awarded %d, want %d", got, tt.awarded)
+			}
+			left := 0
+			if p.FreeSpins != nil {
+				left = p.FreeSpins.Left
+			}
+			if left != tt.left {
+				t.Errorf("left = %d, want %d", left, tt.left)
+			}
+		})
+	}
+}
+
+func TestFreeSpin(t *testing.T) {
+	tests := []struct {
+		name string
+		held *FreeSpins
+		off  bool
+		free bool
+	}{
+		{name: "last free spin", held: &FreeSpins{GameID: "chess-slots", Left: 1}, free: true},
+		{name: "another game's", held: &FreeSpins{GameID: "knights-quest", Left: 1}},
+		{name: "switched off", held: &FreeSpins{GameID: "chess-slots", Left: 1}, off: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.switches.set(map[string]bool{"freespins": !tt.off})
+			g := s.games.house()
+			g.FreeSpins = FreeSpinsPolicy{}
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			p.FreeSpins = tt.held
+			before, entries := p.Balance, len(s.ledger)
+
+			round, err := s.spin(p, g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if round.Free != tt.free {
+				t.Errorf("free = %v, want %v", round.Free, tt.free)
+			}
+			bet := g.SpinCost
+			if tt.free {
+				bet = 0
+			}
+			if round.Bet != bet || p.Balance != before-bet+round.Win+round.Jackpot {
+				t.Errorf("bet %d, balance %d; want a bet of %d", round.Bet, p.Balance, bet)
+			}
+			for _, e := range s.ledger[entries:] {
+				if e.Kind == "bet" && tt.free {
+					t.Errorf("a free spin posted a bet: %+v", e)
+				}
+			}
+			if tt.free && p.FreeSpins != nil {
+				t.Errorf("free spins after the last = %+v, want none", p.FreeSpins)
+			}
+			if !tt.free && (p.FreeSpins == nil || p.FreeSpins.Left != 1) {
+				t.Errorf("free spins = %+v, want the one still held", p.FreeSpins)
+			}
+		})
+	}
+}
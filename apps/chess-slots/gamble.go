@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// gambleMaxWins is how many times in a row a win can be doubled before
+// the offer closes.
+const gambleMaxWins = 5
+
+var (
+	errNoGamble   = errors.New("no win to gamble")
+	errGamblePick = errors.New(`pick "white" or "black"`)
+)
+
+// GambleOffer is a paid spin's line win the player may stake on calling
+// the colour of the next piece drawn, white or black. Right doubles the
+// stake and offers it again; wrong loses it. The win is paid when the
+// spin settles, so leaving an offer costs nothing: the next spin drops
+// it.
+type GambleOffer struct {
+	RoundID string `json:"roundId"`
+	// The winning symbol's name, for achievements
+	Symbol string `json:"symbol,omitempty"`
+	Stake  int    `json:"stake"`
+	Wins   int    `json:"wins"`
+	// The game the win came from, for metrics
+	gameID string
+}
+
+// GambleResult is one call of the colour.
+type GambleResult struct {
+	Pick  string `json:"pick"`
+	Drawn string `json:"drawn"`
+	Stake int    `json:"stake"`
+	Win   int    `json:"win"`
+	// The doubled stake on offer again, or nil when the gamble is over
+	Offer *GambleOffer `json:"offer"`
+}
+
+// offerGamble lets p gamble round's line win. The jackpot and the pick
+// bonus aren't part of the stake. Callers must hold s.mu.
+func (s *Store) offerGamble(p *Player, g *GameDefinition, round Round) {
+	p.Gamble = nil
+	if round.Win <= 0 || round.Bet <= 0 || !s.switches.enabled("gamble") {
+		return
+	}
+	p.Gamble = &GambleOffer{RoundID: round.ID, Stake: round.Win, gameID: g.ID}
+	if sym, ok := g.findSymbol(round.Result.WinningSymbol); ok {
+		p.Gamble.Symbol = sym.Name
+	}
+}
+
+// gamble stakes p's offer on pick. The stake and any win go in the ledger
+// against the round it came from, and count towards p's play limits.
+// Callers must hold s.mu.
+func (s *Store) gamble(p *Player, pick string, now time.Time) (GambleResult, error) {
+	o := p.Gamble
+	if o == nil {
+		return GambleResult{}, errNoGamble
+	}
+	if pick != "white" && pick != "black" {
+		return GambleResult{}, errGamblePick
+	}
+	// The win may have been spent since, on a duel buy-in say
+	if p.Balance < o.Stake {
+		return GambleResult{}, errInsufficientFunds
+	}
+	if err := p.Safety.checkBet(o.Stake, now); err != nil {
+		return GambleResult{}, err
+	}
+	res := GambleResult{Pick: pick, Drawn: [...]string{"white", "black"}[randIntn(2)], Stake: o.Stake}
+	s.post(p, "gamble", -o.Stake, o.RoundID)
+	if res.Drawn != pick {
+		p.Gamble = nil
+		metrics.recordGamble(o.gameID, o.Stake, 0)
+		return res, nil
+	}
+	res.Win = 2 * o.Stake
+	s.post(p, "gamble_win", res.Win, o.RoundID)
+	metrics.recordGamble(o.gameID, o.Stake, res.Win)
+	s.awardBadges(p, settledEvent{kind: "gamble", symbol: o.Symbol, win: res.Win}, now)
+	o.Stake = res.Win
+	o.Wins++
+	if o.Wins >= gambleMaxWins {
+		p.Gamble = nil
+	}
+	res.Offer = p.Gamble
+	return res, nil
+}
+
+type gambleResponse struct {
+	GambleResult
+	Balance      int           `json:"balance"`
+	Achievements []Achievement `json:"achievements,omitempty"`
+}
+
+// handleGamble plays {"pick": "white" | "black"} on a POST and takes the
+// win as it stands on a DELETE.
+func (s *server) handleGamble(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		var balance int
+		s.store.Update(playerID(r), func(p *Player) error {
+			p.Gamble = nil
+			balance = p.Balance
+			return nil
+		})
+		writeJSON(w, http.StatusOK, map[string]any{"balance": balance})
+		return
+	}
+	var req struct {
+		Pick string `json:"pick"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, errGamblePick.Error())
+		return
+	}
+	resp, err := s.playGamble(playerID(r), clientIP(r), req.Pick)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// playGamble is one gamble for the REST and WebSocket APIs alike. It
+// counts against the spin rate limits.
+func (s *server) playGamble(playerID, ip, pick string) (gambleResponse, error) {
+	var resp gambleResponse
+	err := s.store.Update(playerID, func(p *Player) error {
+		now := time.Now()
+		if err := s.rateLimit(p.ID, ip, now); err != nil {
+			return err
+		}
+		res, err := s.store.gamble(p, pick, now)
+		if err != nil {
+			return err
+		}
+		resp = gambleResponse{GambleResult: res, Balance: p.Balance, Achievements: s.store.takeNewBadges(p)}
+		return nil
+	})
+	return resp, err
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGamble(t *testing.T) {
+	tests := []struct {
+		name    string
+		offer   bool
+		balance int // when set, the balance left after the win
+		pick    string
+		err     error
+	}{
+		{name: "white", offer: true, pick: "white"},
+		{name: "black", offer: true, pick: "black"},
+		{name: "no win to gamble", pick: "white", err: errNoGamble},
+		{name: "not a colour", offer: true, pick: "red", err: errGamblePick},
+		{name: "stake over the balance", offer: true, balance: 30, pick: "white", err: errInsufficientFunds},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			if tt.offer {
+				p.Gamble = &GambleOffer{RoundID: "r1", Stake: 40}
+			}
+			if tt.balance > 0 {
+				p.Balance = tt.balance
+			}
+			before, entries := p.Balance, len(s.ledger)
+
+			res, err := s.gamble(p, tt.pick, time.Now())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if p.Balance != before || len(s.ledger) != entries {
+					t.Errorf("a refused gamble moved coins: balance %d -> %d", before, p.Balance)
+				}
+				return
+			}
+
+			posted := s.ledger[entries:]
+			if posted[0].Kind != "gamble" || posted[0].Amount != -40 || posted[0].RoundID != "r1" {
+				t.Errorf("stake entry = %+v", posted[0])
+			}
+			if res.Drawn != tt.pick {
+				if res.Win != 0 || len(posted) != 1 || p.Balance != before-40 || p.Gamble != nil {
+					t.Errorf("lost: win %d, %d entries, balance %d, offer %+v", res.Win, len(posted), p.Balance, p.Gamble)
+				}
+				return
+			}
+			if res.Win != 80 || len(posted) != 2 || posted[1].Kind != "gamble_win" || posted[1].Amount != 80 || p.Balance != before+40 {
+				t.Errorf("won: win %d, entries %+v, balance %d", res.Win, posted, p.Balance)
+			}
+			if p.Gamble == nil || p.Gamble.Stake != 80 || p.Gamble.Wins != 1 || res.Offer != p.Gamble {
+				t.Errorf("offer after a win = %+v, want 80 on offer again", p.Gamble)
+			}
+		})
+	}
+}
+
+func TestGambleRefusedByLimits(t *testing.T) {
+	s := newTestStore(t)
+	s.createPlayer("p1")
+	p := s.players["p1"]
+	p.Gamble = &GambleOffer{RoundID: "r1", Stake: 40}
+	p.Safety.ExcludedUntil = time.Now().Add(time.Hour)
+	var le *LimitError
+	if _, err := s.gamble(p, "white", time.Now()); !errors.As(err, &le) {
+		t.Fatalf("got %v, want a LimitError", err)
+	}
+	if p.Gamble == nil {
+		t.Error("a refused gamble dropped the offer")
+	}
+}
+
+// Spending the win elsewhere closes the offer, so it can't be staked
+// twice.
+func TestGambleOfferDropped(t *testing.T) {
+	tests := []struct {
+		name  string
+		spend func(s *Store, p *Player) error
+	}{
+		{name: "duel buy-in", spend: func(s *Store, p *Player) error {
+			return s.queueDuel(p, time.Now())
+		}},
+		{name: "spin", spend: func(s *Store, p *Player) error {
+			_, err := s.spin(p, s.game)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.game.Duel.BuyIn = 50
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			p.Balance = 60
+			p.Gamble = &GambleOffer{RoundID: "r1", Stake: 30}
+			if err := tt.spend(s, p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Gamble != nil && p.Gamble.RoundID == "r1" {
+				t.Fatalf("offer = %+v after the %s, want it closed", p.Gamble, tt.name)
+			}
+		})
+	}
+}
+
+// However the calls go, an offer closes after gambleMaxWins wins or the
+// first loss, and the ledger accounts for every coin.
+func TestGambleCloses(t *testing.T) {
+	s := newTestStore(t)
+	s.createPlayer("p1")
+	p := s.players["p1"]
+	for i := 0; i < 20; i++ {
+		p.Gamble = &GambleOffer{RoundID: "r1", Stake: 5}
+		before, entries := p.Balance, len(s.ledger)
+		wins := 0
+		for p.Gamble != nil {
+			res, err := s.gamble(p, "white", time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Win > 0 {
+				wins++
+			}
+			if wins > gambleMaxWins {
+				t.Fatalf("won %d times on one offer", wins)
+			}
+		}
+		sum := 0
+		for _, e := range s.ledger[entries:] {
+			sum += e.Amount
+		}
+		if p.Balance != before+sum {
+			t.Fatalf("balance = %d, want %d", p.Balance, before+sum)
+		}
+	}
+}
+
+func TestOfferGamble(t *testing.T) {
+	tests := []struct {
+		name  string
+		round Round
+		off   bool
+		offer bool
+	}{
+		{name: "paid win", round: Round{ID: "r1", Bet: 5, Win: 20}, offer: true},
+		{name: "no win", round: Round{ID: "r1", Bet: 5}},
+		{name: "free spin", round: Round{ID: "r1", Win: 20, Free: true}},
+		{name: "switched off", round: Round{ID: "r1", Bet: 5, Win: 20}, off: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.switches.set(map[string]bool{"gamble": !tt.off})
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			p.Gamble = &GambleOffer{RoundID: "r0", Stake: 10}
+			s.offerGamble(p, s.game, tt.round)
+			switch {
+			case !tt.offer && p.Gamble != nil:
+				t.Errorf("offer = %+v, want none", p.Gamble)
+			case tt.offer && (p.Gamble == nil || p.Gamble.RoundID != "r1" || p.Gamble.Stake != tt.round.Win):
+				t.Errorf("offer = %+v, want the round's %d", p.Gamble, tt.round.Win)
+			}
+		})
+	}
+}
+
+func TestGambleBadges(t *testing.T) {
+	tests := []struct {
+		symbol string
+		earned bool
+	}{
+		{symbol: "Queen", earned: true},
+		{symbol: "Knight"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			s := newTestStore(t)
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			// Call until one comes up right
+			for won := false; !won; {
+				p.Gamble = &GambleOffer{RoundID: "r1", Symbol: tt.symbol, Stake: 5}
+				res, err := s.gamble(p, "black", time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				won = res.Win > 0
+			}
+			if _, earned := p.Badges.Earned["queens-gambit"]; earned != tt.earned {
+				t.Errorf("Queen's Gambit earned = %v, want %v", earned, tt.earned)
+			}
+		})
+	}
+}
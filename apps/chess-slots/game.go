@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"time"
+)
+
+type Symbol struct {
+	Symbol  string `json:"symbol"`
+	Name    string `json:"name"`
+	Weight  int    `json:"weight"`
+	Payout  int    `json:"payout"`
+	Scatter bool   `json:"scatter,omitempty"`
+}
+
+// Presentation is how long the page takes to play a spin back in one mode.
+// The server never waits on it except to pace autoplay.
+type Presentation struct {
+	ReelStopsMs        []int `json:"reelStopsMs"`
+	ResultDelayMs      int   `json:"resultDelayMs"`
+	AutoplayIntervalMs int   `json:"autoplayIntervalMs"`
+}
+
+// Theme is how a game looks in the lobby and on its page.
+type Theme struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	Icon     string `json:"icon"`
+	// CSS background for the page
+	Background string `json:"background"`
+}
+
+// GameDefinition is everything that makes up one slot game: the paytable,
+// reel layout, costs, presentation timings and theme.
+type GameDefinition struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Theme         Theme  `json:"theme"`
+	SpinCost      int    `json:"spinCost"`
+	StartingCoins int    `json:"startingCoins"`
+	Reels         int    `json:"reels"`
+	Rows          int    `json:"rows"`
+	PaylineRow    int    `json:"paylineRow"`
+	// Scatters anywhere on the visible grid start the pick bonus. This and
+	// the symbols' weights and payouts are as loaded at startup; spins use
+	// paytable(), which can be reloaded.
+	ScattersForBonus int `json:"scattersForBonus"`
+	// Wins of at least this many times the bet go out on the live event
+	// stream (default 20)
+	BigWinMultiplier int                     `json:"bigWinMultiplier"`
+	Symbols          []Symbol                `json:"symbols"`
+	Presentation     map[string]Presentation `json:"presentation"`
+	Economy          EconomyPolicy           `json:"economy"`
+	Jackpot          JackpotPolicy           `json:"jackpot"`
+	FreeSpins        FreeSpinsPolicy         `json:"freeSpins"`
+	Achievements     []Achievement           `json:"achievements"`
+	Tournaments      []TournamentTemplate    `json:"tournaments"`
+	Duel             DuelPolicy              `json:"duel"`
+
+	paytables paytableHistory
+	// The definition without what a paytable reload may change
+	fixed string
+}
+
+const defaultMode = "normal"
+
+// loadGameDefinition reads the game definition at path.
+func loadGameDefinition(path string) (*GameDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("game definition: %w", err)
+	}
+	return parseGameDefinition(data)
+}
+
+func parseGameDefinition(data []byte) (*GameDefinition, error) {
+	var g GameDefinition
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("game definition: %w", err)
+	}
+	if err := g.validate(); err != nil {
+		return nil, fmt.Errorf("game definition %q: %w", g.ID, err)
+	}
+	fixed, err := fixedPart(data)
+	if err != nil {
+		return nil, fmt.Errorf("game definition: %w", err)
+	}
+	g.fixed = fixed
+	g.addPaytable(newPaytable(g.Symbols, g.ScattersForBonus, g.Reels, "startup"))
+	if g.Theme.Title == "" {
+		g.Theme.Title = g.Name
+	}
+	return &g, nil
+}
+
+func (g *GameDefinition) validate() error {
+	switch {
+	case !validGameID(g.ID):
+		return errors.New("id must be lowercase letters, digits and dashes, and not a fixed route like api or static (it is the game's URL)")
+	case g.SpinCost <= 0 || g.StartingCoins < 0:
+		return errors.New("spinCost must be positive and startingCoins non-negative")
+	case g.Reels < 3 || g.Rows < 1 || g.PaylineRow < 0 || g.PaylineRow >= g.Rows:
+		return errors.New("need at least 3 reels and a payline row inside the grid")
+	case len(g.Symbols) == 0:
+		return errors.New("no symbols")
+	}
+	for _, s := range g.Symbols {
+		if s.Symbol == "" || s.Weight <= 0 || s.Payout < 0 {
+			return fmt.Errorf("symbol %q needs a positive weight and a non-negative payout", s.Name)
+		}
+	}
+	if err := validateAchievements(g); err != nil {
+		return err
+	}
+	if g.BigWinMultiplier < 0 {
+		return errors.New("bigWinMultiplier cannot be negative")
+	}
+	if err := g.Economy.validate(g.SpinCost); err != nil {
+		return fmt.Errorf("economy: %w", err)
+	}
+	if err := g.Duel.validate(); err != nil {
+		return fmt.Errorf("duel: %w", err)
+	}
+	if err := g.Jackpot.validate(); err != nil {
+		return fmt.Errorf("jackpot: %w", err)
+	}
+	if err := g.FreeSpins.validate(); err != nil {
+		return fmt.Errorf("freeSpins: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, t := range g.Tournaments {
+		if seen[t.ID] {
+			return fmt.Errorf("duplicate tournament id %q", t.ID)
+		}
+		seen[t.ID] = true
+		if err := t.validate(g.SpinCost); err != nil {
+			return err
+		}
+	}
+	if _, ok := g.Presentation[defaultMode]; !ok {
+		return fmt.Errorf("presentation %q is required", defaultMode)
+	}
+	for mode, p := range g.Presentation {
+		if len(p.ReelStopsMs) != g.Reels {
+			return fmt.Errorf("presentation %q needs one stop time per reel", mode)
+		}
+		if p.ResultDelayMs < 0 || p.AutoplayIntervalMs <= 0 {
+			return fmt.Errorf("presentation %q has invalid timings", mode)
+		}
+	}
+	return nil
+}
+
+func validGameID(id string) bool {
+	switch id {
+	case "", "admin", "api", "static", "health", "healthz", "readyz", "metrics":
+		// Fixed route segments under the base path, which a game page
+		// would clash with
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// presentation returns the timings for mode, falling back to normal.
+func (g *GameDefinition) presentation(mode string) Presentation {
+	if p, ok := g.Presentation[mode]; ok {
+		return p
+	}
+	return g.Presentation[defaultMode]
+}
+
+// fastestSpin is the shortest time the page takes to play a spin back:
+// the last reel stopping in the quickest mode. Spins through the page
+// can't come closer together than this.
+func (g *GameDefinition) fastestSpin() time.Duration {
+	fastest := -1
+	for _, p := range g.Presentation {
+		if stop := slices.Max(p.ReelStopsMs); fastest < 0 || stop < fastest {
+			fastest = stop
+		}
+	}
+	return time.Duration(max(fastest, 0)) * time.Millisecond
+}
+
+type SpinResult struct {
+	Grid           [][]string `json:"grid"`
+	Payline        []string   `json:"payline"`
+	WinningSymbol  string     `json:"winningSymbol,omitempty"`
+	MatchCount     int        `json:"matchCount,omitempty"`
+	Payout         int        `json:"payout"`
+	Scatters       int        `json:"scatters"`
+	BonusTriggered bool       `json:"bonusTriggered"`
+	// Free spins the scatters won
+	FreeSpinsWon int `json:"freeSpinsWon,omitempty"`
+	// The paytable version the spin was played under, and its hash
+	PaytableVersion int    `json:"paytableVersion"`
+	PaytableHash    string `json:"paytableHash"`
+}
+
+func randIntn(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic("chess-slots: crypto/rand unavailable: " + err.Error())
+	}
+	return int(v.Int64())
+}
+
+func (pt *Paytable) randomSymbol() Symbol {
+	r := randIntn(pt.totalWeight)
+	for _, s := range pt.Symbols {
+		if r < s.Weight {
+			return s
+		}
+		r -= s.Weight
+	}
+	return pt.Symbols[len(pt.Symbols)-1]
+}
+
+func (pt *Paytable) findSymbol(sym string) (Symbol, bool) {
+	for _, s := range pt.Symbols {
+		if s.Symbol == sym {
+			return s, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// findSymbol looks a symbol up by glyph. Glyphs and names are the same in
+// every paytable version; weights and payouts are the startup ones.
+func (g *GameDefinition) findSymbol(sym string) (Symbol, bool) {
+	for _, s := range g.Symbols {
+		if s.Symbol == sym {
+			return s, true
+		}
+	}
+	return Symbol{}, false
+}
+
+func (g *GameDefinition) symbolByName(name string) *Symbol {
+	for i := range g.Symbols {
+		if g.Symbols[i].Name == name {
+			return &g.Symbols[i]
+		}
+	}
+	return nil
+}
+
+// spin fills the grid reel by reel (grid[reel][row]) and scores the
+// payline the same way the original client did: the most frequent
+// symbol with 3+ matches pays, with bonuses for 4 and 5 of a kind. The
+// whole spin uses the paytable that was current when it started.
+func (g *GameDefinition) spin() SpinResult {
+	return g.spinWith(g.paytable())
+}
+
+// spinWith spins under pt, such as a player's experiment variant.
+func (g *GameDefinition) spinWith(pt *Paytable) SpinResult {
+	res := SpinResult{Grid: make([][]string, g.Reels), PaytableVersion: pt.Version, PaytableHash: pt.Hash}
+	for i := range res.Grid {
+		res.Grid[i] = make([]string, g.Rows)
+		for j := range res.Grid[i] {
+			s := pt.randomSymbol()
+			res.Grid[i][j] = s.Symbol
+			if s.Scatter {
+				res.Scatters++
+			}
+		}
+		res.Payline = append(res.Payline, res.Grid[i][g.PaylineRow])
+	}
+
+	counts := map[string]int{}
+	for _, sym := range res.Payline {
+		counts[sym]++
+	}
+	for sym, count := range counts {
+		s, ok := pt.findSymbol(sym)
+		if !ok || s.Scatter || count < 3 || count <= res.MatchCount {
+			continue
+		}
+		multiplier := s.Payout
+		if count == 4 {
+			multiplier *= 3
+		}
+		if count == 5 {
+			multiplier *= 10
+		}
+		res.WinningSymbol = sym
+		res.MatchCount = count
+		res.Payout = g.SpinCost * multiplier
+	}
+
+	res.BonusTriggered = pt.ScattersForBonus > 0 && res.Scatters >= pt.ScattersForBonus
+	return res
+}
+
+// baseRTP is the share of each bet the payline pays back on average, before
+// the pick bonus. Each payline cell is an independent weighted draw, so a
+// symbol's match count is binomial. With more than five reels two symbols
+// can both match 3+, and then only one pays, so this slightly overstates
+// those games.
+func (pt *Paytable) baseRTP(reels int) float64 {
+	n := reels
+	rtp := 0.0
+	for _, s := range pt.Symbols {
+		if s.Scatter || s.Payout == 0 {
+			continue
+		}
+		p := float64(s.Weight) / float64(pt.totalWeight)
+		for k := 3; k <= n; k++ {
+			multiplier := float64(s.Payout)
+			switch k {
+			case 4:
+				multiplier *= 3
+			case 5:
+				multiplier *= 10
+			}
+			rtp += binomial(n, k) * math.Pow(p, float64(k)) * math.Pow(1-p, float64(n-k)) * multiplier
+		}
+	}
+	return rtp
+}
+
+func binomial(n, k int) float64 {
+	c := 1.0
+	for i := 1; i <= k; i++ {
+		c = c * float64(n-k+i) / float64(i)
+	}
+	return c
+}
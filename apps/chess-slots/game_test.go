@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestValidGameID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: "chess-slots", want: true},
+		{id: "knights-quest-2", want: true},
+		{id: ""},
+		{id: "Chess"},
+		{id: "chess_slots"},
+		{id: "chess/slots"},
+		{id: "admin"},
+		{id: "api"},
+		{id: "static"},
+		{id: "health"},
+		{id: "healthz"},
+		{id: "readyz"},
+		{id: "metrics"},
+	}
+	for _, tt := range tests {
+		if got := validGameID(tt.id); got != tt.want {
+			t.Errorf("validGameID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
@@ -1,4 +1,4 @@
 module chess-slots
 
-go 1.21
+go 1.22
 
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.gitSHA=...".
+// A plain go build falls back to the VCS revision Go stamps into the binary.
+var (
+	version = "dev"
+	gitSHA  = ""
+)
+
+var startTime = time.Now()
+
+// readyTimeout bounds each readiness check. A store that can't be locked
+// in this long is wedged, and the instance should stop taking traffic.
+const readyTimeout = 2 * time.Second
+
+func init() {
+	if gitSHA != "" {
+		return
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				gitSHA = s.Value
+			}
+		}
+	}
+}
+
+type buildInfo struct {
+	Version       string  `json:"version"`
+	GitSHA        string  `json:"gitSha,omitempty"`
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+}
+
+func currentBuild() buildInfo {
+	up := time.Since(startTime)
+	return buildInfo{Version: version, GitSHA: gitSHA, Uptime: up.Round(time.Second).String(), UptimeSeconds: up.Seconds()}
+}
+
+type livenessResponse struct {
+	Status string `json:"status"`
+	buildInfo
+}
+
+// handleLiveness only says the process is serving HTTP. It checks nothing
+// else, so a slow dependency never gets the instance restarted.
+func (s *server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, livenessResponse{Status: "ok", buildInfo: currentBuild()})
+}
+
+type dependencyStatus struct {
+	Status  string `json:"status"` // ok or fail
+	Latency string `json:"latency"`
+	Detail  string `json:"detail,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type readinessResponse struct {
+	Status string `json:"status"` // ready, not_ready or shutting_down
+	buildInfo
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+}
+
+// handleReadiness checks what a spin needs: the store, the game
+// definitions and the jackpot (5 of a kind and the pool). Any failure answers
+// 503 so the load balancer stops sending players here.
+func (s *server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(context.Context) (string, error){
+		"store":   s.checkStore,
+		"games":   s.checkGames,
+		"jackpot": s.checkJackpot,
+	}
+	resp := readinessResponse{Status: "ready", buildInfo: currentBuild(), Dependencies: map[string]dependencyStatus{}}
+	if s.shuttingDown() {
+		resp.Status = "shutting_down"
+	}
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		start := time.Now()
+		detail, err := check(ctx)
+		cancel()
+		dep := dependencyStatus{Status: "ok", Latency: time.Since(start).String(), Detail: detail}
+		if err != nil {
+			dep.Status, dep.Error = "fail", err.Error()
+			resp.Status = "not_ready"
+		}
+		resp.Dependencies[name] = dep
+	}
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, resp)
+}
+
+// checkStore takes the store lock, which every request needs.
+func (s *server) checkStore(ctx context.Context) (string, error) {
+	done := make(chan string, 1)
+	go s.store.read(func() {
+		done <- fmt.Sprintf("%d players, %d rounds, %d ledger entries",
+			len(s.store.players), len(s.store.rounds), len(s.store.ledger))
+	})
+	select {
+	case detail := <-done:
+		return detail, nil
+	case <-ctx.Done():
+		return "", errors.New("store lock not acquired in time")
+	}
+}
+
+// checkGames re-validates every loaded game definition.
+func (s *server) checkGames(ctx context.Context) (string, error) {
+	games := s.store.games
+	if games == nil || games.house() == nil {
+		return "", errors.New("no house game loaded")
+	}
+	for _, g := range games.games {
+		if err := g.validate(); err != nil {
+			return "", fmt.Errorf("game %s: %w", g.ID, err)
+		}
+	}
+	return fmt.Sprintf("%d games, house game %s", len(games.games), games.house().ID), nil
+}
+
+// checkJackpot makes sure every game has a 5 of a kind to win, and
+// reports the progressive pool it pays on top.
+func (s *server) checkJackpot(ctx context.Context) (string, error) {
+	var top int
+	for _, g := range s.store.games.games {
+		best := 0
+		for _, sym := range g.paytable().Symbols {
+			if !sym.Scatter && sym.Payout > best {
+				best = sym.Payout
+			}
+		}
+		if best == 0 {
+			return "", fmt.Errorf("game %s has no paying symbol", g.ID)
+		}
+		top = max(top, g.SpinCost*best*10)
+	}
+	if !s.store.game.Jackpot.enabled() {
+		return fmt.Sprintf("top 5-of-a-kind prize %d coins, no progressive pool", top), nil
+	}
+	var pool int
+	s.store.read(func() { pool = s.store.jackpotAmount() })
+	return fmt.Sprintf("top 5-of-a-kind prize %d coins, progressive pool %d coins", top, pool), nil
+}
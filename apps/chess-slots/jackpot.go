@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// JackpotPolicy is the progressive jackpot shared by every game. Each paid
+// spin adds ContributionPercent of its bet to the pool, and a 5 of a kind
+// on the payline wins the whole pool on top of the line prize. The pool
+// then starts again from Seed. No seed and no contribution turn it off;
+// so does the "jackpot" switch, which leaves the pool as it stands.
+type JackpotPolicy struct {
+	Seed                int     `json:"seed"`
+	ContributionPercent float64 `json:"contributionPercent"`
+}
+
+func (j JackpotPolicy) validate() error {
+	if j.Seed < 0 || j.ContributionPercent < 0 || j.ContributionPercent > 100 {
+		return errors.New("seed cannot be negative and contributionPercent must be from 0 to 100")
+	}
+	return nil
+}
+
+func (j JackpotPolicy) enabled() bool {
+	return j.Seed > 0 || j.ContributionPercent > 0
+}
+
+// JackpotEvent is the pool as pages show it. A win names the winner.
+type JackpotEvent struct {
+	Amount int    `json:"amount"`
+	Won    int    `json:"won,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Game   string `json:"game,omitempty"`
+}
+
+// jackpotAmount is the pool in whole coins. Callers must hold s.mu.
+func (s *Store) jackpotAmount() int {
+	return int(s.jackpot)
+}
+
+// fundJackpot adds a paid bet's share to the pool. Callers must hold s.mu.
+func (s *Store) fundJackpot(bet int) {
+	if pct := s.game.Jackpot.ContributionPercent; pct > 0 && s.switches.enabled("jackpot") {
+		s.jackpot += float64(bet) * pct / 100
+	}
+}
+
+// payJackpot pays p the pool for round roundID of g and starts it again
+// from the seed; the fraction of a coin left over stays in. Callers must
+// hold s.mu.
+func (s *Store) payJackpot(p *Player, g *GameDefinition, roundID string) int {
+	won := s.jackpotAmount()
+	if !s.game.Jackpot.enabled() || won <= 0 || !s.switches.enabled("jackpot") {
+		return 0
+	}
+	s.jackpot = s.jackpot - float64(won) + float64(s.game.Jackpot.Seed)
+	s.post(p, "jackpot", won, roundID)
+	metrics.recordJackpotWin(g.ID, won)
+	s.jackpotShown = s.jackpotAmount()
+	s.events.publishLive("jackpot", JackpotEvent{Amount: s.jackpotShown, Won: won, Name: displayName(p.ID), Game: g.Name})
+	return won
+}
+
+// publishJackpot sends the pool to every page if it has grown since it
+// last went out. The scheduler calls it each tick rather than every spin,
+// so busy play doesn't flood the stream. Callers must hold s.mu.
+func (s *Store) publishJackpot() {
+	if !s.game.Jackpot.enabled() || s.jackpotAmount() == s.jackpotShown {
+		return
+	}
+	s.jackpotShown = s.jackpotAmount()
+	s.events.publishLive("jackpot", JackpotEvent{Amount: s.jackpotShown})
+}
+
+// jackpotHello is the first event on a page's stream: the pool as it is
+// now, or nil with no jackpot.
+func (s *server) jackpotHello() *Event {
+	var ev *Event
+	s.store.read(func() {
+		if s.store.game.Jackpot.enabled() {
+			ev = liveEvent("jackpot", JackpotEvent{Amount: s.store.jackpotAmount()})
+		}
+	})
+	return ev
+}
+
+// JackpotView is the pool as the admin area shows it, with the last win.
+type JackpotView struct {
+	Amount int `json:"amount"`
+	JackpotPolicy
+	LastWon *LedgerEntry `json:"lastWon"`
+}
+
+// jackpotView is the pool now. Callers must hold s.mu.
+func (s *Store) jackpotView() JackpotView {
+	v := JackpotView{Amount: s.jackpotAmount(), JackpotPolicy: s.game.Jackpot}
+	for i := len(s.ledger) - 1; i >= 0; i-- {
+		if s.ledger[i].Kind == "jackpot" {
+			e := s.ledger[i]
+			v.LastWon = &e
+			break
+		}
+	}
+	return v
+}
+
+// handleAdminJackpot shows the pool, or resets or seeds it:
+// {"reason": "..."} puts it back to the seed and {"amount": coins,
+// "reason": "..."} sets it to amount. Pages see the new amount at once.
+func (s *server) handleAdminJackpot(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		var v JackpotView
+		s.store.read(func() { v = s.store.jackpotView() })
+		writeJSON(w, http.StatusOK, v)
+		return
+	}
+	var req struct {
+		Amount *int   `json:"amount"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	audit := auditFrom(r.Context())
+	audit.Details = map[string]any{"amount": req.Amount, "reason": req.Reason}
+	if (req.Amount != nil && *req.Amount < 0) || req.Reason == "" {
+		writeError(w, http.StatusBadRequest, `expected {"reason": "..."} to reset to the seed, or {"amount": coins, "reason": "..."}`)
+		return
+	}
+	if !s.store.game.Jackpot.enabled() {
+		writeError(w, http.StatusConflict, "the house game has no jackpot; give it a seed or contribution")
+		return
+	}
+	var v JackpotView
+	var from int
+	s.store.read(func() {
+		st := s.store
+		from = st.jackpotAmount()
+		to := st.game.Jackpot.Seed
+		if req.Amount != nil {
+			to = *req.Amount
+		}
+		st.jackpot = float64(to)
+		st.jackpotShown = to
+		st.changes++
+		st.events.publishLive("jackpot", JackpotEvent{Amount: to})
+		v = st.jackpotView()
+	})
+	audit.Details["from"] = from
+	audit.Details["to"] = v.Amount
+	slog.WarnContext(r.Context(), "jackpot set", "from", from, "to", v.Amount, "reason", req.Reason)
+	writeJSON(w, http.StatusOK, v)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJackpotPool(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy JackpotPolicy
+		off    bool  // the "jackpot" switch
+		bets   []int // paid before the win
+		won    int
+		after  float64 // the pool after the win
+	}{
+		{name: "seed alone", policy: JackpotPolicy{Seed: 1000, ContributionPercent: 2}, won: 1000, after: 1000},
+		{name: "bets add their share", policy: JackpotPolicy{Seed: 1000, ContributionPercent: 2}, bets: []int{50, 50, 100}, won: 1004, after: 1000},
+		{name: "fractions carry over", policy: JackpotPolicy{Seed: 1000, ContributionPercent: 2}, bets: []int{5, 5, 5, 5, 5, 5, 5}, won: 1000, after: 1000.7},
+		{name: "no seed", policy: JackpotPolicy{ContributionPercent: 10}, bets: []int{30}, won: 3, after: 0},
+		{name: "off", bets: []int{1000}, won: 0, after: 0},
+		{name: "switched off", policy: JackpotPolicy{Seed: 1000, ContributionPercent: 2}, off: true, bets: []int{100}, won: 0, after: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.game.Jackpot = tt.policy
+			s.jackpot = float64(tt.policy.Seed)
+			s.switches.set(map[string]bool{"jackpot": !tt.off})
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			for _, bet := range tt.bets {
+				s.fundJackpot(bet)
+			}
+			before := p.Balance
+			if won := s.payJackpot(p, s.game, "r1"); won != tt.won {
+				t.Errorf("won %d, want %d", won, tt.won)
+			}
+			if p.Balance != before+tt.won {
+				t.Errorf("balance = %d, want %d", p.Balance, before+tt.won)
+			}
+			if diff := s.jackpot - tt.after; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("pool after = %v, want %v", s.jackpot, tt.after)
+			}
+			if tt.won > 0 {
+				last := s.ledger[len(s.ledger)-1]
+				if last.Kind != "jackpot" || last.Amount != tt.won || last.RoundID != "r1" {
+					t.Errorf("ledger entry = %+v", last)
+				}
+			}
+		})
+	}
+}
+
+func TestJackpotStream(t *testing.T) {
+	s := newTestStore(t)
+	c, _ := s.events.subscribe("", 0)
+	s.createPlayer("p1")
+
+	s.fundJackpot(10) // 0.2 of a coin: nothing to show yet
+	s.publishJackpot()
+	s.fundJackpot(50)
+	s.publishJackpot()
+	s.payJackpot(s.players["p1"], s.game, "r1")
+
+	var got []JackpotEvent
+	for len(c) > 0 {
+		ev := <-c
+		if ev.Type != "jackpot" || ev.ID != 0 {
+			t.Fatalf("got %s event %d, want jackpot events outside the replay buffer", ev.Type, ev.ID)
+		}
+		var j JackpotEvent
+		json.Unmarshal(ev.Data, &j)
+		got = append(got, j)
+	}
+	want := []JackpotEvent{{Amount: 1001}, {Amount: 1000, Won: 1001, Name: displayName("p1"), Game: s.game.Name}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+	if _, missed := s.events.subscribe("", 1); len(missed) != 0 {
+		t.Errorf("a reconnecting page was replayed %d jackpot events", len(missed))
+	}
+}
+
+func TestSpinJackpot(t *testing.T) {
+	tests := []struct {
+		name    string
+		free    bool
+		jackpot int
+	}{
+		{name: "paid spin", jackpot: 1000},
+		{name: "free spin", free: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			g := s.games.house()
+			// One symbol, so every spin lands 5 of a kind
+			g.addPaytable(newPaytable([]Symbol{{Symbol: "♕", Name: "Queen", Weight: 1, Payout: 5}}, 0, g.Reels, "test"))
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			if tt.free {
+				p.FreeSpins = &FreeSpins{GameID: g.ID, Left: 1}
+			}
+			c, _ := s.events.subscribe("", 0)
+
+			round, err := s.spin(p, g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if round.Result.MatchCount != 5 || round.Free != tt.free {
+				t.Fatalf("matched %d, free %v", round.Result.MatchCount, round.Free)
+			}
+			if round.Jackpot != tt.jackpot {
+				t.Errorf("jackpot = %d, want %d", round.Jackpot, tt.jackpot)
+			}
+			var big *BigWinEvent
+			for len(c) > 0 {
+				if ev := <-c; ev.Type == "big_win" {
+					json.Unmarshal(ev.Data, &big)
+				}
+			}
+			if big == nil {
+				t.Fatal("no big win for 50 times the bet")
+			}
+			if big.Jackpot != (tt.jackpot > 0) || big.Pool != tt.jackpot {
+				t.Errorf("big win jackpot %v pool %d, want the pool of %d", big.Jackpot, big.Pool, tt.jackpot)
+			}
+		})
+	}
+}
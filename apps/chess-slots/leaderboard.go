@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	leaderboardSize    = 10
+	maxLeaderboardSize = 50
+)
+
+var (
+	leaderboardPeriods = []string{"daily", "weekly", "alltime"}
+	leaderboardMetrics = []string{"biggestWin", "multiplier", "totalWon", "streak"}
+)
+
+// PlayerStats are one player's running totals for one period.
+type PlayerStats struct {
+	BiggestWin    int     `json:"biggestWin"`
+	Multiplier    float64 `json:"multiplier"`
+	TotalWon      int     `json:"totalWon"`
+	LongestStreak int     `json:"streak"`
+	currentStreak int
+}
+
+func (ps *PlayerStats) metric(name string) float64 {
+	switch name {
+	case "biggestWin":
+		return float64(ps.BiggestWin)
+	case "multiplier":
+		return ps.Multiplier
+	case "totalWon":
+		return float64(ps.TotalWon)
+	default:
+		return float64(ps.LongestStreak)
+	}
+}
+
+// Leaderboard keeps per-player stats for the current day, the current
+// week and all time, updated as each round settles so requests never
+// rescan the round history.
+type Leaderboard struct {
+	periods map[string]map[string]*PlayerStats // period key -> player -> stats
+}
+
+func NewLeaderboard() *Leaderboard {
+	return &Leaderboard{periods: map[string]map[string]*PlayerStats{}}
+}
+
+func leaderboardKeys(t time.Time) map[string]string {
+	day, week, _ := periodKeys(t)
+	return map[string]string{"daily": "day:" + day, "weekly": "week:" + week, "alltime": "alltime"}
+}
+
+// record adds one win (or loss, when win is 0) for playerID. Bonus
+// payouts are recorded as their own wins but don't touch the streak.
+func (lb *Leaderboard) record(playerID string, bet, win int, spin bool, at time.Time) {
+	keys := leaderboardKeys(at)
+	lb.prune(keys)
+	for _, key := range keys {
+		board := lb.periods[key]
+		if board == nil {
+			board = map[string]*PlayerStats{}
+			lb.periods[key] = board
+		}
+		ps := board[playerID]
+		if ps == nil {
+			ps = &PlayerStats{}
+			board[playerID] = ps
+		}
+		ps.TotalWon += win
+		if win > ps.BiggestWin {
+			ps.BiggestWin = win
+		}
+		if bet > 0 {
+			if m := float64(win) / float64(bet); m > ps.Multiplier {
+				ps.Multiplier = m
+			}
+		}
+		if spin {
+			if win > 0 {
+				ps.currentStreak++
+			} else {
+				ps.currentStreak = 0
+			}
+			if ps.currentStreak > ps.LongestStreak {
+				ps.LongestStreak = ps.currentStreak
+			}
+		}
+	}
+}
+
+// prune drops days and weeks that have ended.
+func (lb *Leaderboard) prune(current map[string]string) {
+	for key := range lb.periods {
+		if key != current["daily"] && key != current["weekly"] && key != current["alltime"] {
+			delete(lb.periods, key)
+		}
+	}
+}
+
+type LeaderboardEntry struct {
+	Rank  int     `json:"rank"`
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	You   bool    `json:"you,omitempty"`
+}
+
+type LeaderboardView struct {
+	Period    string             `json:"period"`
+	PeriodKey string             `json:"periodKey"`
+	Metric    string             `json:"metric"`
+	Entries   []LeaderboardEntry `json:"entries"`
+	You       *LeaderboardEntry  `json:"you"`
+}
+
+// displayName hides the player ID, which doubles as their session cookie.
+func displayName(playerID string) string {
+	sum := sha256.Sum256([]byte(playerID))
+	return "Player " + hex.EncodeToString(sum[:3])
+}
+
+func (lb *Leaderboard) top(period, metric, playerID string, limit int, now time.Time) LeaderboardView {
+	key := leaderboardKeys(now)[period]
+	v := LeaderboardView{Period: period, PeriodKey: key, Metric: metric, Entries: []LeaderboardEntry{}}
+
+	type row struct {
+		id    string
+		value float64
+	}
+	var rows []row
+	for id, ps := range lb.periods[key] {
+		if val := ps.metric(metric); val > 0 {
+			rows = append(rows, row{id, val})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].value != rows[j].value {
+			return rows[i].value > rows[j].value
+		}
+		return rows[i].id < rows[j].id
+	})
+
+	for i, r := range rows {
+		e := LeaderboardEntry{Rank: i + 1, Name: displayName(r.id), Value: r.value, You: r.id == playerID}
+		if e.You {
+			you := e
+			v.You = &you
+		}
+		if i < limit {
+			v.Entries = append(v.Entries, e)
+		}
+	}
+	return v
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	period := q.Get("period")
+	if period == "" {
+		period = "daily"
+	}
+	if !contains(leaderboardPeriods, period) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("period must be one of %v", leaderboardPeriods))
+		return
+	}
+	metrics := leaderboardMetrics
+	if m := q.Get("metric"); m != "" {
+		if !contains(leaderboardMetrics, m) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("metric must be one of %v", leaderboardMetrics))
+			return
+		}
+		metrics = []string{m}
+	}
+	limit := leaderboardSize
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= maxLeaderboardSize {
+		limit = l
+	}
+
+	id := playerID(r)
+	var boards []LeaderboardView
+	s.store.read(func() {
+		for _, m := range metrics {
+			boards = append(boards, s.store.leaderboard.top(period, m, id, limit, time.Now()))
+		}
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"boards": boards})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderboardRecord(t *testing.T) {
+	type play struct {
+		bet, win int
+		spin     bool
+	}
+	tests := []struct {
+		name  string
+		plays []play
+		want  PlayerStats
+	}{
+		{name: "no plays"},
+		{name: "one win", plays: []play{{5, 20, true}}, want: PlayerStats{BiggestWin: 20, Multiplier: 4, TotalWon: 20, LongestStreak: 1}},
+		{
+			name:  "streak broken by a loss",
+			plays: []play{{5, 10, true}, {5, 10, true}, {5, 0, true}, {5, 50, true}},
+			want:  PlayerStats{BiggestWin: 50, Multiplier: 10, TotalWon: 70, LongestStreak: 2},
+		},
+		{
+			name:  "bonus wins leave the streak alone",
+			plays: []play{{5, 10, true}, {5, 100, false}, {5, 10, true}},
+			want:  PlayerStats{BiggestWin: 100, Multiplier: 20, TotalWon: 120, LongestStreak: 2},
+		},
+		{name: "free spin has no multiplier", plays: []play{{0, 40, true}}, want: PlayerStats{BiggestWin: 40, TotalWon: 40, LongestStreak: 1}},
+	}
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := NewLeaderboard()
+			for _, p := range tt.plays {
+				lb.record("p1", p.bet, p.win, p.spin, now)
+			}
+			for _, period := range leaderboardPeriods {
+				for _, metric := range leaderboardMetrics {
+					v := lb.top(period, metric, "p1", leaderboardSize, now)
+					want := tt.want.metric(metric)
+					got := 0.0
+					if v.You != nil {
+						got = v.You.Value
+					}
+					if got != want {
+						t.Errorf("%s %s = %v, want %v", period, metric, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLeaderboardPeriods(t *testing.T) {
+	today := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		name   string
+		played time.Time
+		period string
+		listed bool
+	}{
+		{name: "today, daily", played: today, period: "daily", listed: true},
+		{name: "yesterday, daily", played: today.AddDate(0, 0, -1), period: "daily"},
+		{name: "yesterday, weekly", played: today.AddDate(0, 0, -1), period: "weekly", listed: true},
+		{name: "last week, weekly", played: today.AddDate(0, 0, -7), period: "weekly"},
+		{name: "last week, all time", played: today.AddDate(0, 0, -7), period: "alltime", listed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := NewLeaderboard()
+			lb.record("old", 5, 10, true, tt.played)
+			lb.record("new", 5, 10, true, today)
+			v := lb.top(tt.period, "totalWon", "old", leaderboardSize, today)
+			if listed := v.You != nil; listed != tt.listed {
+				t.Errorf("listed = %v, want %v", listed, tt.listed)
+			}
+		})
+	}
+}
+
+func TestLeaderboardTop(t *testing.T) {
+	now := time.Now()
+	lb := NewLeaderboard()
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		lb.record(id, 5, 10*(i+1), true, now)
+	}
+	lb.record("tie", 5, 30, true, now)
+	tests := []struct {
+		name    string
+		player  string
+		limit   int
+		entries int
+		rank    int
+	}{
+		{name: "top of the board", player: "e", limit: 3, entries: 3, rank: 1},
+		{name: "below the cut", player: "a", limit: 3, entries: 3, rank: 6},
+		// Ties go by player ID, so the ranking is stable
+		{name: "tied", player: "tie", limit: 10, entries: 6, rank: 4},
+		{name: "not playing", player: "nobody", limit: 10, entries: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := lb.top("daily", "totalWon", tt.player, tt.limit, now)
+			if len(v.Entries) != tt.entries {
+				t.Errorf("%d entries, want %d", len(v.Entries), tt.entries)
+			}
+			rank := 0
+			if v.You != nil {
+				rank = v.You.Rank
+			}
+			if rank != tt.rank {
+				t.Errorf("rank = %d, want %d", rank, tt.rank)
+			}
+			for i, e := range v.Entries {
+				if e.Rank != i+1 || (i > 0 && e.Value > v.Entries[i-1].Value) {
+					t.Errorf("entry %d = %+v out of order", i, e)
+				}
+			}
+		})
+	}
+}
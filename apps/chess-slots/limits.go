@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// Raising or removing a limit only takes effect after this long;
+	// lowering one applies straight away.
+	limitIncreaseDelay = 24 * time.Hour
+	// A gap this long between spins starts a new session for reality checks.
+	sessionIdleTimeout = 30 * time.Minute
+)
+
+// Cool-off periods can be chosen from the page; self-exclusion is longer.
+var exclusionPeriods = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"6m":  182 * 24 * time.Hour,
+	"1y":  365 * 24 * time.Hour,
+}
+
+// PlayLimits are the player's own safeguards. Zero means no limit.
+type PlayLimits struct {
+	DailyLoss           int `json:"dailyLoss"`
+	WeeklyLoss          int `json:"weeklyLoss"`
+	MonthlyLoss         int `json:"monthlyLoss"`
+	DailyWager          int `json:"dailyWager"`
+	WeeklyWager         int `json:"weeklyWager"`
+	MonthlyWager        int `json:"monthlyWager"`
+	RealityCheckMinutes int `json:"realityCheckMinutes"`
+}
+
+func (l *PlayLimits) fields() []*int {
+	return []*int{&l.DailyLoss, &l.WeeklyLoss, &l.MonthlyLoss, &l.DailyWager, &l.WeeklyWager, &l.MonthlyWager, &l.RealityCheckMinutes}
+}
+
+// stricter merges next into l, keeping whichever value protects the
+// player more for each field.
+func (l PlayLimits) stricter(next PlayLimits) PlayLimits {
+	cur, nxt := l.fields(), next.fields()
+	for i := range cur {
+		if *nxt[i] > 0 && (*cur[i] == 0 || *nxt[i] < *cur[i]) {
+			*cur[i] = *nxt[i]
+		}
+	}
+	return l
+}
+
+// LimitError is returned when a responsible-play safeguard blocks a spin.
+type LimitError struct {
+	Code    string     `json:"code"`
+	Message string     `json:"error"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+func (e *LimitError) Error() string { return e.Message }
+
+// PeriodUsage is what the player wagered and won in one calendar period
+// (UTC), identified by Key.
+type PeriodUsage struct {
+	Key     string `json:"key"`
+	Wagered int    `json:"wagered"`
+	Won     int    `json:"won"`
+}
+
+func (u PeriodUsage) Loss() int { return u.Wagered - u.Won }
+
+type PlayUsage struct {
+	Daily   PeriodUsage `json:"daily"`
+	Weekly  PeriodUsage `json:"weekly"`
+	Monthly PeriodUsage `json:"monthly"`
+}
+
+func periodKeys(t time.Time) (day, week, month string) {
+	t = t.UTC()
+	y, w := t.ISOWeek()
+	return t.Format("2006-01-02"), fmt.Sprintf("%d-W%02d", y, w), t.Format("2006-01")
+}
+
+// rollover starts fresh totals for any period that has ended.
+func (u *PlayUsage) rollover(now time.Time) {
+	day, week, month := periodKeys(now)
+	for _, p := range []struct {
+		usage *PeriodUsage
+		key   string
+	}{{&u.Daily, day}, {&u.Weekly, week}, {&u.Monthly, month}} {
+		if p.usage.Key != p.key {
+			*p.usage = PeriodUsage{Key: p.key}
+		}
+	}
+}
+
+// PlaySafety is everything the responsible-play checks need per player.
+type PlaySafety struct {
+	Limits        PlayLimits  `json:"limits"`
+	Pending       *PlayLimits `json:"pending,omitempty"`
+	PendingFrom   time.Time   `json:"pendingFrom"`
+	ExcludedUntil time.Time   `json:"excludedUntil"`
+	Usage         PlayUsage   `json:"usage"`
+	Session       PlaySession `json:"session"`
+}
+
+type PlaySession struct {
+	StartedAt    time.Time `json:"startedAt"`
+	LastActivity time.Time `json:"lastActivity"`
+	LastCheck    time.Time `json:"lastCheck"`
+	Wagered      int       `json:"wagered"`
+	Won          int       `json:"won"`
+}
+
+func (ps *PlaySafety) refresh(now time.Time) {
+	if ps.Pending != nil && !now.Before(ps.PendingFrom) {
+		ps.Limits = *ps.Pending
+		ps.Pending = nil
+		ps.PendingFrom = time.Time{}
+	}
+	ps.Usage.rollover(now)
+	if now.Sub(ps.Session.LastActivity) > sessionIdleTimeout {
+		ps.Session = PlaySession{StartedAt: now, LastActivity: now, LastCheck: now}
+	}
+}
+
+// setLimits applies tighter limits now and queues looser ones.
+func (ps *PlaySafety) setLimits(next PlayLimits, now time.Time) {
+	ps.refresh(now)
+	ps.Limits = ps.Limits.stricter(next)
+	ps.Pending = nil
+	ps.PendingFrom = time.Time{}
+	if ps.Limits != next {
+		ps.Pending = &next
+		ps.PendingFrom = now.Add(limitIncreaseDelay)
+	}
+}
+
+// checkBet reports whether the player may stake bet right now.
+func (ps *PlaySafety) checkBet(bet int, now time.Time) error {
+	ps.refresh(now)
+	if now.Before(ps.ExcludedUntil) {
+		return &LimitError{
+			Code:    "excluded",
+			Message: "You are taking a break from play until " + ps.ExcludedUntil.UTC().Format("Jan 2, 2006 15:04 MST") + ".",
+			Until:   &ps.ExcludedUntil,
+		}
+	}
+
+	l, u := ps.Limits, ps.Usage
+	checks := []struct {
+		name   string
+		limit  int
+		amount int
+		until  time.Time
+	}{
+		{"daily loss", l.DailyLoss, u.Daily.Loss(), nextDay(now)},
+		{"weekly loss", l.WeeklyLoss, u.Weekly.Loss(), nextWeek(now)},
+		{"monthly loss", l.MonthlyLoss, u.Monthly.Loss(), nextMonth(now)},
+		{"daily wager", l.DailyWager, u.Daily.Wagered, nextDay(now)},
+		{"weekly wager", l.WeeklyWager, u.Weekly.Wagered, nextWeek(now)},
+		{"monthly wager", l.MonthlyWager, u.Monthly.Wagered, nextMonth(now)},
+	}
+	for _, c := range checks {
+		if c.limit > 0 && c.amount+bet > c.limit {
+			return &LimitError{
+				Code:    "limit_reached",
+				Message: fmt.Sprintf("Your %s limit of %d coins has been reached.", c.name, c.limit),
+				Until:   &c.until,
+			}
+		}
+	}
+
+	if m := l.RealityCheckMinutes; m > 0 && now.Sub(ps.Session.LastCheck) >= time.Duration(m)*time.Minute {
+		return &LimitError{
+			Code:    "reality_check",
+			Message: fmt.Sprintf("You have been playing for %d minutes.", int(now.Sub(ps.Session.StartedAt).Minutes())),
+		}
+	}
+	return nil
+}
+
+// record counts a ledger movement towards the player's limits.
+func (ps *PlaySafety) record(kind string, amount int, now time.Time) {
+	ps.refresh(now)
+	ps.Session.LastActivity = now
+	switch kind {
+	case "bet", "gamble", "duel_buyin", "duel_refund":
+		for _, u := range []*PeriodUsage{&ps.Usage.Daily, &ps.Usage.Weekly, &ps.Usage.Monthly} {
+			u.Wagered -= amount
+		}
+		ps.Session.Wagered -= amount
+	case "win", "bonus", "jackpot", "gamble_win", "duel_pot":
+		for _, u := range []*PeriodUsage{&ps.Usage.Daily, &ps.Usage.Weekly, &ps.Usage.Monthly} {
+			u.Won += amount
+		}
+		ps.Session.Won += amount
+	}
+}
+
+func nextDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextWeek(t time.Time) time.Time {
+	t = t.UTC()
+	daysToMonday := (8 - int(t.Weekday())) % 7
+	if daysToMonday == 0 {
+		daysToMonday = 7
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+daysToMonday, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	id := playerID(r)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var next PlayLimits
+		if err := decodeJSON(r, &next); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, v := range next.fields() {
+			if *v < 0 {
+				writeError(w, http.StatusBadRequest, "limits cannot be negative")
+				return
+			}
+		}
+		s.store.Update(id, func(p *Player) error {
+			p.Safety.setLimits(next, time.Now())
+			return nil
+		})
+	}
+	s.writeSafety(w, id)
+}
+
+func (s *server) handleExclude(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Period string `json:"period"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	d, ok := exclusionPeriods[req.Period]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "period must be one of 24h, 7d, 30d, 6m or 1y")
+		return
+	}
+	id := playerID(r)
+	s.store.Update(id, func(p *Player) error {
+		// An exclusion can be extended but never shortened
+		if until := time.Now().Add(d); until.After(p.Safety.ExcludedUntil) {
+			p.Safety.ExcludedUntil = until
+		}
+		s.store.stopAutoplay(p.Autoplay, "break started")
+		return nil
+	})
+	s.writeSafety(w, id)
+}
+
+func (s *server) handleRealityCheck(w http.ResponseWriter, r *http.Request) {
+	id := playerID(r)
+	s.store.Update(id, func(p *Player) error {
+		now := time.Now()
+		p.Safety.refresh(now)
+		p.Safety.Session.LastCheck = now
+		return nil
+	})
+	s.writeSafety(w, id)
+}
+
+func (s *server) writeSafety(w http.ResponseWriter, playerID string) {
+	var view PlaySafety
+	s.store.Update(playerID, func(p *Player) error {
+		p.Safety.refresh(time.Now())
+		view = p.Safety
+		return nil
+	})
+	writeJSON(w, http.StatusOK, view)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// A Wednesday, so the day, week and month all have time left
+var limitsNow = time.Date(2026, 3, 11, 12, 0, 0, 0, time.UTC)
+
+func TestCheckBet(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   PlayLimits
+		excluded time.Duration // from now
+		wagered  int           // so far today
+		won      int
+		bet      int
+		code     string // "" when the bet may go ahead
+	}{
+		{name: "no limits", wagered: 1_000_000, bet: 10},
+		{name: "under the daily loss", limits: PlayLimits{DailyLoss: 100}, wagered: 80, bet: 10},
+		{name: "reaches the daily loss", limits: PlayLimits{DailyLoss: 100}, wagered: 90, bet: 10},
+		{name: "over the daily loss", limits: PlayLimits{DailyLoss: 100}, wagered: 95, bet: 10, code: "limit_reached"},
+		{name: "wins offset the loss", limits: PlayLimits{DailyLoss: 100}, wagered: 200, won: 150, bet: 10},
+		{name: "wins don't offset the wager", limits: PlayLimits{DailyWager: 100}, wagered: 95, won: 500, bet: 10, code: "limit_reached"},
+		{name: "weekly loss", limits: PlayLimits{WeeklyLoss: 50}, wagered: 45, bet: 10, code: "limit_reached"},
+		{name: "monthly wager", limits: PlayLimits{MonthlyWager: 50}, wagered: 45, bet: 10, code: "limit_reached"},
+		{name: "excluded", excluded: time.Hour, bet: 10, code: "excluded"},
+		{name: "exclusion over", excluded: -time.Second, bet: 10},
+		{name: "reality check due", limits: PlayLimits{RealityCheckMinutes: 1}, bet: 10, code: "reality_check"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ps PlaySafety
+			ps.Limits = tt.limits
+			ps.ExcludedUntil = limitsNow.Add(tt.excluded)
+			start := limitsNow.Add(-5 * time.Minute)
+			ps.record("signup", 0, start)
+			if tt.wagered > 0 {
+				ps.record("bet", -tt.wagered, start)
+			}
+			if tt.won > 0 {
+				ps.record("win", tt.won, start)
+			}
+
+			err := ps.checkBet(tt.bet, limitsNow)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("refused: %v", err)
+				}
+				return
+			}
+			var le *LimitError
+			if !errors.As(err, &le) {
+				t.Fatalf("got %v, want a %s LimitError", err, tt.code)
+			}
+			if le.Code != tt.code {
+				t.Errorf("code = %q, want %q", le.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestCheckBetRollsOver(t *testing.T) {
+	var ps PlaySafety
+	ps.Limits = PlayLimits{DailyLoss: 100}
+	ps.record("bet", -100, limitsNow)
+	if err := ps.checkBet(10, limitsNow); err == nil {
+		t.Fatal("the daily loss limit let a bet through")
+	}
+	if err := ps.checkBet(10, nextDay(limitsNow)); err != nil {
+		t.Fatalf("the next day: %v", err)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		current PlayLimits
+		next    PlayLimits
+		now     PlayLimits  // in force straight away
+		pending *PlayLimits // queued for limitIncreaseDelay
+	}{
+		{
+			name: "first limit applies now",
+			next: PlayLimits{DailyLoss: 100},
+			now:  PlayLimits{DailyLoss: 100},
+		},
+		{
+			name:    "lowering applies now",
+			current: PlayLimits{DailyLoss: 100},
+			next:    PlayLimits{DailyLoss: 50},
+			now:     PlayLimits{DailyLoss: 50},
+		},
+		{
+			name:    "raising waits",
+			current: PlayLimits{DailyLoss: 100},
+			next:    PlayLimits{DailyLoss: 200},
+			now:     PlayLimits{DailyLoss: 100},
+			pending: &PlayLimits{DailyLoss: 200},
+		},
+		{
+			name:    "removing waits",
+			current: PlayLimits{DailyLoss: 100},
+			next:    PlayLimits{},
+			now:     PlayLimits{DailyLoss: 100},
+			pending: &PlayLimits{},
+		},
+		{
+			name:    "mixed: the tighter half now, the rest later",
+			current: PlayLimits{DailyLoss: 100, DailyWager: 500},
+			next:    PlayLimits{DailyLoss: 50, DailyWager: 1000},
+			now:     PlayLimits{DailyLoss: 50, DailyWager: 500},
+			pending: &PlayLimits{DailyLoss: 50, DailyWager: 1000},
+		},
+		{
+			name:    "adding a reality check applies now",
+			current: PlayLimits{WeeklyWager: 300},
+			next:    PlayLimits{WeeklyWager: 300, RealityCheckMinutes: 30},
+			now:     PlayLimits{WeeklyWager: 300, RealityCheckMinutes: 30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := PlaySafety{Limits: tt.current}
+			ps.setLimits(tt.next, limitsNow)
+			if ps.Limits != tt.now {
+				t.Errorf("limits = %+v, want %+v", ps.Limits, tt.now)
+			}
+			switch {
+			case tt.pending == nil && ps.Pending != nil:
+				t.Errorf("pending = %+v, want none", *ps.Pending)
+			case tt.pending != nil && (ps.Pending == nil || *ps.Pending != *tt.pending):
+				t.Errorf("pending = %v, want %+v", ps.Pending, *tt.pending)
+			case tt.pending != nil && !ps.PendingFrom.Equal(limitsNow.Add(limitIncreaseDelay)):
+				t.Errorf("pending from %v, want %v", ps.PendingFrom, limitsNow.Add(limitIncreaseDelay))
+			}
+
+			// Once the delay is over whatever was asked for is in force
+			ps.refresh(limitsNow.Add(limitIncreaseDelay))
+			if ps.Limits != tt.next || ps.Pending != nil {
+				t.Errorf("after the delay limits = %+v pending %v, want %+v", ps.Limits, ps.Pending, tt.next)
+			}
+		})
+	}
+}
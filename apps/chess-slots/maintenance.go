@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errMaintenance = errors.New("the game is down for maintenance; please come back soon")
+
+// How long the maintenance page tells browsers and crawlers to wait
+const maintenanceRetrySeconds = 60
+
+// Maintenance is the operator's switch for taking the game offline. While
+// it is on, new spins, autoplay, tournament entries and duel queueing are
+// refused. Rounds already open settle: bonus picks and the spins of a
+// duel in progress still go through.
+type Maintenance struct {
+	mu      sync.RWMutex
+	on      bool
+	message string
+	since   time.Time
+}
+
+type MaintenanceView struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+func (m *Maintenance) view() MaintenanceView {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v := MaintenanceView{Enabled: m.on, Message: m.message}
+	if m.on {
+		since := m.since
+		v.Since = &since
+	}
+	return v
+}
+
+func (m *Maintenance) set(on bool, message string) MaintenanceView {
+	m.mu.Lock()
+	if on && !m.on {
+		m.since = time.Now()
+	}
+	m.on, m.message = on, message
+	m.mu.Unlock()
+	return m.view()
+}
+
+// check is errMaintenance while maintenance is on.
+func (m *Maintenance) check() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.on {
+		return errMaintenance
+	}
+	return nil
+}
+
+// unlessMaintenance answers 503 instead of calling h during maintenance.
+func (s *server) unlessMaintenance(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.maintenance.check(); err != nil {
+			writeErr(w, err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// hasOpenRound reports whether the player has a pick bonus or a duel to
+// finish.
+func (s *Store) hasOpenRound(playerID string) bool {
+	open := false
+	s.read(func() {
+		p := s.players[playerID]
+		open = p != nil && (p.Bonus != nil && !p.Bonus.Finished() || p.Duel != nil && p.Duel.Status == "active")
+	})
+	return open
+}
+
+// StatusView is what players see of the operator's switches.
+type StatusView struct {
+	Maintenance MaintenanceView `json:"maintenance"`
+	Features    map[string]bool `json:"features"`
+}
+
+func (s *server) status() StatusView {
+	return StatusView{Maintenance: s.maintenance.view(), Features: s.store.switches.all()}
+}
+
+// handleStatus tells the page whether maintenance is on and which
+// features are; the maintenance page polls it to know when to come back.
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+// publishStatus tells every open page that a switch changed.
+func (s *server) publishStatus() {
+	s.store.events.publish("status", s.status())
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A small Prometheus registry: counters and histograms with labels,
+// written out in the text exposition format at /metrics.
+
+type metric interface {
+	write(w io.Writer)
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // joined label values -> value
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) get(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\x00")]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelSet(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	total  uint64
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.sum += v
+	hist.total++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelSet(h.labels, key, formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelSet(h.labels, key, "+Inf"), hist.total)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelSet(h.labels, key, ""), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelSet(h.labels, key, ""), hist.total)
+	}
+}
+
+// gaugeFunc is a gauge computed when scraped.
+type gaugeFunc struct {
+	name, help string
+	labels     []string
+	fn         func() map[string]float64 // joined label values -> value
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	values := g.fn()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelSet(g.labels, key, ""), formatFloat(values[key]))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelSet renders {name="value",...}, adding le for histogram buckets.
+func labelSet(names []string, key, le string) string {
+	var parts []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\x00") {
+			parts = append(parts, names[i]+"="+strconv.Quote(v))
+		}
+	}
+	if le != "" {
+		parts = append(parts, `le="`+le+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type Metrics struct {
+	all []metric
+
+	requests        *counterVec
+	requestDuration *histogramVec
+	spins           *counterVec
+	wagered         *counterVec
+	won             *counterVec
+	jackpots        *histogramVec
+	walletErrors    *counterVec
+	rateLimited     *counterVec
+	botFlags        *counterVec
+	// The store whose jackpot pool the pool gauge reads
+	jackpotStore atomic.Pointer[Store]
+}
+
+func newMetrics() *Metrics {
+	m := &Metrics{}
+	counter := func(name, help string, labels ...string) *counterVec {
+		c := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+		m.all = append(m.all, c)
+		return c
+	}
+	hist := func(name, help string, buckets []float64, labels ...string) *histogramVec {
+		h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: map[string]*histogram{}}
+		m.all = append(m.all, h)
+		return h
+	}
+	m.requests = counter("http_requests_total", "HTTP requests by route and status.", "method", "route", "status")
+	m.requestDuration = hist("http_request_duration_seconds", "HTTP request latency by route.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "method", "route")
+	m.spins = counter("slots_spins_total", "Spins played; rate() gives spins per second.", "game", "kind")
+	m.wagered = counter("slots_wagered_coins_total", "Coins bet on paid spins and gambles.", "game")
+	m.won = counter("slots_won_coins_total", "Coins paid out by paid and free spins, their bonuses, gambles and the jackpot.", "game")
+	m.all = append(m.all, &gaugeFunc{
+		name: "slots_rtp_ratio", help: "Observed return to player since start: won / wagered.", labels: []string{"game"},
+		fn: func() map[string]float64 {
+			rtp := map[string]float64{}
+			m.wagered.mu.Lock()
+			wagered := make(map[string]float64, len(m.wagered.values))
+			for k, v := range m.wagered.values {
+				wagered[k] = v
+			}
+			m.wagered.mu.Unlock()
+			for game, bet := range wagered {
+				if bet > 0 {
+					rtp[game] = m.won.get(game) / bet
+				}
+			}
+			return rtp
+		},
+	})
+	m.jackpots = hist("slots_jackpot_coins", "Size of each progressive jackpot paid, in coins.",
+		[]float64{100, 250, 500, 1000, 2500, 5000, 10000, 25000}, "game")
+	m.all = append(m.all, &gaugeFunc{
+		name: "slots_jackpot_pool_coins", help: "Whole coins in the progressive jackpot pool now, under the house game.", labels: []string{"game"},
+		fn: func() map[string]float64 {
+			s := m.jackpotStore.Load()
+			if s == nil {
+				return nil
+			}
+			var pool map[string]float64
+			s.read(func() {
+				if s.game.Jackpot.enabled() {
+					pool = map[string]float64{s.game.ID: float64(s.jackpotAmount())}
+				}
+			})
+			return pool
+		},
+	})
+	m.walletErrors = counter("slots_wallet_errors_total", "Bets and claims the wallet refused, by reason.", "reason")
+	m.rateLimited = counter("slots_rate_limited_total", "Spins and new sessions refused by a rate limit, by scope: player, ip or session.", "scope")
+	m.botFlags = counter("slots_bot_flags_total", "Players flagged as possible bots, by heuristic.", "reason")
+	return m
+}
+
+var metrics = newMetrics()
+
+// recordSpin counts one settled spin of kind paid, tournament or duel.
+// Only paid spins move real coins, so only they count toward RTP.
+func (m *Metrics) recordSpin(game, kind string, bet, win int) {
+	m.spins.add(1, game, kind)
+	if kind == "paid" || kind == "free" {
+		m.wagered.add(float64(bet), game)
+		m.won.add(float64(win), game)
+	}
+}
+
+// trackJackpot points the pool gauge at s's jackpot.
+func (m *Metrics) trackJackpot(s *Store) {
+	m.jackpotStore.Store(s)
+}
+
+// recordGamble counts a gamble's stake as a bet and its win, if any, as
+// winnings. The stake was already paid out once by the spin it came from.
+func (m *Metrics) recordGamble(game string, stake, win int) {
+	m.wagered.add(float64(stake), game)
+	m.won.add(float64(win), game)
+}
+
+// recordJackpotWin adds a progressive jackpot to its game's winnings.
+func (m *Metrics) recordJackpotWin(game string, win int) {
+	m.won.add(float64(win), game)
+	m.jackpots.observe(float64(win), game)
+}
+
+// recordBonusWin adds a pick bonus payout to its game's winnings.
+func (m *Metrics) recordBonusWin(game string, win int) {
+	m.won.add(float64(win), game)
+}
+
+// recordWalletError counts err if it is the wallet saying no.
+func (m *Metrics) recordWalletError(err error) {
+	var limitErr *LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		m.walletErrors.add(1, limitErr.Code)
+	case errors.Is(err, errInsufficientFunds):
+		m.walletErrors.add(1, "insufficient_funds")
+	case errors.Is(err, errOutOfCredits):
+		m.walletErrors.add(1, "out_of_tournament_credits")
+	}
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metrics.all {
+		m.write(w)
+	}
+}
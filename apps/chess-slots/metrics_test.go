@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsOutput(t *testing.T) {
+	m := newMetrics()
+	m.recordSpin("chess-slots", "paid", 10, 0)
+	m.recordSpin("chess-slots", "paid", 10, 30)
+	m.recordSpin("chess-slots", "tournament", 10, 500)
+	m.recordBonusWin("chess-slots", 5)
+	m.recordJackpotWin("chess-slots", 300)
+	m.recordGamble("chess-slots", 30, 60)
+	m.recordGamble("chess-slots", 20, 0)
+	m.recordWalletError(errInsufficientFunds)
+	m.requests.add(1, "GET", "/api/state", "200")
+	var b strings.Builder
+	for _, metric := range m.all {
+		metric.write(&b)
+	}
+	out := b.String()
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "counter type", line: "# TYPE slots_spins_total counter"},
+		{name: "paid spins", line: `slots_spins_total{game="chess-slots",kind="paid"} 2`},
+		{name: "tournament spins", line: `slots_spins_total{game="chess-slots",kind="tournament"} 1`},
+		{name: "paid spins and gambles wager", line: `slots_wagered_coins_total{game="chess-slots"} 70`},
+		{name: "won counts the bonus, jackpot and gamble", line: `slots_won_coins_total{game="chess-slots"} 395`},
+		{name: "rtp", line: `slots_rtp_ratio{game="chess-slots"} 5.642857142857143`},
+		{name: "gauge type", line: "# TYPE slots_rtp_ratio gauge"},
+		{name: "bucket below", line: `slots_jackpot_coins_bucket{game="chess-slots",le="250"} 0`},
+		{name: "bucket holding it", line: `slots_jackpot_coins_bucket{game="chess-slots",le="500"} 1`},
+		{name: "buckets are cumulative", line: `slots_jackpot_coins_bucket{game="chess-slots",le="25000"} 1`},
+		{name: "inf bucket", line: `slots_jackpot_coins_bucket{game="chess-slots",le="+Inf"} 1`},
+		{name: "histogram sum", line: `slots_jackpot_coins_sum{game="chess-slots"} 300`},
+		{name: "histogram count", line: `slots_jackpot_coins_count{game="chess-slots"} 1`},
+		{name: "wallet error", line: `slots_wallet_errors_total{reason="insufficient_funds"} 1`},
+		{name: "several labels", line: `http_requests_total{method="GET",route="/api/state",status="200"} 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(out, "\n"+tt.line+"\n") {
+				t.Errorf("no line %q in\n%s", tt.line, out)
+			}
+		})
+	}
+}
+
+func TestJackpotPoolGauge(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy JackpotPolicy
+		pool   float64
+		want   string // the gauge's line, or "" for none
+	}{
+		{name: "whole coins", policy: JackpotPolicy{Seed: 1000, ContributionPercent: 2}, pool: 1234.7, want: `slots_jackpot_pool_coins{game="chess-slots"} 1234`},
+		{name: "no jackpot", pool: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMetrics()
+			s := newTestStore(t)
+			s.game.Jackpot = tt.policy
+			s.jackpot = tt.pool
+			m.trackJackpot(s)
+			var b strings.Builder
+			for _, metric := range m.all {
+				metric.write(&b)
+			}
+			lines := 0
+			for _, line := range strings.Split(b.String(), "\n") {
+				if strings.HasPrefix(line, "slots_jackpot_pool_coins{") {
+					lines++
+					if line != tt.want {
+						t.Errorf("got %q, want %q", line, tt.want)
+					}
+				}
+			}
+			if want := map[bool]int{true: 1}[tt.want != ""]; lines != want {
+				t.Errorf("%d pool lines, want %d", lines, want)
+			}
+		})
+	}
+}
+
+func TestLabelSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		key   string
+		le    string
+		want  string
+	}{
+		{name: "none", want: ""},
+		{name: "one", names: []string{"game"}, key: "chess-slots", want: `{game="chess-slots"}`},
+		{name: "two", names: []string{"method", "route"}, key: "GET\x00/api", want: `{method="GET",route="/api"}`},
+		{name: "quotes escaped", names: []string{"reason"}, key: `say "hi"`, want: `{reason="say \"hi\""}`},
+		{name: "bucket", names: []string{"game"}, key: "g", le: "+Inf", want: `{game="g",le="+Inf"}`},
+	}
+	for _, tt := range tests {
+		if got := labelSet(tt.names, tt.key, tt.le); got != tt.want {
+			t.Errorf("%s: labelSet = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	srv := newServer(newTestStore(t), defaultConfig())
+	w := httptest.NewRecorder()
+	srv.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, name := range []string{"http_requests_total", "slots_spins_total", "slots_rtp_ratio", "slots_jackpot_coins", "slots_jackpot_pool_coins"} {
+		if !strings.Contains(w.Body.String(), "# TYPE "+name+" ") {
+			t.Errorf("no %s in the output", name)
+		}
+	}
+}
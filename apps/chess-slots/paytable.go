@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errNoPaytable   = errors.New("no such paytable version")
+	errPaytableOnly = errors.New("only symbol weights and payouts and scattersForBonus can change while the server runs; restart for anything else")
+)
+
+// Paytable is the part of a game that can change while the server runs:
+// how often each symbol lands, what it pays and how many scatters start
+// the bonus. A version never changes once loaded.
+type Paytable struct {
+	Version int `json:"version"`
+	// Short SHA-256 of the symbols and scatter count
+	Hash             string    `json:"hash"`
+	Source           string    `json:"source"`
+	LoadedAt         time.Time `json:"loadedAt"`
+	Symbols          []Symbol  `json:"symbols"`
+	ScattersForBonus int       `json:"scattersForBonus"`
+	BaseRTP          float64   `json:"baseRtp"`
+
+	totalWeight int
+}
+
+func newPaytable(symbols []Symbol, scattersForBonus, reels int, source string) *Paytable {
+	pt := &Paytable{Symbols: symbols, ScattersForBonus: scattersForBonus, Source: source, LoadedAt: time.Now()}
+	for _, s := range symbols {
+		pt.totalWeight += s.Weight
+	}
+	data, _ := json.Marshal(struct {
+		Symbols          []Symbol
+		ScattersForBonus int
+	}{symbols, scattersForBonus})
+	sum := sha256.Sum256(data)
+	pt.Hash = hex.EncodeToString(sum[:6])
+	pt.BaseRTP = pt.baseRTP(reels)
+	return pt
+}
+
+// paytableHistory is every paytable version a game has loaded since
+// start, and the one new spins use.
+type paytableHistory struct {
+	mu       sync.Mutex
+	versions []*Paytable
+	current  atomic.Pointer[Paytable]
+	// The game's A/B test, running or ended, if there has been one
+	experiment atomic.Pointer[Experiment]
+}
+
+// paytable is the version new spins use.
+func (g *GameDefinition) paytable() *Paytable {
+	return g.paytables.current.Load()
+}
+
+// addPaytable makes pt the next version and moves new spins onto it. A
+// paytable the same as the current one is not a new version.
+func (g *GameDefinition) addPaytable(pt *Paytable) (*Paytable, bool) {
+	h := &g.paytables
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cur := h.current.Load(); cur != nil && cur.Hash == pt.Hash {
+		return cur, false
+	}
+	pt.Version = len(h.versions) + 1
+	h.versions = append(h.versions, pt)
+	h.current.Store(pt)
+	return pt, true
+}
+
+// activatePaytable moves new spins onto an earlier version, or back to a
+// later one.
+func (g *GameDefinition) activatePaytable(version int) (*Paytable, error) {
+	h := &g.paytables
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if version < 1 || version > len(h.versions) {
+		return nil, errNoPaytable
+	}
+	pt := h.versions[version-1]
+	h.current.Store(pt)
+	return pt, nil
+}
+
+// restorePaytables puts back the versions a store file kept, and the one
+// that was current. A startup definition that matches none of them was
+// edited while the server was down, so it becomes the next version.
+func (g *GameDefinition) restorePaytables(kept []*Paytable, current int) {
+	if len(kept) == 0 {
+		return
+	}
+	h := &g.paytables
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	startup := h.current.Load()
+	h.versions = nil
+	known := false
+	for _, k := range kept {
+		pt := newPaytable(k.Symbols, k.ScattersForBonus, g.Reels, k.Source)
+		pt.Version, pt.LoadedAt = len(h.versions)+1, k.LoadedAt
+		h.versions = append(h.versions, pt)
+		known = known || pt.Hash == startup.Hash
+	}
+	switch {
+	case !known:
+		startup.Version = len(h.versions) + 1
+		h.versions = append(h.versions, startup)
+		h.current.Store(startup)
+	case current >= 1 && current <= len(h.versions):
+		h.current.Store(h.versions[current-1])
+	default:
+		h.current.Store(h.versions[len(h.versions)-1])
+	}
+}
+
+// paytableVersion is a version g has loaded, current or not.
+func (g *GameDefinition) paytableVersion(version int) (*Paytable, error) {
+	h := &g.paytables
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if version < 1 || version > len(h.versions) {
+		return nil, errNoPaytable
+	}
+	return h.versions[version-1], nil
+}
+
+func (g *GameDefinition) paytableVersions() []*Paytable {
+	g.paytables.mu.Lock()
+	defer g.paytables.mu.Unlock()
+	return append([]*Paytable{}, g.paytables.versions...)
+}
+
+// reloadPaytable checks a new definition of g and, when only the paytable
+// differs, makes that the next version. Anything else needs a restart:
+// open pages, tournaments and platform policy were built from it.
+func (g *GameDefinition) reloadPaytable(data []byte, source string) (*Paytable, bool, error) {
+	next, err := parseGameDefinition(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if next.ID != g.ID {
+		return nil, false, fmt.Errorf("that definition is game %q, not %q", next.ID, g.ID)
+	}
+	if next.fixed != g.fixed {
+		return nil, false, errPaytableOnly
+	}
+	pt := next.paytable()
+	pt.Source = source
+	added, ok := g.addPaytable(pt)
+	return added, ok, nil
+}
+
+// fixedPart is a definition without what a paytable may change, in a
+// canonical form to compare.
+func fixedPart(data []byte) (string, error) {
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", err
+	}
+	delete(m, "scattersForBonus")
+	symbols, _ := m["symbols"].([]any)
+	for _, s := range symbols {
+		if s, ok := s.(map[string]any); ok {
+			delete(s, "weight")
+			delete(s, "payout")
+		}
+	}
+	fixed, err := json.Marshal(m)
+	return string(fixed), err
+}
+
+// MarshalJSON shows the current paytable in place of the one loaded at
+// startup.
+func (g *GameDefinition) MarshalJSON() ([]byte, error) {
+	return g.withPaytable(g.paytable()).MarshalJSON()
+}
+
+// gameWithPaytable is a game as a player sees it under pt, which may be
+// an experiment variant rather than the current version.
+type gameWithPaytable struct {
+	g  *GameDefinition
+	pt *Paytable
+}
+
+func (g *GameDefinition) withPaytable(pt *Paytable) gameWithPaytable {
+	return gameWithPaytable{g, pt}
+}
+
+func (v gameWithPaytable) MarshalJSON() ([]byte, error) {
+	type plain GameDefinition
+	g, pt := v.g, v.pt
+	return json.Marshal(struct {
+		*plain
+		Symbols          []Symbol `json:"symbols"`
+		ScattersForBonus int      `json:"scattersForBonus"`
+		PaytableVersion  int      `json:"paytableVersion"`
+	}{(*plain)(g), pt.Symbols, pt.ScattersForBonus, pt.Version})
+}
+
+// watchGames reloads a game's paytable when its definition file changes.
+// Files are polled for a new modification time or size. Embedded games
+// can't change, so there is nothing to watch without paths.
+func (s *server) watchGames(paths string, every time.Duration) {
+	if paths == "" || every <= 0 {
+		return
+	}
+	type stamp struct {
+		mod  time.Time
+		size int64
+	}
+	seen := map[string]stamp{}
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if fi, err := os.Stat(path); err == nil {
+			seen[path] = stamp{fi.ModTime(), fi.Size()}
+		}
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for path, last := range seen {
+				fi, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if now := (stamp{fi.ModTime(), fi.Size()}); now != last {
+					seen[path] = now
+					s.reloadGameFile(path)
+				}
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// reloadGameFile loads the paytable in the definition file at path. A
+// file that doesn't parse or changes more than the paytable is logged and
+// left alone; the game keeps its current version.
+func (s *server) reloadGameFile(path string) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var head struct {
+			ID string `json:"id"`
+		}
+		if err = json.Unmarshal(data, &head); err == nil && head.ID == "" {
+			err = errUnknownGame
+		}
+		var g *GameDefinition
+		if err == nil {
+			g, err = s.store.games.get(head.ID)
+		}
+		if err == nil {
+			var pt *Paytable
+			var added bool
+			if pt, added, err = g.reloadPaytable(data, "file "+filepath.Base(path)); err == nil && added {
+				slog.Warn("paytable reloaded", "game", g.ID, "version", pt.Version, "hash", pt.Hash, "baseRtp", pt.BaseRTP, "file", path)
+				s.publishPaytable(g, pt)
+			}
+		}
+	}
+	if err != nil {
+		slog.Error("paytable reload failed; keeping the current version", "file", path, "error", err)
+	}
+}
+
+// publishPaytable tells open pages of g that new spins pay differently.
+// During an experiment players stay on their variants, so there is
+// nothing to tell them.
+func (s *server) publishPaytable(g *GameDefinition, pt *Paytable) {
+	if g.experimentRunning() {
+		return
+	}
+	s.store.events.publish("paytable", map[string]any{
+		"game":             g.ID,
+		"version":          pt.Version,
+		"symbols":          pt.Symbols,
+		"scattersForBonus": pt.ScattersForBonus,
+	})
+}
+
+// PaytableView is a paytable version with how its paid spins have done.
+type PaytableView struct {
+	*Paytable
+	Active      bool    `json:"active"`
+	Spins       int     `json:"spins"`
+	Wagered     int     `json:"wagered"`
+	Won         int     `json:"won"`
+	LiveBaseRTP float64 `json:"liveBaseRtp"`
+}
+
+// handleAdminPaytables lists a game's paytable versions with the live
+// base RTP of each, so a change can be checked against its theory. Rounds
+// count by paytable hash: versions with the same hash are the same table
+// and share their figures.
+func (s *server) handleAdminPaytables(w http.ResponseWriter, r *http.Request) {
+	g, err := s.store.games.get(r.PathValue("id"))
+	if err != nil || r.PathValue("id") == "" {
+		writeErr(w, errUnknownGame)
+		return
+	}
+	auditFrom(r.Context()).Target = g.ID
+	current := g.paytable()
+	versions := g.paytableVersions()
+	type played struct{ spins, wagered, won int }
+	byHash := map[string]*played{}
+	for _, pt := range versions {
+		byHash[pt.Hash] = &played{}
+	}
+	s.store.read(func() {
+		for _, round := range s.store.rounds {
+			p := byHash[round.Result.PaytableHash]
+			if round.GameID != g.ID || round.TournamentID != "" || round.DuelID != "" || round.Free || p == nil {
+				continue
+			}
+			p.spins++
+			p.wagered += round.Bet
+			p.won += round.Win
+		}
+	})
+	views := make([]PaytableView, len(versions))
+	for i, pt := range versions {
+		p := byHash[pt.Hash]
+		views[i] = PaytableView{Paytable: pt, Active: pt == current, Spins: p.spins, Wagered: p.wagered, Won: p.won}
+		if p.wagered > 0 {
+			views[i].LiveBaseRTP = float64(p.won) / float64(p.wagered)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"game": g.ID, "current": current.Version, "versions": views})
+}
+
+// handleAdminUploadPaytable takes a whole game definition, as in the game
+// file, and makes its paytable the next version.
+func (s *server) handleAdminUploadPaytable(w http.ResponseWriter, r *http.Request) {
+	g, err := s.store.games.get(r.PathValue("id"))
+	if err != nil || r.PathValue("id") == "" {
+		writeErr(w, errUnknownGame)
+		return
+	}
+	audit := auditFrom(r.Context())
+	audit.Target = g.ID
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pt, added, err := g.reloadPaytable(data, "upload by "+audit.Actor)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	audit.Details = map[string]any{"version": pt.Version, "hash": pt.Hash, "added": added}
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+		slog.WarnContext(r.Context(), "paytable uploaded", "game", g.ID, "version", pt.Version, "hash", pt.Hash, "baseRtp", pt.BaseRTP)
+		s.publishPaytable(g, pt)
+	}
+	writeJSON(w, status, map[string]any{"paytable": pt, "added": added})
+}
+
+// handleAdminActivatePaytable rolls a game back (or forward) to a version
+// it has already loaded. Rounds already played keep their version.
+func (s *server) handleAdminActivatePaytable(w http.ResponseWriter, r *http.Request) {
+	g, err := s.store.games.get(r.PathValue("id"))
+	if err != nil || r.PathValue("id") == "" {
+		writeErr(w, errUnknownGame)
+		return
+	}
+	version, _ := strconv.Atoi(r.PathValue("version"))
+	audit := auditFrom(r.Context())
+	audit.Target = g.ID
+	audit.Details = map[string]any{"from": g.paytable().Version, "to": version}
+	pt, err := g.activatePaytable(version)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	slog.WarnContext(r.Context(), "paytable activated", "game", g.ID, "version", pt.Version, "hash", pt.Hash)
+	s.publishPaytable(g, pt)
+	writeJSON(w, http.StatusOK, map[string]any{"paytable": pt})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadPaytable(t *testing.T) {
+	orig, err := os.ReadFile("games/chess-slots.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := func(old, new string) []byte {
+		return []byte(strings.Replace(string(orig), old, new, 1))
+	}
+	queenRarer := edit(`"name": "Queen", "weight": 2`, `"name": "Queen", "weight": 1`)
+	// Each step runs on the game as the steps before it left it
+	steps := []struct {
+		name     string
+		data     []byte
+		activate int // roll to this version instead of loading data
+		version  int // current afterwards
+		added    bool
+		err      error
+	}{
+		{name: "unchanged file", data: orig, version: 1},
+		{name: "new weight", data: queenRarer, version: 2, added: true},
+		{name: "same again", data: queenRarer, version: 2},
+		{name: "new payout", data: edit(`"payout": 100`, `"payout": 120`), version: 3, added: true},
+		{name: "scatters for the bonus", data: edit(`"scattersForBonus": 3`, `"scattersForBonus": 4`), version: 4, added: true},
+		{name: "more than the paytable", data: edit(`"spinCost": 5`, `"spinCost": 10`), version: 4, err: errPaytableOnly},
+		{name: "roll back", activate: 2, version: 2},
+		{name: "roll forward", activate: 4, version: 4},
+		{name: "unknown version", activate: 5, version: 4, err: errNoPaytable},
+		{name: "back to the original", data: orig, version: 5, added: true},
+	}
+	g := newTestStore(t).games.house()
+	for _, st := range steps {
+		var err error
+		var added bool
+		if st.activate > 0 {
+			_, err = g.activatePaytable(st.activate)
+		} else {
+			_, added, err = g.reloadPaytable(st.data, "test")
+		}
+		if !errors.Is(err, st.err) {
+			t.Fatalf("%s: got error %v, want %v", st.name, err, st.err)
+		}
+		if added != st.added || g.paytable().Version != st.version {
+			t.Fatalf("%s: added %v, now on version %d; want %v, version %d", st.name, added, g.paytable().Version, st.added, st.version)
+		}
+	}
+	if v1, v5 := g.paytableVersions()[0], g.paytable(); v1.Hash != v5.Hash {
+		t.Errorf("the original reloaded hashes to %s, want %s as at startup", v5.Hash, v1.Hash)
+	}
+}
+
+func TestReloadPaytableRefused(t *testing.T) {
+	orig, err := os.ReadFile("games/chess-slots.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "not JSON", data: "{"},
+		{name: "another game", data: strings.Replace(string(orig), `"id": "chess-slots"`, `"id": "knights-quest"`, 1)},
+		{name: "invalid paytable", data: strings.Replace(string(orig), `"weight": 2, "payout": 100`, `"weight": -2, "payout": 100`, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestStore(t).games.house()
+			if _, _, err := g.reloadPaytable([]byte(tt.data), "test"); err == nil {
+				t.Error("reloaded, want an error")
+			}
+			if v := g.paytable().Version; v != 1 {
+				t.Errorf("version = %d after a refused reload, want 1", v)
+			}
+		})
+	}
+}
+
+// Versions, the one rolled back to and a running experiment come back
+// from the store file. A definition edited while the server was down is
+// the next version.
+func TestRestorePaytables(t *testing.T) {
+	orig, err := os.ReadFile("games/chess-slots.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queenRarer := strings.Replace(string(orig), `"name": "Queen", "weight": 2`, `"name": "Queen", "weight": 1`, 1)
+	path := filepath.Join(t.TempDir(), "store.json")
+	s := newTestStore(t)
+	g := s.games.house()
+	if _, _, err := g.reloadPaytable([]byte(queenRarer), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.activatePaytable(1); err != nil {
+		t.Fatal(err)
+	}
+	e := &Experiment{ID: "e1", Variants: []Variant{{Name: "a", Version: 1, Weight: 1}, {Name: "b", Version: 2, Weight: 1}}}
+	if err := g.startExperiment(e); err != nil {
+		t.Fatal(err)
+	}
+	s.changes++
+	if err := s.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		startup  string
+		versions int
+		current  int
+	}{
+		{name: "file unchanged", startup: string(orig), versions: 2, current: 1},
+		{name: "file edited while down", startup: strings.Replace(string(orig), `"payout": 100`, `"payout": 120`, 1), versions: 3, current: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def, err := parseGameDefinition([]byte(tt.startup))
+			if err != nil {
+				t.Fatal(err)
+			}
+			games, err := newRegistry([]*GameDefinition{def})
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := NewStore(games)
+			loaded.switches = newSwitches(nil)
+			if err := loaded.load(path); err != nil {
+				t.Fatal(err)
+			}
+			versions := def.paytableVersions()
+			if len(versions) != tt.versions || def.paytable().Version != tt.current {
+				t.Fatalf("%d versions, version %d current; want %d, version %d", len(versions), def.paytable().Version, tt.versions, tt.current)
+			}
+			for i, pt := range g.paytableVersions() {
+				if versions[i].Hash != pt.Hash || versions[i].Version != pt.Version || versions[i].BaseRTP != pt.BaseRTP {
+					t.Errorf("version %d = %s, want %s as saved", i+1, versions[i].Hash, pt.Hash)
+				}
+			}
+			if def.paytable().Hash != versions[tt.current-1].Hash {
+				t.Errorf("current paytable hashes to %s, want version %d's", def.paytable().Hash, tt.current)
+			}
+			kept := def.paytables.experiment.Load()
+			if kept == nil || kept.ID != "e1" || !def.experimentRunning() {
+				t.Fatalf("experiment = %+v, want e1 still running", kept)
+			}
+			for i, v := range kept.Variants {
+				if v.paytable != versions[v.Version-1] {
+					t.Errorf("variant %s plays version %d, want %d", v.Name, v.paytable.Version, e.Variants[i].Version)
+				}
+			}
+		})
+	}
+}
+
+// The report counts rounds by the hash they were played under, so rounds
+// from before a restart, under the same version number, stay apart.
+func TestAdminPaytablesByHash(t *testing.T) {
+	const token = "0123456789abcdef0123"
+	s := newTestStore(t)
+	g := s.games.house()
+	v1 := g.paytable()
+	s.rounds = []Round{
+		{ID: "r1", GameID: g.ID, Bet: 10, Win: 5, Result: SpinResult{PaytableVersion: 1, PaytableHash: v1.Hash}},
+		{ID: "r2", GameID: g.ID, Bet: 10, Win: 15, Result: SpinResult{PaytableVersion: 1, PaytableHash: v1.Hash}},
+		// Version 1 of an earlier boot, and a free spin
+		{ID: "r3", GameID: g.ID, Bet: 10, Win: 900, Result: SpinResult{PaytableVersion: 1, PaytableHash: "0123456789ab"}},
+		{ID: "r4", GameID: g.ID, Win: 900, Free: true, Result: SpinResult{PaytableVersion: 1, PaytableHash: v1.Hash}},
+	}
+	cfg := defaultConfig()
+	cfg.AdminToken = token
+	srv := newServer(s, cfg)
+	r := httptest.NewRequest("GET", srv.base+"/admin/api/games/chess-slots/paytables", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.routes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var resp struct {
+		Versions []PaytableView `json:"versions"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Versions) != 1 {
+		t.Fatalf("%d versions, want 1", len(resp.Versions))
+	}
+	if v := resp.Versions[0]; v.Spins != 2 || v.Wagered != 20 || v.Won != 20 || v.LiveBaseRTP != 1 {
+		t.Errorf("version 1 = %d spins, %d wagered, %d won, live %v; want 2, 20, 20, 1", v.Spins, v.Wagered, v.Won, v.LiveBaseRTP)
+	}
+}
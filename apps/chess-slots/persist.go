@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How often the store is written to its file when anything has changed
+const storeSaveInterval = 5 * time.Second
+
+// storeFile is the path in a "file:<path>" storage setting, or "" for
+// memory.
+func storeFile(storage string) string {
+	path, ok := strings.CutPrefix(storage, "file:")
+	if !ok {
+		return ""
+	}
+	return path
+}
+
+// checkStoreDir makes sure the store file can be saved: its directory
+// exists and takes new files, which is how save writes. The file itself
+// need not exist yet.
+func checkStoreDir(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// storeSnapshot is the store as written to its file: every wallet, what a
+// player needs to carry on after a restart, and each game's paytable
+// versions and A/B test. Tournaments, duels in play, autoplay and open
+// streams are not kept; a duel buy-in that was never settled is refunded
+// when the file is loaded.
+type storeSnapshot struct {
+	SavedAt     time.Time
+	Players     []playerSnapshot
+	Ledger      []LedgerEntry
+	Rounds      []Round
+	Leaderboard map[string]map[string]statsSnapshot
+	ClaimsDay   string
+	ClaimsByIP  map[string]int
+	Jackpot     float64
+	// By game ID
+	Paytables map[string]paytablesSnapshot `json:",omitempty"`
+}
+
+type playerSnapshot struct {
+	ID        string
+	Balance   int
+	CreatedAt time.Time
+	Bonus     *bonusSnapshot `json:",omitempty"`
+	FreeSpins *FreeSpins     `json:",omitempty"`
+	Safety    PlaySafety
+	Rewards   Rewards
+	Badges    Badges
+	Spectate  Spectate
+}
+
+// bonusSnapshot keeps an open pick bonus with its board and salt, so the
+// commitment still holds after a restart.
+type bonusSnapshot struct {
+	PickBonus
+	Board [boardSquares]PickSquare
+	Salt  string
+}
+
+// paytablesSnapshot keeps a game's versions, so the version numbers in
+// rounds and experiments still mean the same table after a restart.
+type paytablesSnapshot struct {
+	Versions   []*Paytable
+	Current    int
+	Experiment *Experiment `json:",omitempty"`
+}
+
+type statsSnapshot struct {
+	PlayerStats
+	CurrentStreak int
+}
+
+// snapshot copies what the file keeps, deep enough to be marshalled after
+// s.mu is released. The ledger and rounds are only ever appended to, so
+// capping their capacity is copy enough, and paytable versions and
+// experiments never change once loaded. Callers must hold s.mu.
+func (s *Store) snapshot() *storeSnapshot {
+	snap := &storeSnapshot{
+		SavedAt:     time.Now(),
+		Ledger:      s.ledger[:len(s.ledger):len(s.ledger)],
+		Rounds:      s.rounds[:len(s.rounds):len(s.rounds)],
+		Leaderboard: map[string]map[string]statsSnapshot{},
+		ClaimsDay:   s.claimsDay,
+		ClaimsByIP:  maps.Clone(s.claimsByIP),
+		Jackpot:     s.jackpot,
+		Paytables:   map[string]paytablesSnapshot{},
+	}
+	for _, g := range s.games.games {
+		snap.Paytables[g.ID] = paytablesSnapshot{
+			Versions:   g.paytableVersions(),
+			Current:    g.paytable().Version,
+			Experiment: g.paytables.experiment.Load(),
+		}
+	}
+	for _, p := range s.players {
+		ps := playerSnapshot{
+			ID: p.ID, Balance: p.Balance, CreatedAt: p.CreatedAt,
+			Safety: p.Safety, Rewards: p.Rewards, Spectate: p.Spectate,
+			Badges: Badges{Earned: maps.Clone(p.Badges.Earned), Progress: maps.Clone(p.Badges.Progress)},
+		}
+		if f := p.FreeSpins; f != nil {
+			free := *f
+			ps.FreeSpins = &free
+		}
+		if b := p.Bonus; b != nil && !b.Finished() {
+			ps.Bonus = &bonusSnapshot{PickBonus: *b, Board: b.board, Salt: b.salt}
+		}
+		snap.Players = append(snap.Players, ps)
+	}
+	for period, players := range s.leaderboard.periods {
+		stats := map[string]statsSnapshot{}
+		for id, st := range players {
+			stats[id] = statsSnapshot{PlayerStats: *st, CurrentStreak: st.currentStreak}
+		}
+		snap.Leaderboard[period] = stats
+	}
+	return snap
+}
+
+// restore replaces the store's state with snap. Callers must hold s.mu.
+func (s *Store) restore(snap *storeSnapshot) {
+	s.players = map[string]*Player{}
+	s.spectators = map[string]string{}
+	for _, ps := range snap.Players {
+		p := &Player{
+			ID: ps.ID, Balance: ps.Balance, CreatedAt: ps.CreatedAt, FreeSpins: ps.FreeSpins,
+			Safety: ps.Safety, Rewards: ps.Rewards, Badges: ps.Badges, Spectate: ps.Spectate,
+		}
+		if b := ps.Bonus; b != nil {
+			bonus := b.PickBonus
+			bonus.board, bonus.salt = b.Board, b.Salt
+			p.Bonus = &bonus
+		}
+		if p.Spectate.Token != "" {
+			s.spectators[p.Spectate.Token] = p.ID
+		}
+		s.players[p.ID] = p
+	}
+	s.ledger, s.rounds = snap.Ledger, snap.Rounds
+	s.claimsDay, s.claimsByIP = snap.ClaimsDay, snap.ClaimsByIP
+	// A file from before the jackpot starts it from the seed
+	s.jackpot = max(snap.Jackpot, float64(s.game.Jackpot.Seed))
+	s.jackpotShown = s.jackpotAmount()
+	s.leaderboard = NewLeaderboard()
+	for period, players := range snap.Leaderboard {
+		stats := map[string]*PlayerStats{}
+		for id, st := range players {
+			ps := st.PlayerStats
+			ps.currentStreak = st.CurrentStreak
+			stats[id] = &ps
+		}
+		s.leaderboard.periods[period] = stats
+	}
+	for id, kept := range snap.Paytables {
+		// A game no longer hosted keeps its rounds but not its versions
+		g, err := s.games.get(id)
+		if err != nil || id == "" {
+			continue
+		}
+		g.restorePaytables(kept.Versions, kept.Current)
+		if e := kept.Experiment; e != nil {
+			if err := g.restoreExperiment(e); err != nil {
+				slog.Warn("dropped an experiment the store file kept", "game", id, "experiment", e.ID, "error", err)
+			}
+		}
+	}
+	s.refundUnsettledDuels()
+}
+
+// refundUnsettledDuels gives back buy-ins for duels that were still open
+// when the snapshot was taken: ones with neither a refund nor a pot paid.
+// Callers must hold s.mu.
+func (s *Store) refundUnsettledDuels() {
+	type buyIn struct {
+		playerID string
+		amount   int
+	}
+	buyIns := map[string][]buyIn{}
+	settled := map[string]bool{}
+	for _, e := range s.ledger {
+		switch e.Kind {
+		case "duel_buyin":
+			buyIns[e.RoundID] = append(buyIns[e.RoundID], buyIn{e.PlayerID, -e.Amount})
+		case "duel_refund", "duel_pot":
+			settled[e.RoundID] = true
+		}
+	}
+	for duelID, paid := range buyIns {
+		if settled[duelID] {
+			continue
+		}
+		for _, b := range paid {
+			if p, ok := s.players[b.playerID]; ok {
+				s.post(p, "duel_refund", b.amount, duelID)
+				slog.Warn("refunded a duel left open by a restart", "duel", duelID, "player", p.ID, "amount", b.amount)
+			}
+		}
+	}
+}
+
+// load reads the store from path. A missing file is a first start.
+func (s *Store) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap storeSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restore(&snap)
+	s.saved = s.changes
+	return nil
+}
+
+// save writes the store to path if it changed since the last save. The
+// file is written beside path and renamed over it, so a crash mid-write
+// leaves the previous snapshot whole.
+func (s *Store) save(path string) error {
+	s.mu.Lock()
+	if s.changes == s.saved {
+		s.mu.Unlock()
+		return nil
+	}
+	changes := s.changes
+	snap := s.snapshot()
+	s.mu.Unlock()
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.saved = max(s.saved, changes)
+	s.mu.Unlock()
+	return nil
+}
+
+// saveStore writes the store to path every storeSaveInterval until the
+// server starts shutting down.
+func (s *server) saveStore(path string) {
+	ticker := time.NewTicker(storeSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.store.save(path); err != nil {
+				slog.Error("could not save the store", "file", path, "error", err)
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
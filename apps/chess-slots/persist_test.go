@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s := newTestStore(t)
+	g := s.games.house()
+	s.createPlayer("p1")
+	s.createPlayer("p2")
+	p1, p2 := s.players["p1"], s.players["p2"]
+	if _, err := s.spin(p1, g); err != nil {
+		t.Fatal(err)
+	}
+	p1.Bonus = fixedBonus(g.SpinCost)
+	s.pickSquare(p1, 20)
+	// A duel buy-in the restart caught before the duel was settled
+	s.post(p2, "duel_buyin", -50, "d1")
+	if err := s.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestStore(t)
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		player    string
+		balance   int
+		bonusOpen bool
+	}{
+		{name: "spun, bonus open", player: "p1", balance: p1.Balance, bonusOpen: true},
+		{name: "duel buy-in refunded", player: "p2", balance: p2.Balance + 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := loaded.players[tt.player]
+			if !ok {
+				t.Fatalf("player %s was not loaded", tt.player)
+			}
+			if p.Balance != tt.balance {
+				t.Errorf("balance = %d, want %d", p.Balance, tt.balance)
+			}
+			if (p.Bonus != nil) != tt.bonusOpen {
+				t.Fatalf("bonus open = %v, want %v", p.Bonus != nil, tt.bonusOpen)
+			}
+			if p.Bonus == nil {
+				return
+			}
+			if p.Bonus.encodeBoard() != p1.Bonus.encodeBoard() || p.Bonus.Commitment != commitBoard(p.Bonus.salt, p.Bonus.encodeBoard()) {
+				t.Error("the loaded bonus board no longer matches its commitment")
+			}
+			if len(p.Bonus.Picks) != 1 || p.Bonus.Coins != p1.Bonus.Coins {
+				t.Errorf("loaded bonus picks %v coins %d, want the one pick and %d coins", p.Bonus.Picks, p.Bonus.Coins, p1.Bonus.Coins)
+			}
+		})
+	}
+	if got, want := len(loaded.ledger), len(s.ledger)+1; got != want {
+		t.Errorf("ledger has %d entries, want %d with the refund", got, want)
+	}
+}
+
+func TestSnapshotIsACopy(t *testing.T) {
+	s := newTestStore(t)
+	g := s.games.house()
+	s.createPlayer("p1")
+	p := s.players["p1"]
+	if _, err := s.spin(p, g); err != nil {
+		t.Fatal(err)
+	}
+	p.FreeSpins = &FreeSpins{GameID: g.ID, Left: 3}
+	p.Badges = Badges{Earned: map[string]time.Time{"first_spin": time.Now()}, Progress: map[string]int{"spins": 1}}
+	s.claimsByIP = map[string]int{"203.0.113.1": 1}
+	snap := s.snapshot()
+	before, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Play on after the lock is released, as a save's marshalling would see
+	if _, err := s.spin(p, g); err != nil {
+		t.Fatal(err)
+	}
+	p.FreeSpins.Left--
+	p.Badges.Earned["big_win"] = time.Now()
+	p.Badges.Progress["spins"]++
+	s.claimsByIP["203.0.113.2"] = 1
+	after, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("the snapshot changed with the store")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errRateLimited = errors.New("you are spinning too fast; slow down")
+
+// RateLimitError is a spin refused by a rate limit. Scope is "player" or
+// "ip", or "session" for a new session.
+type RateLimitError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Scope == "session" {
+		return "too many new sessions from this address; try again later"
+	}
+	return errRateLimited.Error()
+}
+
+func (e *RateLimitError) Unwrap() error { return errRateLimited }
+
+// setRetryAfter adds a Retry-After header, in whole seconds, when err is a
+// rate limit.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var rl *RateLimitError
+	if errors.As(err, &rl) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rl.RetryAfter.Seconds()))))
+	}
+}
+
+// A tokenBucket holds up to burst tokens and gains rate of them a second.
+// Each spin takes one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// Set by a refusal and cleared by the next spin let through, so a
+	// burst of refusals is reported once
+	limited bool
+}
+
+// rateLimiter keeps a bucket per key: a player ID or a client IP.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}}
+}
+
+// take spends one of key's tokens. When there is none it says how long
+// until there is, and whether this refusal starts a new run of them.
+func (l *rateLimiter) take(key string, now time.Time) (ok bool, wait time.Duration, first bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0, false
+	}
+	wait = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	first = !b.limited
+	b.limited = true
+	return false, wait, first
+}
+
+// prune forgets buckets that have filled up again; they would start full
+// anyway.
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
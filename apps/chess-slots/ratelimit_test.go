@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	type take struct {
+		at    time.Duration // after the first take
+		key   string
+		ok    bool
+		wait  time.Duration
+		first bool
+	}
+	// 2 tokens a second, up to 3 at once
+	tests := []struct {
+		name  string
+		takes []take
+	}{
+		{
+			name: "burst, then refused",
+			takes: []take{
+				{ok: true}, {ok: true}, {ok: true},
+				{wait: 500 * time.Millisecond, first: true},
+			},
+		},
+		{
+			name: "a run of refusals is reported once",
+			takes: []take{
+				{ok: true}, {ok: true}, {ok: true},
+				{wait: 500 * time.Millisecond, first: true},
+				{at: 100 * time.Millisecond, wait: 400 * time.Millisecond},
+				{at: 500 * time.Millisecond, ok: true},
+				{at: 500 * time.Millisecond, wait: 500 * time.Millisecond, first: true},
+			},
+		},
+		{
+			name: "refills at the rate",
+			takes: []take{
+				{ok: true}, {ok: true}, {ok: true},
+				{at: time.Second, ok: true}, {at: time.Second, ok: true},
+				{at: time.Second, wait: 500 * time.Millisecond, first: true},
+			},
+		},
+		{
+			name: "refills no higher than the burst",
+			takes: []take{
+				{ok: true},
+				{at: time.Hour, ok: true}, {at: time.Hour, ok: true}, {at: time.Hour, ok: true},
+				{at: time.Hour, wait: 500 * time.Millisecond, first: true},
+			},
+		},
+		{
+			name: "keys have their own buckets",
+			takes: []take{
+				{key: "a", ok: true}, {key: "a", ok: true}, {key: "a", ok: true},
+				{key: "a", wait: 500 * time.Millisecond, first: true},
+				{key: "b", ok: true},
+			},
+		},
+	}
+	start := time.Date(2026, 3, 11, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(2, 3)
+			for i, tk := range tt.takes {
+				ok, wait, first := l.take(tk.key, start.Add(tk.at))
+				if ok != tk.ok || wait != tk.wait || first != tk.first {
+					t.Fatalf("take %d: got ok=%v wait=%v first=%v, want ok=%v wait=%v first=%v",
+						i+1, ok, wait, first, tk.ok, tk.wait, tk.first)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	start := time.Date(2026, 3, 11, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter(2, 3)
+	l.take("a", start)
+	l.take("a", start)
+	l.take("b", start)
+	tests := []struct {
+		at   time.Duration
+		kept int
+	}{
+		{at: 0, kept: 2},
+		{at: 500 * time.Millisecond, kept: 1}, // b is full again
+		{at: time.Second, kept: 0},
+	}
+	for _, tt := range tests {
+		l.prune(start.Add(tt.at))
+		if got := len(l.buckets); got != tt.kept {
+			t.Errorf("after %v: %d buckets, want %d", tt.at, got, tt.kept)
+		}
+	}
+}
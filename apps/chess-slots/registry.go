@@ -0,0 +1,111 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+//go:embed games/*.json
+var embeddedGames embed.FS
+
+var errUnknownGame = errors.New("no such game")
+
+// Registry is every slot game the server hosts, in lobby order. The games
+// share one wallet, history, leaderboard and jackpot, so platform policy
+// (starting coins, free coins, the jackpot, achievements, tournaments and
+// duels) comes from the first game, the house game. The rest only bring their own paytable, reels,
+// presentation and theme.
+type Registry struct {
+	games []*GameDefinition
+	byID  map[string]*GameDefinition
+}
+
+// loadRegistry reads the comma-separated game definition paths, or every
+// embedded games/*.json (in file name order) when paths is empty.
+func loadRegistry(paths string) (*Registry, error) {
+	var games []*GameDefinition
+	if paths == "" {
+		names, err := fs.Glob(embeddedGames, "games/*.json")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			data, err := embeddedGames.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			g, err := parseGameDefinition(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			games = append(games, g)
+		}
+	} else {
+		for _, path := range strings.Split(paths, ",") {
+			g, err := loadGameDefinition(strings.TrimSpace(path))
+			if err != nil {
+				return nil, err
+			}
+			games = append(games, g)
+		}
+	}
+	return newRegistry(games)
+}
+
+func newRegistry(games []*GameDefinition) (*Registry, error) {
+	if len(games) == 0 {
+		return nil, errors.New("no games")
+	}
+	r := &Registry{games: games, byID: map[string]*GameDefinition{}}
+	for _, g := range games {
+		if _, dup := r.byID[g.ID]; dup {
+			return nil, fmt.Errorf("duplicate game id %q", g.ID)
+		}
+		r.byID[g.ID] = g
+	}
+	return r, nil
+}
+
+// house is the game whose policy applies across the platform.
+func (r *Registry) house() *GameDefinition {
+	return r.games[0]
+}
+
+// get returns the game with id, or the house game when id is empty.
+func (r *Registry) get(id string) (*GameDefinition, error) {
+	if id == "" {
+		return r.house(), nil
+	}
+	g, ok := r.byID[id]
+	if !ok {
+		return nil, errUnknownGame
+	}
+	return g, nil
+}
+
+// GameSummary is a game's lobby card.
+type GameSummary struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	SpinCost int    `json:"spinCost"`
+	Theme    Theme  `json:"theme"`
+	// The top paying symbol, shown on the card
+	TopSymbol string `json:"topSymbol"`
+}
+
+func (r *Registry) summaries() []GameSummary {
+	list := make([]GameSummary, 0, len(r.games))
+	for _, g := range r.games {
+		top := Symbol{}
+		for _, s := range g.paytable().Symbols {
+			if !s.Scatter && s.Payout > top.Payout {
+				top = s
+			}
+		}
+		list = append(list, GameSummary{ID: g.ID, Name: g.Name, SpinCost: g.SpinCost, Theme: g.Theme, TopSymbol: top.Symbol})
+	}
+	return list
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	errAlreadyClaimed = errors.New("today's bonus has already been claimed")
+	errRefillNotReady = errors.New("refill is not available yet")
+	errTooManyClaims  = errors.New("too many bonus claims from this network today")
+)
+
+// EconomyPolicy is how free coins come into the game.
+type EconomyPolicy struct {
+	// DailyBonus[n] is paid on day n+1 of a claim streak; the last entry
+	// repeats for longer streaks.
+	DailyBonus []int `json:"dailyBonus"`
+	// Players who can't afford a spin are topped up to RefillTo once
+	// RefillCooldownMinutes have passed.
+	RefillTo              int `json:"refillTo"`
+	RefillCooldownMinutes int `json:"refillCooldownMinutes"`
+	// Caps daily bonus claims per client IP so clearing cookies doesn't
+	// farm coins.
+	MaxDailyClaimsPerIP int `json:"maxDailyClaimsPerIP"`
+}
+
+func (e EconomyPolicy) validate(spinCost int) error {
+	for _, v := range e.DailyBonus {
+		if v <= 0 {
+			return errors.New("dailyBonus amounts must be positive")
+		}
+	}
+	if e.RefillTo != 0 && (e.RefillTo < spinCost || e.RefillCooldownMinutes <= 0) {
+		return errors.New("refillTo must cover a spin and needs a positive cooldown")
+	}
+	if e.MaxDailyClaimsPerIP < 0 {
+		return errors.New("maxDailyClaimsPerIP cannot be negative")
+	}
+	return nil
+}
+
+func (e EconomyPolicy) dailyAmount(streak int) int {
+	if len(e.DailyBonus) == 0 {
+		return 0
+	}
+	if streak > len(e.DailyBonus) {
+		streak = len(e.DailyBonus)
+	}
+	return e.DailyBonus[streak-1]
+}
+
+// Rewards is a player's claim history.
+type Rewards struct {
+	LastDailyClaim string    `json:"lastDailyClaim"` // UTC day, "2006-01-02"
+	Streak         int       `json:"streak"`
+	RefillAt       time.Time `json:"refillAt"`
+}
+
+type RewardsStatus struct {
+	Daily struct {
+		Available bool      `json:"available"`
+		Amount    int       `json:"amount"`
+		Streak    int       `json:"streak"`
+		NextAt    time.Time `json:"nextAt"`
+	} `json:"daily"`
+	Refill struct {
+		Pending   bool      `json:"pending"`
+		Available bool      `json:"available"`
+		Amount    int       `json:"amount"`
+		At        time.Time `json:"at"`
+	} `json:"refill"`
+}
+
+func dayKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// trackRefill starts the refill timer as soon as p can't afford a spin
+// and clears it once they can. Callers must hold s.mu.
+func (s *Store) trackRefill(p *Player, now time.Time) {
+	e := s.game.Economy
+	switch {
+	case e.RefillTo == 0 || p.Balance >= s.game.SpinCost:
+		p.Rewards.RefillAt = time.Time{}
+	case p.Rewards.RefillAt.IsZero():
+		p.Rewards.RefillAt = now.Add(time.Duration(e.RefillCooldownMinutes) * time.Minute)
+	}
+}
+
+// rewardsStatus is what p could claim right now. Callers must hold s.mu.
+func (s *Store) rewardsStatus(p *Player, now time.Time) RewardsStatus {
+	var st RewardsStatus
+	e := s.game.Economy
+
+	// The streak is still alive if the last claim was today or yesterday
+	today := dayKey(now)
+	if last := p.Rewards.LastDailyClaim; last == today || last == dayKey(now.AddDate(0, 0, -1)) {
+		st.Daily.Streak = p.Rewards.Streak
+	}
+	st.Daily.Available = len(e.DailyBonus) > 0 && p.Rewards.LastDailyClaim != today
+	st.Daily.Amount = e.dailyAmount(st.Daily.Streak + 1)
+	st.Daily.NextAt = nextDay(now)
+	if st.Daily.Available {
+		st.Daily.NextAt = now
+	}
+
+	if !p.Rewards.RefillAt.IsZero() {
+		st.Refill.Pending = true
+		st.Refill.At = p.Rewards.RefillAt
+		st.Refill.Available = !now.Before(p.Rewards.RefillAt)
+		st.Refill.Amount = e.RefillTo - p.Balance
+	}
+	return st
+}
+
+// claimDaily pays today's login bonus and extends the streak. Callers
+// must hold s.mu.
+func (s *Store) claimDaily(p *Player, ip string, now time.Time) (int, error) {
+	st := s.rewardsStatus(p, now)
+	if !st.Daily.Available {
+		return 0, errAlreadyClaimed
+	}
+
+	today := dayKey(now)
+	if s.claimsDay != today {
+		s.claimsDay, s.claimsByIP = today, map[string]int{}
+	}
+	if limit := s.game.Economy.MaxDailyClaimsPerIP; limit > 0 && s.claimsByIP[ip] >= limit {
+		return 0, errTooManyClaims
+	}
+	s.claimsByIP[ip]++
+
+	if p.Rewards.LastDailyClaim == dayKey(now.AddDate(0, 0, -1)) {
+		p.Rewards.Streak++
+	} else {
+		p.Rewards.Streak = 1
+	}
+	p.Rewards.LastDailyClaim = today
+	s.post(p, "daily_bonus", st.Daily.Amount, "")
+	return st.Daily.Amount, nil
+}
+
+// claimRefill tops p back up once the refill timer has run out. Callers
+// must hold s.mu.
+func (s *Store) claimRefill(p *Player, now time.Time) (int, error) {
+	st := s.rewardsStatus(p, now)
+	if !st.Refill.Available {
+		return 0, errRefillNotReady
+	}
+	s.post(p, "refill", st.Refill.Amount, "")
+	return st.Refill.Amount, nil
+}
+
+// clientIP is the caller's address. Behind trusted proxies, viaProxies
+// has already put it in RemoteAddr; X-Forwarded-For is never read here,
+// since the caller can set it to anything.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *server) handleRewards(w http.ResponseWriter, r *http.Request) {
+	var st RewardsStatus
+	s.store.Update(playerID(r), func(p *Player) error {
+		st = s.store.rewardsStatus(p, time.Now())
+		return nil
+	})
+	writeJSON(w, http.StatusOK, st)
+}
+
+type claimResponse struct {
+	Amount  int           `json:"amount"`
+	Balance int           `json:"balance"`
+	Rewards RewardsStatus `json:"rewards"`
+}
+
+func (s *server) handleClaim(claim func(p *Player, r *http.Request, now time.Time) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resp claimResponse
+		err := s.store.Update(playerID(r), func(p *Player) error {
+			now := time.Now()
+			amount, err := claim(p, r, now)
+			if err != nil {
+				return err
+			}
+			resp = claimResponse{Amount: amount, Balance: p.Balance, Rewards: s.store.rewardsStatus(p, now)}
+			return nil
+		})
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleClaimDaily() http.HandlerFunc {
+	return s.handleClaim(func(p *Player, r *http.Request, now time.Time) (int, error) {
+		return s.store.claimDaily(p, clientIP(r), now)
+	})
+}
+
+func (s *server) handleClaimRefill() http.HandlerFunc {
+	return s.handleClaim(func(p *Player, r *http.Request, now time.Time) (int, error) {
+		return s.store.claimRefill(p, now)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The daily bonus is capped per client address. A caller who makes up
+// X-Forwarded-For entries is still counted at the address the server (or
+// its trusted proxy) saw.
+func TestClaimDailyCapPerIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   int
+		forwarded func(i int) string // X-Forwarded-For for the i-th player
+		capped    bool
+	}{
+		{
+			name:      "no proxy, spoofed header",
+			forwarded: func(i int) string { return fmt.Sprintf("10.0.0.%d", i) },
+			capped:    true,
+		},
+		{
+			name:      "one proxy, spoofed entries before its own",
+			proxies:   1,
+			forwarded: func(i int) string { return fmt.Sprintf("10.0.0.%d, 203.0.113.9", i) },
+			capped:    true,
+		},
+		{
+			name:      "one proxy, different callers",
+			proxies:   1,
+			forwarded: func(i int) string { return fmt.Sprintf("203.0.113.%d", i) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(newTestStore(t), defaultConfig())
+			h := viaProxies(srv.routes(), tt.proxies)
+			limit := srv.store.game.Economy.MaxDailyClaimsPerIP
+			do := func(path, cookie, forwarded string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest("POST", srv.base+"/api/"+path, nil)
+				r.RemoteAddr = "192.0.2.1:4711"
+				r.Header.Set("X-Forwarded-For", forwarded)
+				if cookie != "" {
+					r.AddCookie(&http.Cookie{Name: playerCookie, Value: cookie})
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+
+			refused := 0
+			for i := 0; i < limit+2; i++ {
+				fwd := tt.forwarded(i)
+				w := do("session", "", fwd)
+				if w.Code != http.StatusCreated {
+					t.Fatalf("session %d: %d %s", i, w.Code, w.Body)
+				}
+				cookie := w.Result().Cookies()[0].Value
+				switch w := do("rewards/daily", cookie, fwd); w.Code {
+				case http.StatusOK:
+				case http.StatusTooManyRequests:
+					refused++
+				default:
+					t.Fatalf("claim %d: %d %s", i, w.Code, w.Body)
+				}
+			}
+			want := 0
+			if tt.capped {
+				want = 2
+			}
+			if refused != want {
+				t.Errorf("%d of %d claims refused, want %d", refused, limit+2, want)
+			}
+		})
+	}
+}
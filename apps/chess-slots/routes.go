@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const defaultBasePath = "/apps/chess-slots"
+
+// cleanBasePath turns BASE_PATH into "" or "/prefix" without a trailing
+// slash.
+func cleanBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// routes registers every page and API route under the base path.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	base := s.base
+
+	// Platform endpoints answer at the root and under the base path
+	prefixes := []string{""}
+	if base != "" {
+		prefixes = append(prefixes, base)
+	}
+	for _, prefix := range prefixes {
+		mux.HandleFunc("GET "+prefix+"/health", s.handleLiveness)
+		mux.HandleFunc("GET "+prefix+"/healthz", s.handleLiveness)
+		mux.HandleFunc("GET "+prefix+"/readyz", s.handleReadiness)
+		// Metrics show play and RTP, so they need the admin token
+		if s.admin != nil {
+			mux.HandleFunc("GET "+prefix+"/metrics", s.tokenOnly(s.handleMetrics))
+		}
+	}
+	if base != "" {
+		mux.Handle("GET /{$}", http.RedirectHandler(base+"/", http.StatusFound))
+	}
+	mux.HandleFunc("GET "+base+"/{$}", s.serveLobby)
+	mux.HandleFunc("GET "+base+"/{game}", s.serveGamePage)
+	mux.HandleFunc("GET "+base+"/static/{file...}", serveStatic)
+
+	// Reads, cancels and refunds stay open while a feature is off, so
+	// nobody is left stuck in it. Maintenance refuses new rounds but lets
+	// open ones (bonus picks, a duel in progress) settle
+	api := func(method, path string, h http.HandlerFunc) {
+		mux.HandleFunc(method+" "+base+"/api/"+path, h)
+	}
+	// Player routes need a session, which only POST api/session starts
+	player := func(method, path string, h http.HandlerFunc) {
+		api(method, path, s.requireSession(h))
+	}
+	api("POST", "session", s.handleSession)
+	api("GET", "games", s.handleGames)
+	api("GET", "game", s.handleGame)
+	player("GET", "state", s.handleState)
+	api("GET", "status", s.handleStatus)
+	player("POST", "spin", s.unlessMaintenance(s.handleSpin))
+	player("POST", "bonus/pick", s.handlePick)
+	player("POST", "gamble", s.requireFeature("gamble", s.unlessMaintenance(s.handleGamble)))
+	player("DELETE", "gamble", s.handleGamble)
+	api("GET", "leaderboard", s.handleLeaderboard)
+	player("GET", "achievements", s.handleAchievements)
+	player("GET", "rewards", s.handleRewards)
+	player("POST", "rewards/daily", s.requireFeature("rewards", s.handleClaimDaily()))
+	player("POST", "rewards/refill", s.requireFeature("rewards", s.handleClaimRefill()))
+	player("GET", "autoplay", s.autoplayStatus)
+	player("POST", "autoplay", s.requireFeature("autoplay", s.unlessMaintenance(s.startAutoplay)))
+	player("DELETE", "autoplay", s.cancelAutoplay)
+	player("GET", "challenge", s.handleChallenge)
+	player("POST", "challenge", s.handleChallenge)
+	player("GET", "limits", s.handleLimits)
+	player("POST", "limits", s.handleLimits)
+	player("POST", "limits/exclude", s.handleExclude)
+	player("POST", "limits/reality-check", s.handleRealityCheck)
+	api("GET", "tournaments", s.handleTournaments)
+	player("POST", "tournaments/join", s.requireFeature("tournaments", s.unlessMaintenance(s.handleJoinTournament)))
+	player("POST", "tournaments/spin", s.requireFeature("tournaments", s.unlessMaintenance(s.handleTournamentSpin)))
+	api("GET", "tournaments/ranking", s.handleTournamentRanking)
+	player("GET", "duel", s.handleDuel)
+	player("POST", "duel", s.requireFeature("duels", s.unlessMaintenance(s.handleDuel)))
+	player("DELETE", "duel", s.handleDuel)
+	player("POST", "duel/spin", s.requireFeature("duels", s.handleDuelSpin))
+	api("GET", "events", s.handleEvents)
+	player("GET", "spectate", s.handleSpectate)
+	player("POST", "spectate", s.requireFeature("spectate", s.handleSpectate))
+	api("GET", "spectate/watch", s.requireFeature("spectate", s.handleWatch))
+	player("GET", "ws", s.requireFeature("websocket", s.handleWebSocket))
+
+	// The operator area exists only when an admin token is configured
+	if s.admin != nil {
+		mux.HandleFunc("GET "+base+"/admin", s.serveAdminPage)
+		admin := func(method, path, action string, h http.HandlerFunc) {
+			mux.HandleFunc(method+" "+base+"/admin/api/"+path, s.adminOnly(action, h))
+		}
+		admin("GET", "players", "players.list", s.handleAdminPlayers)
+		admin("GET", "players/{id}", "players.view", s.handleAdminPlayer)
+		admin("POST", "players/{id}/adjust", "players.adjust", s.handleAdminAdjust)
+		admin("GET", "rounds/{id}", "rounds.view", s.handleAdminRound)
+		admin("GET", "rtp", "rtp.view", s.handleAdminRTP)
+		admin("GET", "jackpot", "jackpot.view", s.handleAdminJackpot)
+		admin("POST", "jackpot", "jackpot.set", s.handleAdminJackpot)
+		admin("GET", "games/{id}/paytables", "paytables.view", s.handleAdminPaytables)
+		admin("POST", "games/{id}/paytables", "paytables.upload", s.handleAdminUploadPaytable)
+		admin("POST", "games/{id}/paytables/{version}/activate", "paytables.activate", s.handleAdminActivatePaytable)
+		admin("GET", "games/{id}/experiment", "experiment.view", s.handleAdminExperiment)
+		admin("POST", "games/{id}/experiment", "experiment.start", s.handleAdminStartExperiment)
+		admin("DELETE", "games/{id}/experiment", "experiment.stop", s.handleAdminStopExperiment)
+		admin("GET", "maintenance", "maintenance.view", s.handleAdminMaintenance)
+		admin("POST", "maintenance", "maintenance.set", s.handleAdminMaintenance)
+		admin("GET", "features", "features.view", s.handleAdminFeatures)
+		admin("POST", "features", "features.set", s.handleAdminFeatures)
+		admin("GET", "abuse", "abuse.view", s.handleAdminAbuse)
+		admin("GET", "audit", "audit.view", s.handleAdminAudit)
+	}
+
+	return router{mux}
+}
+
+// router is a ServeMux whose 404 and 405 responses are JSON like the rest
+// of the API, and which redirects a trailing slash away when the path
+// without it is a route.
+type router struct {
+	mux *http.ServeMux
+}
+
+func (rt router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, pattern := rt.mux.Handler(r)
+	if info := requestInfoFrom(r.Context()); info != nil && pattern != "" {
+		info.route = routeLabel(pattern)
+	}
+	if pattern == "" {
+		if trimmed := strings.TrimRight(r.URL.Path, "/"); trimmed != r.URL.Path && trimmed != "" {
+			u := *r.URL
+			u.Path = trimmed
+			u.RawPath = ""
+			r2 := *r
+			r2.URL = &u
+			if _, pattern := rt.mux.Handler(&r2); pattern != "" {
+				// 308 keeps the method and body
+				http.Redirect(w, r, u.RequestURI(), http.StatusPermanentRedirect)
+				return
+			}
+		}
+		w = &jsonErrorWriter{ResponseWriter: w}
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// jsonErrorWriter swaps the mux's plain text error for a JSON one.
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *jsonErrorWriter) WriteHeader(status int) {
+	if status < 400 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	writeError(w.ResponseWriter, status, strings.ToLower(http.StatusText(status)))
+	w.wrote = true
+}
+
+func (w *jsonErrorWriter) Write(b []byte) (int, error) {
+	if w.wrote {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
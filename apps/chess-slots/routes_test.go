@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCleanBasePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "", want: ""},
+		{in: "/", want: ""},
+		{in: "slots", want: "/slots"},
+		{in: "/slots/", want: "/slots"},
+		{in: " /apps/chess-slots ", want: "/apps/chess-slots"},
+	}
+	for _, tt := range tests {
+		if got := cleanBasePath(tt.in); got != tt.want {
+			t.Errorf("cleanBasePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		method   string
+		path     string
+		status   int
+		location string
+		jsonErr  bool
+	}{
+		{name: "api route", base: "/slots", method: "GET", path: "/slots/api/games", status: http.StatusOK},
+		{name: "lobby", base: "/slots", method: "GET", path: "/slots/", status: http.StatusOK},
+		{name: "game page", base: "/slots", method: "GET", path: "/slots/chess-slots", status: http.StatusOK},
+		{name: "root goes to the base", base: "/slots", method: "GET", path: "/", status: http.StatusFound, location: "/slots/"},
+		{name: "health at the root", base: "/slots", method: "GET", path: "/healthz", status: http.StatusOK},
+		{name: "health under the base", base: "/slots", method: "GET", path: "/slots/healthz", status: http.StatusOK},
+		{name: "trailing slash", base: "/slots", method: "GET", path: "/slots/api/games/", status: http.StatusPermanentRedirect, location: "/slots/api/games"},
+		{name: "trailing slash keeps the query", base: "/slots", method: "GET", path: "/slots/api/leaderboard/?period=weekly", status: http.StatusPermanentRedirect, location: "/slots/api/leaderboard?period=weekly"},
+		{name: "trailing slash on a POST", base: "/slots", method: "POST", path: "/slots/api/session/", status: http.StatusPermanentRedirect, location: "/slots/api/session"},
+		{name: "unknown api route", base: "/slots", method: "GET", path: "/slots/api/nope", status: http.StatusNotFound, jsonErr: true},
+		{name: "outside the base", base: "/slots", method: "GET", path: "/api/games", status: http.StatusNotFound, jsonErr: true},
+		{name: "unknown game", base: "/slots", method: "GET", path: "/slots/no-such-game", status: http.StatusNotFound},
+		{name: "wrong method", base: "/slots", method: "DELETE", path: "/slots/api/games", status: http.StatusMethodNotAllowed, jsonErr: true},
+		{name: "no base: api route", method: "GET", path: "/api/games", status: http.StatusOK},
+		{name: "no base: lobby", method: "GET", path: "/", status: http.StatusOK},
+		{name: "no base: wrong method", method: "PUT", path: "/api/session", status: http.StatusMethodNotAllowed, jsonErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.BasePath = tt.base
+			srv := newServer(newTestStore(t), cfg)
+			w := httptest.NewRecorder()
+			srv.routes().ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if loc := w.Header().Get("Location"); loc != tt.location {
+				t.Errorf("Location = %q, want %q", loc, tt.location)
+			}
+			if tt.jsonErr {
+				var body struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == "" {
+					t.Errorf("body = %q, want a JSON error", w.Body.String())
+				}
+			}
+		})
+	}
+}
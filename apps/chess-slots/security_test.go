@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestViaProxies(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   int
+		forwarded []string // X-Forwarded-For headers, in order
+		want      string
+	}{
+		{name: "no proxies ignores the header", forwarded: []string{"203.0.113.9"}, want: "192.0.2.1"},
+		{name: "one proxy", proxies: 1, forwarded: []string{"203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed entry before the proxy's", proxies: 1, forwarded: []string{"6.6.6.6, 203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed header before the proxy's", proxies: 1, forwarded: []string{"6.6.6.6", "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "two proxies", proxies: 2, forwarded: []string{"6.6.6.6, 203.0.113.9, 198.51.100.7"}, want: "203.0.113.9"},
+		{name: "fewer entries than proxies", proxies: 2, forwarded: []string{"203.0.113.9"}, want: "192.0.2.1"},
+		{name: "no header", proxies: 1, want: "192.0.2.1"},
+		{name: "not an address", proxies: 1, forwarded: []string{"unknown"}, want: "192.0.2.1"},
+		{name: "IPv6", proxies: 1, forwarded: []string{"2001:db8::1"}, want: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := viaProxies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}), tt.proxies)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:4711"
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
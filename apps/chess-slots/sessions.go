@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// New sessions one client IP may start: a burst, then one every
+	// sessionIPEvery
+	sessionIPBurst = 10
+	sessionIPEvery = 6 * time.Minute
+	// A player still holding the starting coins has nothing to lose, so
+	// is dropped after a day without play; anyone else after 90 days
+	playerUnplayedExpiry = 24 * time.Hour
+	playerIdleExpiry     = 90 * 24 * time.Hour
+)
+
+var errNoSession = errors.New("no session; start one with POST /api/session")
+
+// playerID is the player named by the session cookie, or "" when there
+// is none. Only handleSession issues the cookie.
+func playerID(r *http.Request) string {
+	if c, err := r.Cookie(playerCookie); err == nil && len(c.Value) == 24 {
+		return c.Value
+	}
+	return ""
+}
+
+// handleSession starts a session: a new player with the starting coins
+// and a cookie naming them. A browser that already has one keeps it. New
+// sessions are limited per client IP, so fresh wallets can't be farmed by
+// dropping the cookie.
+func (s *server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if id := playerID(r); id != "" && s.store.exists(id) {
+		writeJSON(w, http.StatusOK, map[string]any{"created": false})
+		return
+	}
+	ip := clientIP(r)
+	if ok, wait, first := s.sessionLimit.take(ip, time.Now()); !ok {
+		metrics.rateLimited.add(1, "session")
+		if first {
+			s.abuse.record(AbuseEvent{Kind: "rate_limited", IP: ip, Reason: "session limit"})
+		}
+		writeErr(w, &RateLimitError{Scope: "session", RetryAfter: wait})
+		return
+	}
+	id := newID()
+	s.store.createPlayer(id)
+	http.SetCookie(w, &http.Cookie{
+		Name:     playerCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	writeJSON(w, http.StatusCreated, map[string]any{"created": true})
+}
+
+// requireSession refuses a player route with 401 unless the cookie names
+// a player.
+func (s *server) requireSession(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.store.exists(playerID(r)) {
+			writeErr(w, errNoSession)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Store) exists(playerID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.players[playerID]
+	return ok
+}
+
+func (s *Store) createPlayer(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := &Player{ID: id, Balance: s.game.StartingCoins, CreatedAt: time.Now()}
+	s.players[id] = p
+	s.post(p, "signup", 0, "")
+}
+
+// expirePlayers forgets idle players. Anyone with a bonus, duel, autoplay
+// or self-exclusion still open is kept. Their ledger entries and rounds
+// stay. Callers must hold s.mu.
+func (s *Store) expirePlayers(now time.Time) {
+	expired := 0
+	for id, p := range s.players {
+		idle := now.Sub(p.Safety.Session.LastActivity)
+		switch {
+		case p.Bonus != nil && !p.Bonus.Finished(),
+			p.FreeSpins != nil,
+			p.Duel != nil && (p.Duel.Status == "waiting" || p.Duel.Status == "active"),
+			p.Autoplay != nil && p.Autoplay.Running,
+			now.Before(p.Safety.ExcludedUntil),
+			len(s.balanceWatchers[id]) > 0:
+			continue
+		case idle > playerIdleExpiry,
+			idle > playerUnplayedExpiry && p.Balance == s.game.StartingCoins:
+		default:
+			continue
+		}
+		if p.Spectate.Token != "" {
+			delete(s.spectators, p.Spectate.Token)
+		}
+		delete(s.players, id)
+		expired++
+	}
+	if expired > 0 {
+		s.changes++
+		slog.Info("expired idle players", "count", expired, "players", len(s.players))
+	}
+}
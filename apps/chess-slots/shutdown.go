@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serverTimeouts bound how long a client may take. Write covers a whole
+// response, so the event stream and WebSocket clear it for themselves.
+type serverTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	// How long in-flight requests get to finish after SIGTERM. Cloud Run
+	// kills the instance 10s after sending it.
+	Shutdown time.Duration
+}
+
+var defaultTimeouts = serverTimeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       30 * time.Second,
+	Write:      60 * time.Second,
+	Idle:       120 * time.Second,
+	Shutdown:   8 * time.Second,
+}
+
+// serve runs the HTTP server until SIGTERM or SIGINT, then shuts down in
+// order: stop taking new work, settle what is open, close the long-lived
+// streams, wait for in-flight requests, and flush what is buffered.
+func (s *server) serve(addr string, h http.Handler, t serverTimeouts) error {
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: t.ReadHeader,
+		ReadTimeout:       t.Read,
+		WriteTimeout:      t.Write,
+		IdleTimeout:       t.Idle,
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- httpServer.ListenAndServe() }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-signals:
+		slog.Info("shutting down", "signal", sig.String(), "timeout", t.Shutdown.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.Shutdown)
+	defer cancel()
+	s.beginShutdown()
+	err := httpServer.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("requests still open at the shutdown deadline; closing them")
+		httpServer.Close()
+	}
+	s.finishShutdown(ctx)
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// beginShutdown fails readiness, stops the scheduler and autoplay, and
+// closes every event stream and WebSocket. Spins already holding the
+// store lock settle first; autoplay stops between spins.
+func (s *server) beginShutdown() {
+	close(s.closing)
+	stopped := 0
+	s.store.read(func() {
+		for _, p := range s.store.players {
+			if p.Autoplay != nil && p.Autoplay.Running {
+				s.store.stopAutoplay(p.Autoplay, "server restarting")
+				stopped++
+			}
+		}
+	})
+	s.store.events.close()
+	slog.Info("draining", "autoplaysStopped", stopped)
+}
+
+// finishShutdown runs once no request is in flight. It settles the rounds
+// still open, saves the store with file storage and records where the
+// ledger ended up; spans still queued for the collector are sent.
+func (s *server) finishShutdown(ctx context.Context) {
+	s.store.read(func() {
+		// The store file keeps open bonuses, so only memory storage, which
+		// loses them, plays them out
+		bonuses, duels := s.store.settleOpen(storeFile(s.cfg.Storage) == "")
+		total := 0
+		for _, p := range s.store.players {
+			total += p.Balance
+		}
+		slog.Info("final state",
+			"players", len(s.store.players),
+			"rounds", len(s.store.rounds),
+			"ledgerEntries", len(s.store.ledger),
+			"coinsHeld", total,
+			"bonusesSettled", bonuses,
+			"duelsRefunded", duels,
+		)
+	})
+	if path := storeFile(s.cfg.Storage); path != "" {
+		if err := s.store.save(path); err != nil {
+			slog.Error("could not save the store", "file", path, "error", err)
+		} else {
+			slog.Info("store saved", "file", path)
+		}
+	}
+	tracer.flush(ctx)
+}
+
+// settleOpen ends every round a player can no longer finish once the
+// server stops, so no coins are left hanging on it. With playBonuses, an
+// open pick bonus is played out on random squares, which on average is
+// worth what the player's own picks would be, and pays as usual; without,
+// it is left open for the store file to keep. A duel still waiting for an
+// opponent or part played is cancelled and both buy-ins refunded. Callers
+// must hold s.mu.
+func (s *Store) settleOpen(playBonuses bool) (bonuses, duels int) {
+	for _, p := range s.players {
+		if !playBonuses || p.Bonus == nil || p.Bonus.Finished() {
+			continue
+		}
+		for p.Bonus != nil {
+			s.pickSquare(p, randIntn(boardSquares))
+		}
+		bonuses++
+	}
+	if d := s.duelWaiting; d != nil {
+		if p, ok := s.players[d.Sides[0].PlayerID]; ok && s.cancelDuel(p, time.Now()) == nil {
+			duels++
+		}
+	}
+	for id, d := range s.duels {
+		for _, side := range d.Sides {
+			if p, ok := s.players[side.PlayerID]; ok {
+				s.post(p, "duel_refund", s.game.Duel.BuyIn, d.ID)
+			}
+		}
+		d.Status = "cancelled"
+		delete(s.duels, id)
+		duels++
+	}
+	return bonuses, duels
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFinishShutdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      bool
+		bonusOpen bool // the bonus is kept for after the restart
+	}{
+		{name: "memory"},
+		{name: "file", file: true, bonusOpen: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.json")
+			cfg := defaultConfig()
+			if tt.file {
+				cfg.Storage = "file:" + path
+			}
+			s := newTestStore(t)
+			srv := newServer(s, cfg)
+			now := time.Now()
+			for _, id := range []string{"bonus", "duel-a", "duel-b", "queued"} {
+				s.createPlayer(id)
+			}
+			start := s.players["bonus"].Balance
+			s.players["bonus"].Bonus = fixedBonus(10)
+			s.pickSquare(s.players["bonus"], 20)
+			for _, id := range []string{"duel-a", "duel-b", "queued"} {
+				if err := s.queueDuel(s.players[id], now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.duelSpin(s.players["duel-a"], now)
+
+			srv.finishShutdown(context.Background())
+
+			if len(s.duels) != 0 || s.duelWaiting != nil {
+				t.Errorf("%d duels active and %v waiting after shutdown, want none", len(s.duels), s.duelWaiting)
+			}
+			after := s
+			if tt.file {
+				after = newTestStore(t)
+				if err := after.load(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, id := range []string{"duel-a", "duel-b", "queued"} {
+				if p := after.players[id]; p == nil || p.Balance != start {
+					t.Errorf("%s = %+v, want a balance of %d", id, p, start)
+				}
+			}
+
+			p := after.players["bonus"]
+			if p == nil || (p.Bonus != nil) != tt.bonusOpen {
+				t.Fatalf("bonus player = %+v, want the bonus open = %v", p, tt.bonusOpen)
+			}
+			paid := 0
+			for _, e := range after.ledger {
+				if e.PlayerID == "bonus" && e.Kind == "bonus" {
+					paid += e.Amount
+				}
+			}
+			if tt.bonusOpen {
+				if len(p.Bonus.Picks) != 1 || paid != 0 || p.Balance != start {
+					t.Errorf("kept bonus has picks %v, %d paid, balance %d; want the one pick and nothing paid yet", p.Bonus.Picks, paid, p.Balance)
+				}
+				return
+			}
+			// The pick before shutdown had already won 20 coins
+			if paid < 20 || p.Balance != start+paid {
+				t.Errorf("balance = %d with %d bonus in the ledger, want at least 20 paid", p.Balance, paid)
+			}
+		})
+	}
+}
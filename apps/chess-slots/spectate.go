@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var errNoSpectateLink = errors.New("this live view has ended or never existed")
+
+// Spectate is a player's read-only live view. The token in the shared link
+// is random so the link never reveals the player ID, which doubles as the
+// session cookie. Turning the view off and on again issues a new token,
+// which ends every old link.
+type Spectate struct {
+	Token string
+}
+
+func spectateAudience(token string) string {
+	return "spectate:" + token
+}
+
+// SpectateRound is what a spectator sees of a settled spin.
+type SpectateRound struct {
+	Game string `json:"game"`
+	SpinResult
+	Balance int `json:"balance"`
+}
+
+type SpectateBonus struct {
+	Payout  int `json:"payout"`
+	Balance int `json:"balance"`
+}
+
+type spectateSnapshot struct {
+	Name    string `json:"name"`
+	Balance int    `json:"balance"`
+}
+
+// publishSpectate sends a round or bonus to p's spectators, if they have
+// any. Callers must hold s.mu.
+func (s *Store) publishSpectate(p *Player, typ string, v any) {
+	if p.Spectate.Token != "" {
+		s.events.publishTo(spectateAudience(p.Spectate.Token), typ, v)
+	}
+}
+
+// setSpectating turns p's live view on or off. Callers must hold s.mu.
+func (s *Store) setSpectating(p *Player, enabled bool) {
+	if old := p.Spectate.Token; old != "" {
+		s.events.publishTo(spectateAudience(old), "ended", map[string]string{})
+		delete(s.spectators, old)
+		p.Spectate.Token = ""
+	}
+	if enabled {
+		p.Spectate.Token = newID()
+		s.spectators[p.Spectate.Token] = p.ID
+	}
+}
+
+type spectateStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Token    string `json:"token,omitempty"`
+	Watchers int    `json:"watchers"`
+}
+
+func (s *server) handleSpectate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	var st spectateStatus
+	s.store.Update(playerID(r), func(p *Player) error {
+		if r.Method == http.MethodPost {
+			s.store.setSpectating(p, req.Enabled)
+		}
+		st.Token = p.Spectate.Token
+		st.Enabled = st.Token != ""
+		return nil
+	})
+	if st.Enabled {
+		st.Watchers = s.store.events.count(spectateAudience(st.Token))
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleWatch is the spectator's event stream for ?token=. It opens with a
+// snapshot of the player's balance, then carries "round" and "bonus"
+// events until the player turns the view off ("ended").
+func (s *server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var snap spectateSnapshot
+	found := false
+	s.store.read(func() {
+		if id, ok := s.store.spectators[token]; ok {
+			found = true
+			snap = spectateSnapshot{Name: displayName(id), Balance: s.store.players[id].Balance}
+		}
+	})
+	if !found {
+		writeErr(w, errNoSpectateLink)
+		return
+	}
+	data, _ := json.Marshal(snap)
+	s.streamEvents(w, r, spectateAudience(token), &Event{Type: "snapshot", Data: data})
+}
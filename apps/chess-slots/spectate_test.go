@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetSpectating(t *testing.T) {
+	tests := []struct {
+		name   string
+		on     []bool // calls in order
+		active bool
+		ended  bool // the first link got "ended"
+	}{
+		{name: "on", on: []bool{true}, active: true},
+		{name: "off again", on: []bool{true, false}, ended: true},
+		{name: "new link ends the old", on: []bool{true, false, true}, active: true, ended: true},
+		{name: "on twice", on: []bool{true, true}, active: true, ended: true},
+		{name: "off when it never was", on: []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			var first chan Event
+			for _, on := range tt.on {
+				s.setSpectating(p, on)
+				if first == nil && p.Spectate.Token != "" {
+					first, _ = s.events.subscribe(spectateAudience(p.Spectate.Token), 0)
+				}
+			}
+			if active := p.Spectate.Token != ""; active != tt.active {
+				t.Fatalf("active = %v, want %v", active, tt.active)
+			}
+			if tt.active && s.spectators[p.Spectate.Token] != "p1" {
+				t.Errorf("token %q doesn't lead to the player", p.Spectate.Token)
+			}
+			if len(s.spectators) != map[bool]int{true: 1}[tt.active] {
+				t.Errorf("%d links open, want only the current one", len(s.spectators))
+			}
+			ended := false
+			if first != nil {
+				select {
+				case ev := <-first:
+					ended = ev.Type == "ended"
+				default:
+				}
+			}
+			if ended != tt.ended {
+				t.Errorf("first link ended = %v, want %v", ended, tt.ended)
+			}
+		})
+	}
+}
+
+func TestPublishSpectate(t *testing.T) {
+	s := newTestStore(t)
+	g := s.games.house()
+	s.createPlayer("p1")
+	s.createPlayer("p2")
+	p1 := s.players["p1"]
+	s.setSpectating(p1, true)
+	watcher, _ := s.events.subscribe(spectateAudience(p1.Spectate.Token), 0)
+	// p2 isn't shared, so their spins go nowhere
+	if _, err := s.spin(s.players["p2"], g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.spin(p1, g); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for len(watcher) > 0 {
+		ev := <-watcher
+		if ev.Type == "round" || ev.Type == "bonus" {
+			types = append(types, ev.Type)
+		}
+	}
+	if len(types) != 1 || types[0] != "round" {
+		t.Errorf("spectator got %v, want p1's one round", types)
+	}
+}
+
+func TestHandleWatch(t *testing.T) {
+	s := newTestStore(t)
+	s.createPlayer("p1")
+	s.setSpectating(s.players["p1"], true)
+	srv := newServer(s, defaultConfig())
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "shared link", token: s.players["p1"].Spectate.Token, status: http.StatusOK},
+		{name: "no token", status: http.StatusNotFound},
+		{name: "unknown token", token: "nope", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Gone already, so the stream stops after the snapshot
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			r := httptest.NewRequest("GET", srv.base+"/api/spectate/watch?token="+tt.token, nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			srv.routes().ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), "event: snapshot") {
+				t.Errorf("stream = %q, want a snapshot first", w.Body.String())
+			}
+		})
+	}
+}
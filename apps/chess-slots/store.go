@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// History kept in memory and in the store file. Once a list is a tenth
+// over its limit the oldest entries are dropped.
+const (
+	ledgerKept = 100_000
+	roundsKept = 50_000
+)
+
+var (
+	errInsufficientFunds = errors.New("insufficient coins")
+	errBonusActive       = errors.New("finish the bonus game first")
+)
+
+type Player struct {
+	ID        string     `json:"id"`
+	Balance   int        `json:"balance"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Bonus     *PickBonus `json:"-"`
+	Autoplay  *Autoplay  `json:"-"`
+	Safety    PlaySafety `json:"-"`
+	Rewards   Rewards    `json:"-"`
+	Badges    Badges     `json:"-"`
+	Duel      *Duel      `json:"-"`
+	Spectate  Spectate   `json:"-"`
+	Pace      PlayPace   `json:"-"`
+	// Free spins won and not yet played
+	FreeSpins *FreeSpins `json:"-"`
+	// The last paid win, while it can still be gambled
+	Gamble *GambleOffer `json:"-"`
+}
+
+type LedgerEntry struct {
+	PlayerID string    `json:"playerId"`
+	RoundID  string    `json:"roundId,omitempty"`
+	Kind     string    `json:"kind"`
+	Amount   int       `json:"amount"`
+	Balance  int       `json:"balance"`
+	Time     time.Time `json:"time"`
+	// Why an operator adjusted the balance
+	Reason string `json:"reason,omitempty"`
+}
+
+type Round struct {
+	ID           string     `json:"id"`
+	PlayerID     string     `json:"playerId"`
+	GameID       string     `json:"gameId"`
+	TournamentID string     `json:"tournamentId,omitempty"`
+	DuelID       string     `json:"duelId,omitempty"`
+	Time         time.Time  `json:"time"`
+	Bet          int        `json:"bet"`
+	Win          int        `json:"win"`
+	Result       SpinResult `json:"result"`
+	// Played from free spins, without a bet
+	Free bool `json:"free,omitempty"`
+	// The progressive pool a paid spin won, besides Win
+	Jackpot int `json:"jackpot,omitempty"`
+	// The A/B test and variant a paid spin was played under, if any
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+}
+
+// Store keeps players, the coin ledger and round history in memory, and
+// with file storage saves them to disk (see persist.go).
+type Store struct {
+	mu    sync.Mutex
+	games *Registry
+	// The house game, whose policy applies to every game
+	game    *GameDefinition
+	players map[string]*Player
+	ledger  []LedgerEntry
+	rounds  []Round
+
+	leaderboard *Leaderboard
+	tournaments map[string]*Tournament
+	duels       map[string]*Duel  // active duels
+	duelWaiting *Duel             // the player queued for an opponent
+	spectators  map[string]string // live view token -> player ID
+	events      *Hub
+	// Feature switches; the pick bonus checks "bonus"
+	switches *Switches
+	// Open WebSockets waiting on each player's balance
+	balanceWatchers map[string]map[chan int]bool
+
+	// The progressive jackpot in coins, fractions and all, and the amount
+	// pages were last sent (see jackpot.go)
+	jackpot      float64
+	jackpotShown int
+
+	// Daily bonus claims per client IP for claimsDay
+	claimsDay  string
+	claimsByIP map[string]int
+
+	// Counts updates, so a save can be skipped when nothing changed
+	changes int
+	saved   int
+}
+
+func NewStore(games *Registry) *Store {
+	s := &Store{
+		games:       games,
+		game:        games.house(),
+		players:     map[string]*Player{},
+		leaderboard: NewLeaderboard(),
+		tournaments: map[string]*Tournament{},
+		duels:       map[string]*Duel{},
+		spectators:  map[string]string{},
+		events:      NewHub(),
+
+		balanceWatchers: map[string]map[chan int]bool{},
+	}
+	s.jackpot = float64(s.game.Jackpot.Seed)
+	s.jackpotShown = s.game.Jackpot.Seed
+	return s
+}
+
+// read runs fn with the store locked for state that isn't per player.
+func (s *Store) read(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic("chess-slots: crypto/rand unavailable: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Update runs fn with the player locked. Players only come from
+// createPlayer, when a session starts.
+func (s *Store) Update(playerID string, fn func(p *Player) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.players[playerID]
+	if !ok {
+		return errNoSession
+	}
+	s.changes++
+	err := fn(p)
+	if err != nil {
+		metrics.recordWalletError(err)
+	}
+	return err
+}
+
+// post moves coins and records the movement. Callers must hold s.mu.
+func (s *Store) post(p *Player, kind string, amount int, roundID string) {
+	now := time.Now()
+	s.changes++
+	p.Balance += amount
+	p.Safety.record(kind, amount, now)
+	s.trackRefill(p, now)
+	s.notifyBalance(p)
+	s.ledger = keepLast(append(s.ledger, LedgerEntry{
+		PlayerID: p.ID,
+		RoundID:  roundID,
+		Kind:     kind,
+		Amount:   amount,
+		Balance:  p.Balance,
+		Time:     now,
+	}), ledgerKept)
+}
+
+// keepLast trims list to its last limit entries once it is a tenth over,
+// so the copy is paid once every limit/10 appends. The kept entries move
+// to a new array, which leaves a snapshot of the old one as it was.
+func keepLast[T any](list []T, limit int) []T {
+	if len(list) <= limit+limit/10 {
+		return list
+	}
+	return append([]T(nil), list[len(list)-limit:]...)
+}
+
+// spin settles one paid round of g for p. Callers must hold s.mu.
+func (s *Store) spin(p *Player, g *GameDefinition) (Round, error) {
+	if p.Bonus != nil && !p.Bonus.Finished() {
+		return Round{}, errBonusActive
+	}
+	// A new spin drops the last win's gamble offer, whether or not it plays
+	p.Gamble = nil
+	free := s.freeSpin(p, g)
+	bet := g.SpinCost
+	if free {
+		bet = 0
+	}
+	if p.Balance < bet {
+		return Round{}, errInsufficientFunds
+	}
+	if err := p.Safety.checkBet(bet, time.Now()); err != nil {
+		return Round{}, err
+	}
+
+	pt, experiment, variant := g.assign(p.ID)
+	round := Round{ID: newID(), PlayerID: p.ID, GameID: g.ID, Time: time.Now(), Bet: bet, Free: free, Experiment: experiment, Variant: variant}
+	if !free {
+		s.post(p, "bet", -round.Bet, round.ID)
+		s.fundJackpot(round.Bet)
+	}
+	round.Result = g.spinWith(pt)
+	round.Win = round.Result.Payout
+	if round.Win > 0 {
+		s.post(p, "win", round.Win, round.ID)
+	}
+	if round.Result.MatchCount == 5 && !free {
+		round.Jackpot = s.payJackpot(p, g, round.ID)
+	}
+	if round.Result.BonusTriggered && !s.switches.enabled("bonus") {
+		round.Result.BonusTriggered = false
+	}
+	if round.Result.BonusTriggered {
+		p.Bonus = newPickBonus(round.ID, g.ID, g.SpinCost)
+	}
+	if free {
+		s.useFreeSpin(p, round.Win)
+	}
+	round.Result.FreeSpinsWon = s.awardFreeSpins(p, g, round)
+	s.offerGamble(p, g, round)
+	s.rounds = keepLast(append(s.rounds, round), roundsKept)
+	s.leaderboard.record(p.ID, round.Bet, round.Win+round.Jackpot, true, round.Time)
+	kind := "paid"
+	if free {
+		kind = "free"
+	}
+	metrics.recordSpin(g.ID, kind, round.Bet, round.Win)
+
+	ev := settledEvent{kind: "spin", matchCount: round.Result.MatchCount, win: round.Win}
+	if sym, ok := g.findSymbol(round.Result.WinningSymbol); ok {
+		ev.symbol = sym.Name
+	}
+	s.awardBadges(p, ev, round.Time)
+	s.publishSpectate(p, "round", SpectateRound{Game: g.ID, SpinResult: round.Result, Balance: p.Balance})
+	if round.Win > 0 {
+		s.publishBigWin(p.ID, g.SpinCost, BigWinEvent{
+			Game:       g.Name,
+			Symbol:     round.Result.WinningSymbol,
+			MatchCount: round.Result.MatchCount,
+			Win:        round.Win + round.Jackpot,
+			Jackpot:    round.Jackpot > 0,
+			Pool:       round.Jackpot,
+		})
+	}
+	return round, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestStore is a store over the embedded games with every feature on.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	games, err := loadRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(games)
+	on := map[string]bool{}
+	for _, name := range featureNames {
+		on[name] = true
+	}
+	s.switches = newSwitches(on)
+	return s
+}
+
+func TestSpinLedger(t *testing.T) {
+	tests := []struct {
+		name  string
+		coins int // the player's balance less the spin cost, if set
+		set   bool
+		bonus bool
+		err   error
+	}{
+		{name: "starting coins"},
+		{name: "exactly one spin", set: true},
+		{name: "short of a spin", coins: -1, set: true, err: errInsufficientFunds},
+		{name: "bonus still open", bonus: true, err: errBonusActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			g := s.games.house()
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			if tt.set {
+				p.Balance = g.SpinCost + tt.coins
+			}
+			if tt.bonus {
+				p.Bonus = fixedBonus(g.SpinCost)
+			}
+			before, entries := p.Balance, len(s.ledger)
+
+			round, err := s.spin(p, g)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if p.Balance != before || len(s.ledger) != entries || len(s.rounds) != 0 {
+					t.Errorf("a refused spin moved coins: balance %d -> %d, %d new ledger entries, %d rounds",
+						before, p.Balance, len(s.ledger)-entries, len(s.rounds))
+				}
+				return
+			}
+
+			if want := before - g.SpinCost + round.Win + round.Jackpot; p.Balance != want {
+				t.Errorf("balance = %d, want %d", p.Balance, want)
+			}
+			posted := s.ledger[entries:]
+			wantEntries := 1
+			if round.Win > 0 {
+				wantEntries++
+			}
+			if round.Jackpot > 0 {
+				wantEntries++
+			}
+			if len(posted) != wantEntries {
+				t.Fatalf("posted %d ledger entries, want %d", len(posted), wantEntries)
+			}
+			bet := posted[0]
+			if bet.Kind != "bet" || bet.Amount != -g.SpinCost || bet.RoundID != round.ID || bet.Balance != before-g.SpinCost {
+				t.Errorf("bet entry = %+v", bet)
+			}
+			if round.Win > 0 {
+				win := posted[1]
+				if win.Kind != "win" || win.Amount != round.Win || win.RoundID != round.ID || win.Balance != before-g.SpinCost+round.Win {
+					t.Errorf("win entry = %+v", win)
+				}
+			}
+			if len(s.rounds) != 1 || s.rounds[0].ID != round.ID {
+				t.Errorf("rounds = %+v, want the one round", s.rounds)
+			}
+		})
+	}
+}
+
+// The ledger alone accounts for every coin, whatever the spins landed.
+func TestSpinLedgerBalances(t *testing.T) {
+	s := newTestStore(t)
+	g := s.games.house()
+	s.createPlayer("p1")
+	p := s.players["p1"]
+	for i := 0; i < 200; i++ {
+		for sq := 0; p.Bonus != nil; sq++ {
+			s.pickSquare(p, sq)
+		}
+		if _, err := s.spin(p, g); errors.Is(err, errInsufficientFunds) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := 0
+	for _, e := range s.ledger {
+		sum += e.Amount
+		if e.Balance != g.StartingCoins+sum {
+			t.Fatalf("entry %+v: running balance %d, want %d", e, e.Balance, g.StartingCoins+sum)
+		}
+	}
+	if p.Balance != g.StartingCoins+sum {
+		t.Errorf("balance = %d, want %d", p.Balance, g.StartingCoins+sum)
+	}
+}
+
+func TestExpirePlayers(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		idle    time.Duration
+		played  bool
+		bonus   bool
+		free    bool
+		exclude bool
+		expired bool
+	}{
+		{name: "unplayed for an hour", idle: time.Hour},
+		{name: "unplayed for two days", idle: 48 * time.Hour, expired: true},
+		{name: "played, two days", idle: 48 * time.Hour, played: true},
+		{name: "played, a hundred days", idle: 100 * 24 * time.Hour, played: true, expired: true},
+		{name: "open bonus", idle: 100 * 24 * time.Hour, bonus: true},
+		{name: "free spins left", idle: 100 * 24 * time.Hour, free: true},
+		{name: "self-excluded", idle: 100 * 24 * time.Hour, exclude: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			p.Safety.Session.LastActivity = now.Add(-tt.idle)
+			if tt.played {
+				p.Balance--
+			}
+			if tt.bonus {
+				p.Bonus = fixedBonus(10)
+			}
+			if tt.free {
+				p.FreeSpins = &FreeSpins{GameID: "chess-slots", Left: 3}
+			}
+			if tt.exclude {
+				p.Safety.ExcludedUntil = now.Add(time.Hour)
+			}
+			s.expirePlayers(now)
+			if _, kept := s.players["p1"]; kept == tt.expired {
+				t.Errorf("expired = %v, want %v", !kept, tt.expired)
+			}
+		})
+	}
+}
+
+func TestKeepLast(t *testing.T) {
+	tests := []struct {
+		name  string
+		len   int
+		first int // the first entry kept
+	}{
+		{name: "under the limit", len: 5},
+		{name: "at the limit", len: 10},
+		{name: "within a tenth over", len: 11},
+		{name: "more than a tenth over", len: 12, first: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list []int
+			for i := 0; i < tt.len; i++ {
+				list = append(list, i)
+			}
+			got := keepLast(list, 10)
+			if len(got) != tt.len-tt.first || got[0] != tt.first || got[len(got)-1] != tt.len-1 {
+				t.Errorf("kept %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newLogger writes JSON lines that Cloud Logging reads as structured
+// entries: severity and message are its field names, and a line with an
+// httpRequest object shows up as a request log.
+func newLogger(level slog.Level) *slog.Logger {
+	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.LevelKey:
+				a.Key = "severity"
+				if l, ok := a.Value.Any().(slog.Level); ok {
+					a.Value = slog.StringValue(severity(l))
+				}
+			case slog.MessageKey:
+				a.Key = "message"
+			}
+			return a
+		},
+	})
+	return slog.New(traceHandler{Handler: h, project: os.Getenv("GOOGLE_CLOUD_PROJECT")})
+}
+
+func severity(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return "ERROR"
+	case l >= slog.LevelWarn:
+		return "WARNING"
+	case l >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+// traceHandler adds the trace of the span in the log call's context, so
+// Cloud Logging can group a request's lines under its trace.
+type traceHandler struct {
+	slog.Handler
+	project string
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sp := spanFromContext(ctx); sp != nil {
+		trace := sp.traceID()
+		if h.project != "" {
+			trace = "projects/" + h.project + "/traces/" + trace
+		}
+		r.AddAttrs(
+			slog.String("logging.googleapis.com/trace", trace),
+			slog.String("logging.googleapis.com/spanId", sp.spanID()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithAttrs(attrs), project: h.project}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithGroup(name), project: h.project}
+}
+
+// requestInfo is filled in by the router once it has matched a route.
+type requestInfo struct {
+	route string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// telemetry traces, times, counts and logs every request. Routes are
+// labelled by their pattern, not their path, so player and game ids don't
+// each become a time series.
+func telemetry(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{route: "unmatched"}
+		ctx, span := tracer.startRequest(r, r.Method)
+		ctx = context.WithValue(ctx, requestInfoKey{}, info)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		elapsed := time.Since(start)
+		status := strconv.Itoa(rec.status)
+		metrics.requests.add(1, r.Method, info.route, status)
+		metrics.requestDuration.observe(elapsed.Seconds(), r.Method, info.route)
+
+		span.Name = r.Method + " " + info.route
+		span.set("http.request.method", r.Method)
+		span.set("http.route", info.route)
+		span.set("url.path", r.URL.Path)
+		span.set("http.response.status_code", rec.status)
+		if rec.status >= 500 {
+			span.Error = http.StatusText(rec.status)
+		}
+		span.end()
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(ctx, level, r.Method+" "+r.URL.Path,
+			slog.Group("httpRequest",
+				slog.String("requestMethod", r.Method),
+				slog.String("requestUrl", r.URL.RequestURI()),
+				slog.Int("status", rec.status),
+				slog.String("responseSize", strconv.FormatInt(rec.size, 10)),
+				slog.String("userAgent", r.UserAgent()),
+				slog.String("remoteIp", clientIP(r)),
+				slog.String("protocol", r.Proto),
+				slog.String("latency", strconv.FormatFloat(elapsed.Seconds(), 'f', 9, 64)+"s"),
+			),
+			slog.String("route", info.route),
+		)
+	})
+}
+
+// statusRecorder remembers the status and size of a response. It keeps
+// the Flusher and Hijacker of the writer it wraps, which the event stream
+// and WebSocket need.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	return hj.Hijack()
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// routeLabel is a mux pattern without its method, e.g. "/api/spin".
+func routeLabel(pattern string) string {
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// Finished tournaments kept per template so players can see results
+	tournamentHistory = 3
+	// How long a ranking request waits for something to change
+	rankingLongPoll = 25 * time.Second
+)
+
+var (
+	errNoTournament   = errors.New("tournament not found")
+	errNotRegistered  = errors.New("join the tournament first")
+	errTournamentShut = errors.New("tournament is not open")
+	errNotRunning     = errors.New("tournament is not running")
+	errOutOfCredits   = errors.New("out of tournament credits")
+)
+
+// TournamentTemplate schedules a recurring tournament. An instance starts
+// every EveryMinutes (offset from midnight UTC by OffsetMinutes), runs for
+// DurationMinutes and opens for registration RegistrationMinutes before it
+// starts.
+type TournamentTemplate struct {
+	ID                  string `json:"id"`
+	Name                string `json:"name"`
+	EveryMinutes        int    `json:"everyMinutes"`
+	OffsetMinutes       int    `json:"offsetMinutes"`
+	DurationMinutes     int    `json:"durationMinutes"`
+	RegistrationMinutes int    `json:"registrationMinutes"`
+	Credits             int    `json:"credits"`
+	RankBy              string `json:"rankBy"` // "totalWin" or "bestMultiplier"
+	Prizes              []int  `json:"prizes"` // paid to the main balance, 1st place first
+}
+
+func (t TournamentTemplate) validate(spinCost int) error {
+	switch {
+	case t.ID == "":
+		return errors.New("tournament id is required")
+	case t.EveryMinutes <= 0 || t.DurationMinutes <= 0 || t.DurationMinutes > t.EveryMinutes:
+		return fmt.Errorf("tournament %q: need 0 < durationMinutes <= everyMinutes", t.ID)
+	case t.RegistrationMinutes < 0 || t.OffsetMinutes < 0:
+		return fmt.Errorf("tournament %q: registrationMinutes and offsetMinutes cannot be negative", t.ID)
+	case t.Credits < spinCost:
+		return fmt.Errorf("tournament %q: credits must cover at least one spin", t.ID)
+	case t.RankBy != "totalWin" && t.RankBy != "bestMultiplier":
+		return fmt.Errorf("tournament %q: rankBy must be totalWin or bestMultiplier", t.ID)
+	}
+	for _, p := range t.Prizes {
+		if p <= 0 {
+			return fmt.Errorf("tournament %q: prizes must be positive", t.ID)
+		}
+	}
+	return nil
+}
+
+// TournamentEntry is one player's separate tournament wallet and score.
+type TournamentEntry struct {
+	PlayerID       string    `json:"-"`
+	Credits        int       `json:"credits"`
+	Spins          int       `json:"spins"`
+	TotalWin       int       `json:"totalWin"`
+	BestMultiplier float64   `json:"bestMultiplier"`
+	ScoredAt       time.Time `json:"-"`
+	Prize          int       `json:"prize,omitempty"`
+}
+
+func (e *TournamentEntry) score(rankBy string) float64 {
+	if rankBy == "bestMultiplier" {
+		return e.BestMultiplier
+	}
+	return float64(e.TotalWin)
+}
+
+type Tournament struct {
+	ID       string
+	Template TournamentTemplate
+	OpensAt  time.Time
+	StartsAt time.Time
+	EndsAt   time.Time
+	Finished bool
+	Entries  map[string]*TournamentEntry
+	// version counts ranking changes; changed is closed and replaced on
+	// each one to wake long-polling ranking requests.
+	version int
+	changed chan struct{}
+	// Last status announced on the event stream
+	announced string
+}
+
+func (t *Tournament) status(now time.Time) string {
+	switch {
+	case t.Finished || !now.Before(t.EndsAt):
+		return "finished"
+	case now.Before(t.OpensAt):
+		return "scheduled"
+	case now.Before(t.StartsAt):
+		return "registration"
+	default:
+		return "running"
+	}
+}
+
+// touchTournament records a ranking change and pushes it to every
+// page. Callers must hold s.mu.
+func (s *Store) touchTournament(t *Tournament, now time.Time) {
+	t.version++
+	close(t.changed)
+	t.changed = make(chan struct{})
+	s.publishTournament(t, now)
+}
+
+// ranked orders entries best first; earlier scores win ties.
+func (t *Tournament) ranked() []*TournamentEntry {
+	entries := make([]*TournamentEntry, 0, len(t.Entries))
+	for _, e := range t.Entries {
+		entries = append(entries, e)
+	}
+	rankBy := t.Template.RankBy
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.score(rankBy) != b.score(rankBy) {
+			return a.score(rankBy) > b.score(rankBy)
+		}
+		if !a.ScoredAt.Equal(b.ScoredAt) {
+			return a.ScoredAt.Before(b.ScoredAt)
+		}
+		return a.PlayerID < b.PlayerID
+	})
+	return entries
+}
+
+// tickTournaments schedules the next tournament from each template and
+// settles finished ones.
+// Callers must hold s.mu.
+func (s *Store) tickTournaments(now time.Time) {
+	for _, tpl := range s.game.Tournaments {
+		every := time.Duration(tpl.EveryMinutes) * time.Minute
+		offset := time.Duration(tpl.OffsetMinutes) * time.Minute
+		// The slot that started most recently and the next one
+		current := now.Add(-offset).Truncate(every).Add(offset)
+		for _, start := range []time.Time{current, current.Add(every)} {
+			end := start.Add(time.Duration(tpl.DurationMinutes) * time.Minute)
+			opens := start.Add(-time.Duration(tpl.RegistrationMinutes) * time.Minute)
+			id := tpl.ID + "-" + start.UTC().Format("20060102T1504")
+			if _, ok := s.tournaments[id]; ok || !now.Before(end) {
+				continue
+			}
+			t := &Tournament{
+				ID:       id,
+				Template: tpl,
+				OpensAt:  opens,
+				StartsAt: start,
+				EndsAt:   end,
+				Entries:  map[string]*TournamentEntry{},
+				changed:  make(chan struct{}),
+			}
+			t.announced = t.status(now)
+			s.tournaments[id] = t
+		}
+	}
+
+	finished := map[string][]*Tournament{}
+	for _, t := range s.tournaments {
+		if !t.Finished && !now.Before(t.EndsAt) {
+			s.settleTournament(t, now)
+		}
+		s.announceTournament(t, now)
+		if t.Finished {
+			finished[t.Template.ID] = append(finished[t.Template.ID], t)
+		}
+	}
+	for _, list := range finished {
+		sort.Slice(list, func(i, j int) bool { return list[i].EndsAt.After(list[j].EndsAt) })
+		for _, t := range list[min(len(list), tournamentHistory):] {
+			delete(s.tournaments, t.ID)
+		}
+	}
+}
+
+// announceTournament tells every page when registration opens, play
+// starts and the winner is known. Callers must hold s.mu.
+func (s *Store) announceTournament(t *Tournament, now time.Time) {
+	st := t.status(now)
+	if st == t.announced {
+		return
+	}
+	t.announced = st
+	switch st {
+	case "registration":
+		s.announce("Registration is open for the " + t.Template.Name + ".")
+	case "running":
+		s.announce("The " + t.Template.Name + " has started - good luck!")
+	case "finished":
+		if ranked := t.ranked(); len(ranked) > 0 && ranked[0].Prize > 0 {
+			s.announce(displayName(ranked[0].PlayerID) + " won the " + t.Template.Name + "!")
+		} else {
+			s.announce("The " + t.Template.Name + " has finished.")
+		}
+	}
+}
+
+// settleTournament pays the prize table into the winners' main balances.
+// Callers must hold s.mu.
+func (s *Store) settleTournament(t *Tournament, now time.Time) {
+	t.Finished = true
+	for i, e := range t.ranked() {
+		if i >= len(t.Template.Prizes) || e.score(t.Template.RankBy) <= 0 {
+			break
+		}
+		e.Prize = t.Template.Prizes[i]
+		if p, ok := s.players[e.PlayerID]; ok {
+			s.post(p, "tournament_prize", e.Prize, t.ID)
+		}
+	}
+	s.touchTournament(t, now)
+}
+
+// joinTournament registers p and funds their tournament wallet. Callers
+// must hold s.mu.
+func (s *Store) joinTournament(p *Player, id string, now time.Time) (*TournamentEntry, error) {
+	t, ok := s.tournaments[id]
+	if !ok {
+		return nil, errNoTournament
+	}
+	if st := t.status(now); st == "scheduled" || st == "finished" {
+		return nil, errTournamentShut
+	}
+	if e, ok := t.Entries[p.ID]; ok {
+		return e, nil
+	}
+	e := &TournamentEntry{PlayerID: p.ID, Credits: t.Template.Credits}
+	t.Entries[p.ID] = e
+	s.touchTournament(t, now)
+	return e, nil
+}
+
+// tournamentSpin plays one spin from p's tournament wallet. Scatters don't
+// start the pick bonus in tournaments; only payline wins score. Callers
+// must hold s.mu.
+func (s *Store) tournamentSpin(p *Player, id string, now time.Time) (Round, *TournamentEntry, error) {
+	t, ok := s.tournaments[id]
+	if !ok {
+		return Round{}, nil, errNoTournament
+	}
+	e, ok := t.Entries[p.ID]
+	if !ok {
+		return Round{}, nil, errNotRegistered
+	}
+	if t.status(now) != "running" {
+		return Round{}, nil, errNotRunning
+	}
+	if e.Credits < s.game.SpinCost {
+		return Round{}, nil, errOutOfCredits
+	}
+	// Tournament credits are free, but a break or an exhausted limit
+	// still applies
+	if err := p.Safety.checkBet(0, now); err != nil {
+		return Round{}, nil, err
+	}
+
+	round := Round{ID: newID(), PlayerID: p.ID, GameID: s.game.ID, TournamentID: id, Time: now, Bet: s.game.SpinCost}
+	round.Result = s.game.spin()
+	round.Result.BonusTriggered = false
+	round.Win = round.Result.Payout
+	s.rounds = keepLast(append(s.rounds, round), roundsKept)
+	metrics.recordSpin(s.game.ID, "tournament", round.Bet, round.Win)
+
+	e.Credits += round.Win - round.Bet
+	e.Spins++
+	if round.Win > 0 {
+		e.TotalWin += round.Win
+		if m := float64(round.Win) / float64(round.Bet); m > e.BestMultiplier {
+			e.BestMultiplier = m
+		}
+		e.ScoredAt = now
+		s.touchTournament(t, now)
+	}
+	return round, e, nil
+}
+
+type TournamentRank struct {
+	Rank  int     `json:"rank"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+	Prize int     `json:"prize,omitempty"`
+	You   bool    `json:"you,omitempty"`
+}
+
+type TournamentView struct {
+	ID       string           `json:"id"`
+	Name     string           `json:"name"`
+	Status   string           `json:"status"`
+	RankBy   string           `json:"rankBy"`
+	Credits  int              `json:"credits"`
+	Prizes   []int            `json:"prizes"`
+	OpensAt  time.Time        `json:"opensAt"`
+	StartsAt time.Time        `json:"startsAt"`
+	EndsAt   time.Time        `json:"endsAt"`
+	Entrants int              `json:"entrants"`
+	Version  int              `json:"version"`
+	Entry    *TournamentEntry `json:"entry"`
+	YourRank int              `json:"yourRank,omitempty"`
+	Ranking  []TournamentRank `json:"ranking,omitempty"`
+}
+
+func (t *Tournament) view(playerID string, rankingSize int, now time.Time) TournamentView {
+	v := TournamentView{
+		ID:       t.ID,
+		Name:     t.Template.Name,
+		Status:   t.status(now),
+		RankBy:   t.Template.RankBy,
+		Credits:  t.Template.Credits,
+		Prizes:   t.Template.Prizes,
+		OpensAt:  t.OpensAt,
+		StartsAt: t.StartsAt,
+		EndsAt:   t.EndsAt,
+		Entrants: len(t.Entries),
+		Version:  t.version,
+	}
+	if e, ok := t.Entries[playerID]; ok {
+		entry := *e
+		v.Entry = &entry
+	}
+	for i, e := range t.ranked() {
+		r := TournamentRank{Rank: i + 1, Name: displayName(e.PlayerID), Score: e.score(t.Template.RankBy), Prize: e.Prize, You: e.PlayerID == playerID}
+		if r.You {
+			v.YourRank = r.Rank
+		}
+		if i < rankingSize {
+			v.Ranking = append(v.Ranking, r)
+		}
+	}
+	return v
+}
+
+func (s *server) handleTournaments(w http.ResponseWriter, r *http.Request) {
+	id := playerID(r)
+	views := []TournamentView{}
+	s.store.read(func() {
+		now := time.Now()
+		s.store.tickTournaments(now)
+		for _, t := range s.store.tournaments {
+			views = append(views, t.view(id, 3, now))
+		}
+	})
+	sort.Slice(views, func(i, j int) bool { return views[i].StartsAt.Before(views[j].StartsAt) })
+	writeJSON(w, http.StatusOK, map[string]any{"tournaments": views})
+}
+
+func (s *server) handleJoinTournament(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var view TournamentView
+	err := s.store.Update(playerID(r), func(p *Player) error {
+		now := time.Now()
+		if _, err := s.store.joinTournament(p, req.ID, now); err != nil {
+			return err
+		}
+		view = s.store.tournaments[req.ID].view(p.ID, leaderboardSize, now)
+		return nil
+	})
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (s *server) handleTournamentSpin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp, err := s.playTournamentSpin(playerID(r), clientIP(r), req.ID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// playTournamentSpin reports the tournament credits left as the balance.
+func (s *server) playTournamentSpin(playerID, ip, tournamentID string) (spinResponse, error) {
+	var resp spinResponse
+	err := s.store.Update(playerID, func(p *Player) error {
+		if err := s.admitSpin(p, s.store.game, ip); err != nil {
+			return err
+		}
+		round, entry, err := s.store.tournamentSpin(p, tournamentID, time.Now())
+		if err != nil {
+			return err
+		}
+		resp = spinResponse{RoundID: round.ID, SpinResult: round.Result, Balance: entry.Credits}
+		return nil
+	})
+	return resp, err
+}
+
+// handleTournamentRanking is the live ranking feed. With ?since=<version>
+// it waits until the ranking moves past that version (or a timeout) so
+// the page can long-poll it.
+func (s *server) handleTournamentRanking(w http.ResponseWriter, r *http.Request) {
+	id := playerID(r)
+	tid := r.URL.Query().Get("id")
+	since, err := strconv.Atoi(r.URL.Query().Get("since"))
+	if err != nil {
+		since = -1
+	}
+
+	timeout := time.NewTimer(rankingLongPoll)
+	defer timeout.Stop()
+	for {
+		var view TournamentView
+		var changed <-chan struct{}
+		found := false
+		s.store.read(func() {
+			// Settle first so a finished ranking always shows its prizes
+			s.store.tickTournaments(time.Now())
+			if t, ok := s.store.tournaments[tid]; ok {
+				found = true
+				view = t.view(id, leaderboardSize, time.Now())
+				changed = t.changed
+			}
+		})
+		if !found {
+			writeErr(w, errNoTournament)
+			return
+		}
+		if view.Version != since || view.Status == "finished" {
+			writeJSON(w, http.StatusOK, view)
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			writeJSON(w, http.StatusOK, view)
+			return
+		case <-s.closing:
+			// Answer now rather than hold up shutdown
+			writeJSON(w, http.StatusOK, view)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
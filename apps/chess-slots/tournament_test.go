@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettleTournament(t *testing.T) {
+	now := time.Now()
+	entry := func(id string, win int, mult float64, ago time.Duration) *TournamentEntry {
+		return &TournamentEntry{PlayerID: id, TotalWin: win, BestMultiplier: mult, ScoredAt: now.Add(-ago)}
+	}
+	tests := []struct {
+		name    string
+		rankBy  string
+		entries []*TournamentEntry
+		prizes  map[string]int
+	}{
+		{
+			name:    "by total win",
+			rankBy:  "totalWin",
+			entries: []*TournamentEntry{entry("a", 40, 2, 0), entry("b", 90, 1, 0), entry("c", 60, 9, 0)},
+			prizes:  map[string]int{"b": 500, "c": 250, "a": 100},
+		},
+		{
+			name:    "by best multiplier",
+			rankBy:  "bestMultiplier",
+			entries: []*TournamentEntry{entry("a", 40, 2, 0), entry("b", 90, 1, 0), entry("c", 60, 9, 0)},
+			prizes:  map[string]int{"c": 500, "a": 250, "b": 100},
+		},
+		{
+			name:    "the earlier score wins a tie",
+			rankBy:  "totalWin",
+			entries: []*TournamentEntry{entry("a", 50, 1, time.Minute), entry("b", 50, 1, time.Hour)},
+			prizes:  map[string]int{"b": 500, "a": 250},
+		},
+		{
+			name:    "more players than prizes",
+			rankBy:  "totalWin",
+			entries: []*TournamentEntry{entry("a", 10, 1, 0), entry("b", 20, 1, 0), entry("c", 30, 1, 0), entry("d", 40, 1, 0)},
+			prizes:  map[string]int{"d": 500, "c": 250, "b": 100},
+		},
+		{
+			name:    "no score, no prize",
+			rankBy:  "totalWin",
+			entries: []*TournamentEntry{entry("a", 10, 1, 0), entry("b", 0, 0, 0)},
+			prizes:  map[string]int{"a": 500},
+		},
+		{
+			name:    "expired player's prize goes unpaid",
+			rankBy:  "totalWin",
+			entries: []*TournamentEntry{entry("gone", 10, 1, 0)},
+			prizes:  map[string]int{"gone": 500},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			tour := &Tournament{
+				ID:       "t1",
+				Template: TournamentTemplate{ID: "t", Name: "Test", RankBy: tt.rankBy, Prizes: []int{500, 250, 100}},
+				EndsAt:   now,
+				Entries:  map[string]*TournamentEntry{},
+				changed:  make(chan struct{}),
+			}
+			before := map[string]int{}
+			for _, e := range tt.entries {
+				tour.Entries[e.PlayerID] = e
+				if e.PlayerID != "gone" {
+					s.createPlayer(e.PlayerID)
+					before[e.PlayerID] = s.players[e.PlayerID].Balance
+				}
+			}
+
+			s.settleTournament(tour, now)
+			if !tour.Finished {
+				t.Error("tournament not finished")
+			}
+			for _, e := range tt.entries {
+				if e.Prize != tt.prizes[e.PlayerID] {
+					t.Errorf("%s's prize = %d, want %d", e.PlayerID, e.Prize, tt.prizes[e.PlayerID])
+				}
+				if p := s.players[e.PlayerID]; p != nil && p.Balance != before[e.PlayerID]+tt.prizes[e.PlayerID] {
+					t.Errorf("%s's balance = %d, want %d", e.PlayerID, p.Balance, before[e.PlayerID]+tt.prizes[e.PlayerID])
+				}
+			}
+			for _, e := range s.ledger {
+				if e.Kind == "tournament_prize" && (e.RoundID != "t1" || e.Amount != tt.prizes[e.PlayerID]) {
+					t.Errorf("prize entry = %+v", e)
+				}
+			}
+		})
+	}
+}
+
+func TestTournamentSpin(t *testing.T) {
+	tests := []struct {
+		name   string
+		join   bool
+		at     time.Duration // after the start
+		credit int
+		err    error
+	}{
+		{name: "running", join: true, at: time.Minute, credit: 1000},
+		{name: "not joined", at: time.Minute, credit: 1000, err: errNotRegistered},
+		{name: "registration only", join: true, at: -time.Minute, credit: 1000, err: errNotRunning},
+		{name: "out of credits", join: true, at: time.Minute, credit: 1, err: errOutOfCredits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			start := time.Now()
+			s.tournaments["t1"] = &Tournament{
+				ID:       "t1",
+				Template: TournamentTemplate{ID: "t", RankBy: "totalWin", Credits: tt.credit},
+				OpensAt:  start.Add(-time.Hour),
+				StartsAt: start,
+				EndsAt:   start.Add(time.Hour),
+				Entries:  map[string]*TournamentEntry{},
+				changed:  make(chan struct{}),
+			}
+			s.createPlayer("p1")
+			p := s.players["p1"]
+			if tt.join {
+				if _, err := s.joinTournament(p, "t1", start); err != nil {
+					t.Fatal(err)
+				}
+			}
+			balance, entries := p.Balance, len(s.ledger)
+
+			round, e, err := s.tournamentSpin(p, "t1", start.Add(tt.at))
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			// Tournament spins never touch the main wallet
+			if p.Balance != balance || len(s.ledger) != entries {
+				t.Errorf("balance %d -> %d, %d ledger entries", balance, p.Balance, len(s.ledger)-entries)
+			}
+			if err == nil && (e.Credits != tt.credit-round.Bet+round.Win || e.Spins != 1 || e.TotalWin != round.Win) {
+				t.Errorf("entry = %+v after a round of %d won %d", e, round.Bet, round.Win)
+			}
+		})
+	}
+}
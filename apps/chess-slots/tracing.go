@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A small OpenTelemetry tracer. Spans are exported as OTLP/HTTP JSON to a
+// collector, or printed one per line to stderr, away from the JSON logs on
+// stdout. OTEL_TRACES_EXPORTER picks which: otlp, console or none (the
+// default).
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusError = 2
+
+	traceBatchSize     = 100
+	traceFlushInterval = 5 * time.Second
+	traceQueueSize     = 2048
+)
+
+type Span struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Name     string
+	Kind     int
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]any
+	Error    string
+
+	tracer *Tracer
+}
+
+func (sp *Span) traceID() string { return hex.EncodeToString(sp.TraceID[:]) }
+func (sp *Span) spanID() string  { return hex.EncodeToString(sp.SpanID[:]) }
+
+// set records an attribute. Spans are used by one goroutine, so no lock.
+func (sp *Span) set(key string, value any) {
+	if sp == nil {
+		return
+	}
+	sp.Attrs[key] = value
+}
+
+func (sp *Span) fail(err error) {
+	if sp == nil || err == nil {
+		return
+	}
+	sp.Error = err.Error()
+}
+
+func (sp *Span) end() {
+	if sp == nil {
+		return
+	}
+	sp.End = time.Now()
+	sp.tracer.export(sp)
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *Span {
+	sp, _ := ctx.Value(spanKey{}).(*Span)
+	return sp
+}
+
+type Tracer struct {
+	service string
+	// exporter is nil when tracing is off; ids are still made so logs can
+	// be correlated with an upstream trace
+	exporter func(*Span)
+	// Asks the OTLP exporter to send what it has; nil for other exporters
+	flushc chan chan struct{}
+}
+
+var tracer = &Tracer{service: "chess-slots"}
+
+// newTracer reads the standard OTEL_* variables.
+func newTracer() *Tracer {
+	t := &Tracer{service: "chess-slots"}
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		t.service = v
+	}
+	switch exp := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); exp {
+	case "", "none":
+	case "console", "stdout":
+		var mu sync.Mutex
+		enc := json.NewEncoder(os.Stderr)
+		t.exporter = func(sp *Span) {
+			mu.Lock()
+			defer mu.Unlock()
+			enc.Encode(otlpSpan(sp))
+		}
+	case "otlp":
+		endpoint := strings.TrimRight(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/")
+		if endpoint == "" {
+			endpoint = "http://localhost:4318"
+		}
+		queue := make(chan *Span, traceQueueSize)
+		t.flushc = make(chan chan struct{})
+		go t.runOTLPExporter(endpoint+"/v1/traces", queue)
+		t.exporter = func(sp *Span) {
+			select {
+			case queue <- sp:
+			default:
+				// The collector is behind; drop rather than block a request
+			}
+		}
+	default:
+		slog.Warn("unknown OTEL_TRACES_EXPORTER, tracing is off", "exporter", exp)
+	}
+	return t
+}
+
+// start begins a span, continuing the trace of the span in ctx if any.
+func (t *Tracer) start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	sp := &Span{Name: name, Kind: kind, Start: time.Now(), Attrs: map[string]any{}, tracer: t}
+	if parent := spanFromContext(ctx); parent != nil {
+		sp.TraceID, sp.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		rand.Read(sp.TraceID[:])
+	}
+	rand.Read(sp.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+// startRequest begins a server span for r, continuing the caller's trace
+// from its W3C traceparent header.
+func (t *Tracer) startRequest(r *http.Request, name string) (context.Context, *Span) {
+	ctx, sp := t.start(r.Context(), name, spanKindServer)
+	if traceID, parentID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		sp.TraceID, sp.ParentID = traceID, parentID
+	}
+	return ctx, sp
+}
+
+// parseTraceparent reads "00-<trace id>-<parent id>-<flags>".
+func parseTraceparent(h string) (traceID [16]byte, parentID [8]byte, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return traceID, parentID, false
+	}
+	t, err1 := hex.DecodeString(parts[1])
+	p, err2 := hex.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || len(t) != 16 || len(p) != 8 {
+		return traceID, parentID, false
+	}
+	copy(traceID[:], t)
+	copy(parentID[:], p)
+	if traceID == [16]byte{} || parentID == [8]byte{} {
+		return traceID, parentID, false
+	}
+	return traceID, parentID, true
+}
+
+func (t *Tracer) export(sp *Span) {
+	if t.exporter != nil {
+		t.exporter(sp)
+	}
+}
+
+// runOTLPExporter posts spans in batches of up to traceBatchSize, at least
+// every traceFlushInterval while there are any.
+func (t *Tracer) runOTLPExporter(url string, queue <-chan *Span) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.post(client, url, batch); err != nil {
+			slog.Warn("could not export spans", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case sp := <-queue:
+			batch = append(batch, sp)
+			if len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-t.flushc:
+			// Take what is already queued, then send it
+			for len(queue) > 0 {
+				batch = append(batch, <-queue)
+				if len(batch) >= traceBatchSize {
+					flush()
+				}
+			}
+			flush()
+			close(done)
+		}
+	}
+}
+
+// flush sends the queued spans, waiting until they are sent or ctx ends.
+func (t *Tracer) flush(ctx context.Context) {
+	if t.flushc == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case t.flushc <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (t *Tracer) post(client *http.Client, url string, batch []*Span) error {
+	spans := make([]map[string]any, len(batch))
+	for i, sp := range batch {
+		spans[i] = otlpSpan(sp)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{"service.name": t.service})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "chess-slots"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpSpan is a span in the OTLP JSON encoding, where ids are hex and
+// 64-bit numbers are strings.
+func otlpSpan(sp *Span) map[string]any {
+	out := map[string]any{
+		"traceId":           sp.traceID(),
+		"spanId":            sp.spanID(),
+		"name":              sp.Name,
+		"kind":              sp.Kind,
+		"startTimeUnixNano": strconv.FormatInt(sp.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(sp.End.UnixNano(), 10),
+		"attributes":        otlpAttributes(sp.Attrs),
+	}
+	if sp.ParentID != [8]byte{} {
+		out["parentSpanId"] = hex.EncodeToString(sp.ParentID[:])
+	}
+	if sp.Error != "" {
+		out["status"] = map[string]any{"code": spanStatusError, "message": sp.Error}
+	}
+	return out
+}
+
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, key := range sortedKeys(attrs) {
+		var value map[string]any
+		switch v := attrs[key].(type) {
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case string:
+			value = map[string]any{"stringValue": v}
+		default:
+			b, _ := json.Marshal(v)
+			value = map[string]any{"stringValue": string(b)}
+		}
+		out = append(out, map[string]any{"key": key, "value": value})
+	}
+	return out
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The page templates and the static files they link to. Brotli copies
+// (name.br) are optional and made by the Docker build; gzip is done here.
+//
+//go:embed web
+var webFS embed.FS
+
+var pageTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"asset": assetURL,
+	"seq": func(n int) []int {
+		s := make([]int, n)
+		for i := range s {
+			s[i] = i + 1
+		}
+		return s
+	},
+}).ParseFS(webFS, "web/templates/*.html"))
+
+// staticAsset is one file under web/static with its precompressed copies.
+type staticAsset struct {
+	contentType string
+	// Content hash; also the ?v= that marks a URL as immutable
+	version string
+	body    []byte
+	gzip    []byte
+	brotli  []byte
+}
+
+var staticAssets = loadStaticAssets()
+
+func loadStaticAssets() map[string]*staticAsset {
+	assets := map[string]*staticAsset{}
+	sub, err := fs.Sub(webFS, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	fs.WalkDir(sub, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(name, ".br") {
+			return err
+		}
+		body, _ := fs.ReadFile(sub, name)
+		sum := sha256.Sum256(body)
+		a := &staticAsset{
+			contentType: contentType(name),
+			version:     hex.EncodeToString(sum[:6]),
+			body:        body,
+		}
+		if compressible(a.contentType) {
+			var buf bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			zw.Write(body)
+			zw.Close()
+			if buf.Len() < len(body) {
+				a.gzip = buf.Bytes()
+			}
+			a.brotli, _ = fs.ReadFile(sub, name+".br")
+		}
+		assets[name] = a
+		return nil
+	})
+	return assets
+}
+
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "json") || strings.Contains(contentType, "svg")
+}
+
+// assetURL is a static file's URL with its content hash, so it can be
+// cached forever and still change on deploy.
+func assetURL(base, name string) string {
+	u := base + "/static/" + name
+	if a, ok := staticAssets[name]; ok {
+		u += "?v=" + a.version
+	}
+	return u
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding.
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveStatic serves web/static. A request with the current ?v= is cached
+// for a year; anything else revalidates with the ETag.
+func serveStatic(w http.ResponseWriter, r *http.Request) {
+	a, ok := staticAssets[r.PathValue("file")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	body, encoding := a.body, ""
+	switch ae := r.Header.Get("Accept-Encoding"); {
+	case a.brotli != nil && acceptsEncoding(ae, "br"):
+		body, encoding = a.brotli, "br"
+	case a.gzip != nil && acceptsEncoding(ae, "gzip"):
+		body, encoding = a.gzip, "gzip"
+	}
+	etag := `"` + a.version + `"`
+	if encoding != "" {
+		etag = `"` + a.version + "-" + encoding + `"`
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", a.contentType)
+	h.Set("ETag", etag)
+	h.Set("Vary", "Accept-Encoding")
+	if r.URL.Query().Get("v") == a.version {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == etag || match == "*") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
+
+type gamePage struct {
+	Base     string
+	Game     *GameDefinition
+	House    *GameDefinition
+	Paytable *Paytable
+	Features map[string]bool
+	Boot     map[string]any
+}
+
+type maintenancePage struct {
+	Base        string
+	Maintenance MaintenanceView
+	Boot        map[string]any
+}
+
+type lobbyPage struct {
+	Base  string
+	Games []GameSummary
+	Boot  map[string]any
+}
+
+// serveLobby lists the games. Live view links (?watch=) from before there
+// was a lobby open the house game instead.
+func (s *server) serveLobby(w http.ResponseWriter, r *http.Request) {
+	if s.serveMaintenance(w, r) {
+		return
+	}
+	games := s.store.games
+	if r.URL.Query().Get("watch") != "" {
+		s.renderGame(w, r, games.house())
+		return
+	}
+	s.render(w, r, "lobby.html", lobbyPage{Base: s.base, Games: games.summaries(), Boot: map[string]any{"base": s.base}})
+}
+
+func (s *server) serveGamePage(w http.ResponseWriter, r *http.Request) {
+	g, err := s.store.games.get(r.PathValue("game"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if s.serveMaintenance(w, r) {
+		return
+	}
+	s.renderGame(w, r, g)
+}
+
+func (s *server) renderGame(w http.ResponseWriter, r *http.Request, g *GameDefinition) {
+	features := s.store.switches.all()
+	pt, _, _ := g.assign(playerID(r))
+	s.render(w, r, "game.html", gamePage{
+		Base:     s.base,
+		Game:     g,
+		House:    s.store.games.house(),
+		Paytable: pt,
+		Features: features,
+		Boot:     map[string]any{"base": s.base, "game": g.withPaytable(pt), "features": features},
+	})
+}
+
+// serveMaintenance shows the maintenance page in place of the lobby or a
+// game while maintenance is on, and reports whether it did. A player with
+// a bonus or duel still open gets the game, so they can finish it.
+func (s *server) serveMaintenance(w http.ResponseWriter, r *http.Request) bool {
+	m := s.maintenance.view()
+	if !m.Enabled {
+		return false
+	}
+	if c, err := r.Cookie(playerCookie); err == nil && s.store.hasOpenRound(c.Value) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetrySeconds))
+	s.renderStatus(w, r, http.StatusServiceUnavailable, "maintenance.html", maintenancePage{
+		Base:        s.base,
+		Maintenance: m,
+		Boot:        map[string]any{"base": s.base},
+	})
+	return true
+}
+
+func (s *server) render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	s.renderStatus(w, r, http.StatusOK, name, data)
+}
+
+func (s *server) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		slog.ErrorContext(r.Context(), "could not render page", "template", name, "error", err)
+		writeError(w, http.StatusInternalServerError, "could not render the page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
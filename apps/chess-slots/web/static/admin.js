@@ -0,0 +1,353 @@
+// The operator dashboard. The token is kept for this tab only and sent as
+// a bearer token; every call is audit-logged by the server.
+const BOOT = JSON.parse(document.getElementById('boot').textContent);
+const API = BOOT.base + '/admin/api/';
+const $ = id => document.getElementById(id);
+
+function authHeaders() {
+    const headers = { 'Authorization': 'Bearer ' + sessionStorage.getItem('adminToken') };
+    const actor = sessionStorage.getItem('adminActor');
+    if (actor) headers['X-Admin-User'] = actor;
+    return headers;
+}
+
+async function api(path, body, method) {
+    const opts = { headers: authHeaders(), method };
+    if (body !== undefined) {
+        opts.method = method || 'POST';
+        opts.headers['Content-Type'] = 'application/json';
+        opts.body = JSON.stringify(body);
+    }
+    const res = await fetch(API + path, opts);
+    const data = await res.json();
+    if (res.status === 401) {
+        signOut('That token was not accepted.');
+        throw new Error(data.error);
+    }
+    if (!res.ok) throw new Error(data.error || res.statusText);
+    return data;
+}
+
+// row builds a table row from cell values, as text so nothing a player
+// controls can become markup
+function row(cells, onClick) {
+    const tr = document.createElement('tr');
+    for (const c of cells) {
+        const td = document.createElement('td');
+        td.textContent = c === undefined || c === null ? '' : c;
+        tr.appendChild(td);
+    }
+    if (onClick) {
+        tr.className = 'clickable';
+        tr.addEventListener('click', onClick);
+    }
+    return tr;
+}
+
+function fill(tbodyId, rows) {
+    $(tbodyId).replaceChildren(...rows);
+}
+
+const pct = x => (x * 100).toFixed(2) + '%';
+const when = t => t && !t.startsWith('0001') ? new Date(t).toLocaleString() : '';
+
+async function loadRTP() {
+    const { games } = await api('rtp');
+    if (!$('paytableGame').options.length) {
+        $('paytableGame').replaceChildren(...games.map(g => new Option(g.name, g.game)));
+        loadPaytables();
+        loadExperiment();
+    }
+    fill('rtpRows', games.map(g => row([
+        g.name, g.spins, g.wagered, g.won, g.bonusWon, g.freeSpinsWon, g.jackpotWon,
+        g.spins ? pct(g.liveRtp) : '-', g.spins ? pct(g.liveBaseRtp) : '-', pct(g.theoreticalBaseRtp),
+    ])));
+}
+
+function paytablePath() {
+    return 'games/' + encodeURIComponent($('paytableGame').value) + '/paytables';
+}
+
+async function loadPaytables() {
+    const { versions } = await api(paytablePath());
+    fill('paytableRows', versions.reverse().map(v => row(
+        [v.version, v.hash, v.source, when(v.loadedAt), pct(v.baseRtp), v.spins, v.spins ? pct(v.liveBaseRtp) : '-', v.active ? 'active' : ''],
+        v.active ? undefined : () => activatePaytable(v.version),
+    )));
+}
+
+async function activatePaytable(version) {
+    if (!confirm('Move new spins onto paytable version ' + version + '?')) return;
+    $('paytableError').textContent = '';
+    try {
+        await api(paytablePath() + '/' + version + '/activate', {});
+    } catch (e) {
+        $('paytableError').textContent = e.message;
+    }
+    await Promise.all([loadPaytables(), loadRTP(), loadAudit()]);
+}
+
+function experimentPath() {
+    return 'games/' + encodeURIComponent($('paytableGame').value) + '/experiment';
+}
+
+const seconds = s => s >= 60 ? Math.round(s / 60) + 'm' : Math.round(s) + 's';
+
+async function loadExperiment() {
+    let report;
+    try {
+        report = await api(experimentPath());
+    } catch (e) {
+        $('experimentState').textContent = 'No A/B test on this game yet.';
+        $('experimentCsv').hidden = true;
+        fill('experimentRows', []);
+        return;
+    }
+    const e = report.experiment;
+    $('experimentState').textContent = (e.endedAt ? 'Ended ' + when(e.endedAt) : 'Running since ' + when(e.startedAt)) + ': ' + e.id;
+    $('experimentCsv').hidden = false;
+    fill('experimentRows', report.cohorts.map(c => row([
+        c.variant, c.version, c.weight, c.players, c.spins, c.wagered,
+        c.spins ? pct(c.rtp) : '-', pct(c.theoreticalBaseRtp), c.sessions,
+        c.sessions ? seconds(c.avgSessionSeconds) : '-', c.sessions ? c.spinsPerSession.toFixed(1) : '-',
+    ])));
+}
+
+// The CSV needs the bearer token too, so it is fetched and saved from here
+// rather than linked.
+async function downloadExperimentCsv() {
+    const res = await fetch(API + experimentPath() + '?format=csv', { headers: authHeaders() });
+    if (!res.ok) return;
+    const link = document.createElement('a');
+    link.href = URL.createObjectURL(await res.blob());
+    link.download = res.headers.get('Content-Disposition')?.match(/filename="(.+)"/)?.[1] || 'experiment.csv';
+    link.click();
+    URL.revokeObjectURL(link.href);
+}
+
+function showMaintenance(m) {
+    $('maintenanceState').textContent = m.enabled
+        ? 'ON since ' + when(m.since) + (m.message ? ': ' + m.message : '') + '. New spins are refused.'
+        : 'Off. The game is open.';
+    $('maintenanceState').className = m.enabled ? 'on' : '';
+    $('maintenanceToggle').textContent = m.enabled ? 'End maintenance' : 'Start maintenance';
+    $('maintenanceToggle').className = m.enabled ? '' : 'danger';
+    $('maintenanceToggle').dataset.enable = String(!m.enabled);
+}
+
+async function loadMaintenance() {
+    showMaintenance(await api('maintenance'));
+}
+
+function showJackpot(j) {
+    $('jackpotState').textContent = j.seed || j.contributionPercent
+        ? j.amount + ' coins in the pool (seed ' + j.seed + ', ' + j.contributionPercent + '% of each paid bet). '
+            + (j.lastWon ? 'Last won ' + when(j.lastWon.time) + ': ' + j.lastWon.amount + ' coins by ' + j.lastWon.playerId + '.' : 'Not won yet.')
+        : 'Off: the house game has no jackpot section.';
+    $('jackpotForm').hidden = !(j.seed || j.contributionPercent);
+}
+
+async function loadJackpot() {
+    showJackpot(await api('jackpot'));
+}
+
+function showFeatures({ features }) {
+    $('featureForm').replaceChildren(...Object.keys(features).sort().map(name => {
+        const label = document.createElement('label');
+        const box = document.createElement('input');
+        box.type = 'checkbox';
+        box.checked = features[name];
+        box.dataset.feature = name;
+        label.append(box, name);
+        if (!features[name]) label.className = 'on';
+        return label;
+    }));
+}
+
+async function loadFeatures() {
+    showFeatures(await api('features'));
+}
+
+async function loadPlayers() {
+    const q = $('playerQuery').value.trim();
+    const { players, total } = await api('players?q=' + encodeURIComponent(q));
+    fill('playerRows', (players || []).map(p => row(
+        [p.id, p.balance, p.rounds, when(p.lastActivity), when(p.createdAt)],
+        () => openPlayer(p.id),
+    )));
+    $('playerTotal').textContent = total + ' players on this instance';
+}
+
+async function openPlayer(id) {
+    const p = await api('players/' + encodeURIComponent(id));
+    $('playerPanel').hidden = false;
+    $('playerId').textContent = p.id;
+    const facts = ['Balance ' + p.balance, p.rounds + ' rounds', 'joined ' + when(p.createdAt)];
+    if (p.autoplayRunning) facts.push('autoplay running');
+    if (p.bonusOpen) facts.push('bonus open');
+    if (p.excludedUntil) facts.push('on a break until ' + when(p.excludedUntil));
+    if (p.botFlags) facts.push('flagged as a possible bot ' + p.botFlags + '×' + (p.challengePending ? ', challenge pending' : ''));
+    $('playerFacts').textContent = facts.join(' · ');
+    fill('ledgerRows', p.ledger.map(e => row([when(e.time), e.kind, e.amount, e.balance, e.roundId, e.reason])));
+    fill('playerRounds', p.recentRounds.map(r => row(
+        [when(r.time), r.id, r.gameId, r.bet, r.win, r.result.payline.join(' ')],
+        () => lookUpRound(r.id),
+    )));
+}
+
+async function lookUpRound(id) {
+    $('roundId').value = id;
+    try {
+        $('roundResult').textContent = JSON.stringify(await api('rounds/' + encodeURIComponent(id)), null, 2);
+    } catch (e) {
+        $('roundResult').textContent = e.message;
+    }
+}
+
+async function loadAbuse() {
+    const { events, flagged } = await api('abuse');
+    fill('flaggedRows', flagged.map(f => row([f.playerId, f.flags, when(f.since)], () => openPlayer(f.playerId))));
+    fill('abuseRows', events.map(e => row(
+        [when(e.time), e.kind, e.playerId, e.ip, e.reason],
+        e.playerId ? () => openPlayer(e.playerId) : undefined,
+    )));
+}
+
+async function loadAudit() {
+    const { entries } = await api('audit');
+    fill('auditRows', entries.map(e => row([
+        when(e.time), e.actor + (e.actorSource === 'header' ? ' (unverified)' : ''), e.ip, e.action, e.target, e.details ? JSON.stringify(e.details) : '', e.status,
+    ])));
+}
+
+function signOut(message) {
+    sessionStorage.removeItem('adminToken');
+    $('dashboard').hidden = true;
+    $('loginForm').hidden = false;
+    $('loginError').textContent = message || '';
+}
+
+async function showDashboard() {
+    $('loginForm').hidden = true;
+    $('dashboard').hidden = false;
+    $('actorName').textContent = sessionStorage.getItem('adminActor') || 'admin';
+    await Promise.all([loadMaintenance(), loadFeatures(), loadRTP(), loadJackpot(), loadPlayers(), loadAbuse(), loadAudit()]);
+}
+
+$('loginForm').addEventListener('submit', e => {
+    e.preventDefault();
+    sessionStorage.setItem('adminToken', $('token').value);
+    sessionStorage.setItem('adminActor', $('actor').value.trim());
+    $('token').value = '';
+    showDashboard().catch(() => {});
+});
+$('signOut').addEventListener('click', () => signOut());
+$('refreshRtp').addEventListener('click', () => loadRTP());
+$('refreshAbuse').addEventListener('click', () => loadAbuse());
+$('refreshAudit').addEventListener('click', () => loadAudit());
+$('playerSearch').addEventListener('submit', e => { e.preventDefault(); loadPlayers(); });
+$('roundSearch').addEventListener('submit', e => { e.preventDefault(); lookUpRound($('roundId').value.trim()); });
+
+$('maintenanceForm').addEventListener('submit', async e => {
+    e.preventDefault();
+    const enable = $('maintenanceToggle').dataset.enable === 'true';
+    if (enable && !confirm('Put the game into maintenance? Players will not be able to spin.')) return;
+    showMaintenance(await api('maintenance', { enabled: enable, message: $('maintenanceMessage').value }));
+    loadAudit();
+});
+
+$('jackpotForm').addEventListener('submit', async e => {
+    e.preventDefault();
+    const amount = $('jackpotAmount').value.trim();
+    const body = { reason: $('jackpotReason').value.trim() };
+    if (amount !== '') body.amount = parseInt(amount, 10);
+    if (!confirm(amount === '' ? 'Reset the jackpot to its seed?' : 'Set the jackpot to ' + body.amount + ' coins?')) return;
+    $('jackpotError').textContent = '';
+    try {
+        showJackpot(await api('jackpot', body));
+        $('jackpotAmount').value = '';
+        $('jackpotReason').value = '';
+    } catch (err) {
+        $('jackpotError').textContent = err.message;
+    }
+    loadAudit();
+});
+
+$('featureForm').addEventListener('change', async e => {
+    const name = e.target.dataset.feature;
+    if (!e.target.checked && !confirm('Turn off ' + name + ' for every player?')) {
+        e.target.checked = true;
+        return;
+    }
+    try {
+        showFeatures(await api('features', { [name]: e.target.checked }));
+    } catch (err) {
+        loadFeatures();
+    }
+    loadAudit();
+});
+
+$('paytableGame').addEventListener('change', () => {
+    loadPaytables();
+    loadExperiment();
+});
+$('paytableForm').addEventListener('submit', async e => {
+    e.preventDefault();
+    const file = $('paytableFile').files[0];
+    $('paytableError').textContent = '';
+    if (!file) return;
+    try {
+        const { added, paytable } = await api(paytablePath(), JSON.parse(await file.text()));
+        if (!added) $('paytableError').textContent = 'Same as version ' + paytable.version + '; nothing changed.';
+        $('paytableFile').value = '';
+    } catch (err) {
+        $('paytableError').textContent = err.message;
+    }
+    await Promise.all([loadPaytables(), loadRTP(), loadAudit()]);
+});
+
+$('refreshExperiment').addEventListener('click', () => loadExperiment());
+$('experimentCsv').addEventListener('click', () => downloadExperimentCsv());
+$('experimentForm').addEventListener('submit', async e => {
+    e.preventDefault();
+    $('experimentError').textContent = '';
+    const variants = $('experimentVariants').value.split(',').map(v => {
+        const [name, version, weight] = v.trim().split(':');
+        return { name, version: parseInt(version, 10), weight: parseInt(weight || '1', 10) };
+    });
+    try {
+        await api(experimentPath(), { id: $('experimentId').value.trim(), variants });
+    } catch (err) {
+        $('experimentError').textContent = err.message;
+    }
+    await Promise.all([loadExperiment(), loadAudit()]);
+});
+$('experimentStop').addEventListener('click', async () => {
+    if (!confirm('Stop the A/B test and put every player back on the current paytable?')) return;
+    $('experimentError').textContent = '';
+    try {
+        await api(experimentPath(), undefined, 'DELETE');
+    } catch (err) {
+        $('experimentError').textContent = err.message;
+    }
+    await Promise.all([loadExperiment(), loadAudit()]);
+});
+
+$('adjustForm').addEventListener('submit', async e => {
+    e.preventDefault();
+    const id = $('playerId').textContent;
+    $('adjustError').textContent = '';
+    try {
+        await api('players/' + encodeURIComponent(id) + '/adjust', {
+            amount: parseInt($('adjustAmount').value, 10),
+            reason: $('adjustReason').value,
+        });
+        $('adjustAmount').value = '';
+        $('adjustReason').value = '';
+        await Promise.all([openPlayer(id), loadPlayers(), loadAudit()]);
+    } catch (err) {
+        $('adjustError').textContent = err.message;
+    }
+});
+
+if (sessionStorage.getItem('adminToken')) showDashboard().catch(() => {});
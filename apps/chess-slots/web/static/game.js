@@ -0,0 +1,1280 @@
+// The server renders the page for one game and hands over its definition
+// (symbols, costs and animation timings) and the base path
+const BOOT = JSON.parse(document.getElementById('boot').textContent);
+const BASE = BOOT.base;
+const game = BOOT.game;
+// Features the operator turned off are hidden by a no-<feature> body class
+const FEATURES = BOOT.features;
+const symbols = game.symbols;
+const SPIN_COST = game.spinCost;
+const NUM_REELS = game.reels;
+const VISIBLE_SYMBOLS = game.rows;
+let mode = localStorage.getItem('chessSlots_mode') || 'normal';
+
+let coins = 0;
+let isSpinning = false;
+let bonus = null;
+let autoplay = null;
+let autoplayCursor = 0;
+let tournament = null;
+// Spectators open the page with ?watch=<token>
+const WATCH = new URLSearchParams(location.search).get('watch');
+let duel = null;
+// Free spins won on this game or another, and the last win while it can
+// be gambled
+let freeSpins = null;
+let gamble = null;
+
+async function api(path, body, method) {
+    const opts = body === undefined ? {} : {
+        method: method || 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: body === null ? undefined : JSON.stringify(body),
+    };
+    const res = await fetch(BASE + '/api/' + path, opts);
+    const data = await res.json();
+    if (!res.ok) {
+        const err = new Error(data.error || res.statusText);
+        err.code = data.code;
+        throw err;
+    }
+    return data;
+}
+
+// Spins and picks go over the WebSocket while it is open and over
+// REST otherwise. Both reach the same wallet.
+let socket = null;
+let socketSeq = 0;
+let socketPending = {};
+let socketRetry = 1000;
+let lastRoundId = null;
+
+function connectSocket() {
+    const sock = new WebSocket(location.origin.replace(/^http/, 'ws') + BASE + '/api/ws');
+    sock.onopen = () => sock.send(JSON.stringify({ v: 1, type: 'hello', id: 'hello', resumeFrom: lastRoundId || undefined }));
+    sock.onmessage = e => {
+        const m = JSON.parse(e.data);
+        if (m.type === 'welcome') {
+            socket = sock;
+            socketRetry = 1000;
+            resumeSocket(m.data);
+            return;
+        }
+        if (m.type === 'balance') {
+            // Spins update the balance when their reels stop
+            if (!isSpinning && !tournament && !autoplay) {
+                coins = m.data.balance;
+                updateDisplay();
+            }
+            return;
+        }
+        const pending = socketPending[m.id];
+        if (!pending) return;
+        delete socketPending[m.id];
+        if (m.type === 'error') {
+            const err = new Error(m.data.error);
+            err.code = m.data.code;
+            pending.reject(err);
+        } else {
+            pending.resolve(m.data);
+        }
+    };
+    sock.onclose = () => {
+        socket = null;
+        Object.values(socketPending).forEach(p => p.reject(Object.assign(new Error('connection lost'), { lost: true })));
+        socketPending = {};
+        setTimeout(connectSocket, socketRetry);
+        socketRetry = Math.min(socketRetry * 2, 30000);
+    };
+}
+
+function send(type, fields, path, body) {
+    if (!socket) return api(path, body);
+    const id = String(++socketSeq);
+    return new Promise((resolve, reject) => {
+        socketPending[id] = { resolve, reject };
+        socket.send(JSON.stringify(Object.assign({ v: 1, type, id }, fields)));
+    });
+}
+
+// A spin sent just before the connection dropped may still have
+// settled; the welcome carries every round after the last one shown
+function resumeSocket(state) {
+    if (isSpinning || tournament || autoplay) return;
+    coins = state.balance;
+    freeSpins = state.freeSpins;
+    updateDisplay();
+    const missed = state.missed || [];
+    if (missed.length > 0) {
+        const last = missed[missed.length - 1];
+        lastRoundId = last.id;
+        last.result.grid.forEach((column, i) => showFinalReel(document.querySelector('#reel' + (i + 1) + ' .reel-inner'), column, i + 1));
+        showMessage('📡 Reconnected - your last spin ' + (last.win > 0 ? 'won ' + last.win + ' coins!' : 'settled with no win.'), last.win > 0 ? 'win' : '');
+    }
+    if (state.bonus && !bonus) openBonus(state.bonus);
+}
+
+async function init() {
+    updateDisplay();
+    if (!game.presentation[mode]) mode = 'normal';
+    setMode(mode);
+    if (game.theme.background) document.body.style.background = game.theme.background;
+    
+    // Initialize reels with random symbols
+    for (let i = 1; i <= NUM_REELS; i++) {
+        const reelInner = document.querySelector('#reel' + i + ' .reel-inner');
+        for (let j = 0; j < VISIBLE_SYMBOLS; j++) {
+            const sym = getRandomSymbol();
+            const div = document.createElement('div');
+            div.className = 'symbol';
+            div.textContent = sym.symbol;
+            div.dataset.symbol = sym.symbol;
+            reelInner.appendChild(div);
+        }
+    }
+    
+    if (WATCH) return watch();
+    
+    // Balance and any unfinished bonus live on the server. The first visit
+    // starts a session, which may put the player on an A/B test paytable
+    try {
+        const session = await api('session', {});
+        if (session.created) applyPaytable({ game: game.id, refetch: true }, true);
+        listenForEvents();
+        if (FEATURES.websocket) connectSocket();
+        const state = await api('state');
+        coins = state.balance;
+        rewards = state.rewards;
+        freeSpins = state.freeSpins;
+        updateDisplay();
+        showGamble(state.gamble);
+        showRewards();
+        loadLeaderboard();
+        loadTournaments();
+        loadDuel();
+        if (state.bonus) openBonus(state.bonus);
+        showAchievements(state.achievements);
+        if (state.autoplay && state.autoplay.running) {
+            autoplay = state.autoplay;
+            autoplayCursor = autoplay.played;
+            followAutoplay();
+        }
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+    }
+}
+
+function scatterSymbol() {
+    return symbols.find(s => s.scatter) || { symbol: '⭐', name: 'Scatter' };
+}
+
+function getRandomSymbol() {
+    const totalWeight = symbols.reduce((sum, s) => sum + s.weight, 0);
+    let random = Math.random() * totalWeight;
+    for (const sym of symbols) {
+        random -= sym.weight;
+        if (random <= 0) return sym;
+    }
+    return symbols[symbols.length - 1];
+}
+
+function updateDisplay() {
+    document.getElementById('coins').textContent = coins;
+    const spinBtn = document.getElementById('spinBtn');
+    if (autoplay) {
+        spinBtn.disabled = false;
+        spinBtn.textContent = '■ STOP ' + autoplay.played + '/' + autoplay.rules.spins;
+    } else {
+        spinBtn.disabled = coins < spinCost() || isSpinning || bonus !== null;
+        spinBtn.textContent = freeSpinsLeft() ? '♔ FREE SPIN (' + freeSpinsLeft() + ') ♔' : '♔ SPIN ♔';
+    }
+    document.getElementById('autoBtn').disabled = spinBtn.disabled || autoplay !== null || tournament !== null;
+}
+
+function showMessage(text, type = '') {
+    const msg = document.getElementById('message');
+    msg.textContent = text;
+    msg.className = 'message ' + type;
+}
+
+async function spin() {
+    if (autoplay) return stopAutoplay();
+    const cost = spinCost();
+    if (isSpinning || coins < cost || bonus) return;
+    
+    isSpinning = true;
+    coins -= cost;
+    showGamble(null);
+    updateDisplay();
+    showMessage('');
+    
+    // The server decides the outcome; the reels only play it back
+    let result;
+    try {
+        if (tournament) {
+            result = await send('tournament.spin', { tournament: tournament.id }, 'tournaments/spin', { id: tournament.id });
+        } else if (duelActive()) {
+            result = await api('duel/spin', {});
+        } else {
+            result = await send('spin', { game: game.id }, 'spin', { game: game.id });
+        }
+    } catch (e) {
+        coins += cost;
+        isSpinning = false;
+        updateDisplay();
+        if (e.lost) {
+            showMessage('📡 Connection lost - reconnecting...', 'lose');
+        } else if (e.code === 'reality_check') {
+            showRealityCheck(e.message);
+        } else if (e.code === 'challenge') {
+            showChallenge();
+        } else {
+            showMessage((e.code ? '🛡️ ' : '⚠️ ') + e.message, 'lose');
+        }
+        return;
+    }
+    await playRound(result);
+    isSpinning = false;
+    updateDisplay();
+}
+
+function playRound(result) {
+    // Clear winning highlights
+    document.querySelectorAll('.symbol').forEach(s => s.classList.remove('winning'));
+    
+    const results = result.payline.map(s => ({ symbol: s }));
+    
+    // The result is already known; the mode only decides how long to show the spin
+    const timings = game.presentation[mode];
+    const spinDurations = timings.reelStopsMs;
+    
+    for (let i = 1; i <= NUM_REELS; i++) {
+        const reel = document.getElementById('reel' + i);
+        const reelInner = reel.querySelector('.reel-inner');
+        
+        if (spinDurations[i-1] === 0) {
+            showFinalReel(reelInner, result.grid[i-1], i);
+            continue;
+        }
+        
+        // Add spinning class
+        reel.classList.add('spinning');
+        
+        // Generate many symbols for spinning effect
+        reelInner.innerHTML = '';
+        for (let j = 0; j < 30; j++) {
+            const sym = (j < 27) ? getRandomSymbol() : results[i-1];
+            const div = document.createElement('div');
+            div.className = 'symbol';
+            div.textContent = sym.symbol;
+            div.dataset.symbol = sym.symbol;
+            reelInner.appendChild(div);
+        }
+        
+        // Stop after duration
+        setTimeout(() => {
+            reel.classList.remove('spinning');
+            showFinalReel(reelInner, result.grid[i-1], i);
+        }, spinDurations[i-1]);
+    }
+    
+    // Check results after all reels stop
+    return new Promise(resolve => setTimeout(() => {
+        checkWin(result);
+        resolve();
+    }, timings.resultDelayMs));
+}
+
+function showFinalReel(reelInner, column, reelNum) {
+    reelInner.innerHTML = '';
+    
+    // Show final symbols (payline in middle)
+    column.forEach((sym, idx) => {
+        const div = document.createElement('div');
+        div.className = 'symbol';
+        div.textContent = sym;
+        div.dataset.symbol = sym;
+        if (idx === game.paylineRow) div.id = 'result-' + reelNum;
+        reelInner.appendChild(div);
+    });
+}
+
+function setMode(m) {
+    mode = m;
+    localStorage.setItem('chessSlots_mode', mode);
+    document.querySelectorAll('.mode-btn').forEach(b => b.classList.toggle('active', b.dataset.mode === mode));
+}
+
+function checkWin(result) {
+    lastRoundId = result.roundId;
+    showAchievements(result.achievements);
+    const payout = result.payout;
+    const maxCount = result.matchCount;
+    const winningSymbol = result.winningSymbol;
+    const lastFree = freeSpinsLeft() ? freeSpins : null;
+    coins = result.balance;
+    if ('freeSpins' in result) freeSpins = result.freeSpins;
+    updateDisplay();
+    
+    if (payout > 0) {
+        // Highlight winning symbols
+        for (let i = 1; i <= NUM_REELS; i++) {
+            const resultEl = document.getElementById('result-' + i);
+            if (resultEl && resultEl.textContent === winningSymbol) {
+                resultEl.classList.add('winning');
+            }
+        }
+        
+        if (result.jackpot) {
+            showMessage('🎉 JACKPOT! +' + payout + ' coins and the ' + result.jackpot + ' coin pool! 🎉', 'jackpot');
+        } else if (maxCount === 5) {
+            showMessage('🎉 JACKPOT! +' + payout + ' coins! 🎉', 'jackpot');
+        } else if (maxCount === 4) {
+            showMessage('🔥 BIG WIN! +' + payout + ' coins!', 'win');
+        } else {
+            showMessage('✨ WIN! +' + payout + ' coins!', 'win');
+        }
+    } else {
+        showMessage('No luck this time...', 'lose');
+    }
+    
+    if (WATCH) {
+        if (result.bonusTriggered) {
+            const sc = scatterSymbol();
+            setTimeout(() => showMessage(sc.symbol + ' ' + result.scatters + ' ' + sc.name.toUpperCase() + 'S! They are playing Pick-a-Piece... ' + sc.symbol, 'jackpot'), payout > 0 ? 1500 : 0);
+        }
+        return;
+    }
+    
+    if (result.freeSpinsWon) {
+        setTimeout(() => showMessage('♟️ ' + result.freeSpinsWon + ' FREE SPINS! ♟️', 'jackpot'), payout > 0 ? 1500 : 0);
+    } else if (lastFree && !freeSpinsLeft()) {
+        const total = lastFree.won + payout;
+        setTimeout(() => showMessage('Free spins over: they paid ' + total + ' coins.', total > 0 ? 'win' : ''), payout > 0 ? 1500 : 0);
+    }
+    
+    if (result.bonusTriggered) {
+        bonus = result.bonus;
+        updateDisplay();
+        const sc = scatterSymbol();
+        document.querySelectorAll('.symbol').forEach(s => {
+            if (s.dataset.symbol === sc.symbol) s.classList.add('winning');
+        });
+        setTimeout(() => {
+            showMessage(sc.symbol + ' ' + result.scatters + ' ' + sc.name.toUpperCase() + 'S! Pick-a-Piece bonus! ' + sc.symbol, 'jackpot');
+            setTimeout(() => openBonus(result.bonus), 1200);
+        }, payout > 0 ? 1500 : 0);
+        return;
+    }
+    
+    if (result.duel) {
+        duel = result.duel;
+        showDuel();
+        return;
+    }
+    
+    if (tournament) {
+        if (coins < SPIN_COST) {
+            setTimeout(() => showMessage('Out of tournament credits - watch the ranking until it ends.', 'lose'), 1500);
+        }
+        return;
+    }
+    
+    loadLeaderboard();
+    if (!autoplay) showGamble(result.gamble);
+    
+    // Check if out of coins
+    if (coins < SPIN_COST && !freeSpinsLeft()) {
+        setTimeout(() => {
+            showMessage('💀 Out of coins! A free refill is on its way.', 'lose');
+        }, 1500);
+        refreshRewards();
+    }
+}
+
+function toggleAutoplayPanel() {
+    document.getElementById('autoplayPanel').classList.toggle('active');
+}
+
+async function startAutoplay() {
+    const value = id => parseInt(document.getElementById(id).value) || 0;
+    try {
+        const data = await api('autoplay', {
+            spins: value('autoSpins'),
+            lossLimit: value('autoLossLimit'),
+            singleWinLimit: value('autoWinLimit'),
+            balanceFloor: value('autoBalanceFloor'),
+            mode,
+            game: game.id,
+        });
+        autoplay = data.autoplay;
+    } catch (e) {
+        if (e.code === 'reality_check') {
+            showRealityCheck(e.message);
+        } else {
+            showMessage((e.code ? '🛡️ ' : '⚠️ ') + e.message, 'lose');
+        }
+        return;
+    }
+    autoplayCursor = 0;
+    document.getElementById('autoplayPanel').classList.remove('active');
+    followAutoplay();
+}
+
+// The server plays the spins; the page polls and replays each one
+async function followAutoplay() {
+    isSpinning = true;
+    updateDisplay();
+    let state = autoplay;
+    while (true) {
+        try {
+            state = (await api('autoplay?after=' + autoplayCursor)).autoplay;
+        } catch (e) {
+            showMessage('⚠️ ' + e.message, 'lose');
+            await new Promise(r => setTimeout(r, 2000));
+            continue;
+        }
+        for (const round of state.rounds) {
+            autoplayCursor++;
+            autoplay.played = autoplayCursor;
+            coins -= SPIN_COST;
+            updateDisplay();
+            await playRound(round);
+        }
+        if (!state.running) break;
+        await new Promise(r => setTimeout(r, 500));
+    }
+    autoplay = null;
+    isSpinning = false;
+    updateDisplay();
+    if (state.stopReason !== 'bonus triggered') {
+        const net = state.net >= 0 ? '+' + state.net : state.net;
+        showMessage('Autoplay ' + state.stopReason + ' (' + net + ' coins)', state.net > 0 ? 'win' : 'lose');
+    }
+}
+
+async function stopAutoplay() {
+    try {
+        await api('autoplay', null, 'DELETE');
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+    }
+}
+
+const limitFields = {
+    dailyLoss: 'limitDailyLoss', weeklyLoss: 'limitWeeklyLoss', monthlyLoss: 'limitMonthlyLoss',
+    dailyWager: 'limitDailyWager', weeklyWager: 'limitWeeklyWager', monthlyWager: 'limitMonthlyWager',
+    realityCheckMinutes: 'limitRealityCheck',
+};
+
+function showSafety(safety) {
+    for (const [field, id] of Object.entries(limitFields)) {
+        document.getElementById(id).value = safety.limits[field] || '';
+    }
+    for (const period of ['Daily', 'Weekly', 'Monthly']) {
+        const usage = safety.usage[period.toLowerCase()];
+        document.getElementById('usage' + period + 'Loss').textContent = Math.max(0, usage.wagered - usage.won);
+        document.getElementById('usage' + period + 'Wager').textContent = usage.wagered;
+    }
+    let note = '';
+    if (new Date(safety.excludedUntil) > new Date()) {
+        note = 'On a break until ' + new Date(safety.excludedUntil).toLocaleString() + '.';
+    } else if (safety.pending) {
+        note = 'Your raised limits take effect ' + new Date(safety.pendingFrom).toLocaleString() + '.';
+    }
+    document.getElementById('limitsNote').textContent = note;
+}
+
+async function openLimits() {
+    try {
+        showSafety(await api('limits'));
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+        return;
+    }
+    document.getElementById('limitsOverlay').classList.add('active');
+}
+
+async function saveLimits() {
+    const limits = {};
+    for (const [field, id] of Object.entries(limitFields)) {
+        limits[field] = parseInt(document.getElementById(id).value) || 0;
+    }
+    try {
+        showSafety(await api('limits', limits));
+        if (!document.getElementById('limitsNote').textContent) {
+            document.getElementById('limitsNote').textContent = '✅ Limits saved.';
+        }
+    } catch (e) {
+        document.getElementById('limitsNote').textContent = '⚠️ ' + e.message;
+    }
+}
+
+async function takeBreak(period, label) {
+    if (!confirm('Stop playing for ' + label + '? This cannot be undone.')) return;
+    try {
+        showSafety(await api('limits/exclude', { period }));
+    } catch (e) {
+        document.getElementById('limitsNote').textContent = '⚠️ ' + e.message;
+    }
+}
+
+async function showRealityCheck(message) {
+    document.getElementById('realityMessage').textContent = message;
+    try {
+        const safety = await api('limits');
+        const net = safety.session.won - safety.session.wagered;
+        document.getElementById('realityStats').innerHTML =
+            '<div>Wagered: ' + safety.session.wagered + ' 🪙</div>' +
+            '<div>Won: ' + safety.session.won + ' 🪙</div>' +
+            '<div>Net: ' + (net >= 0 ? '+' : '') + net + ' 🪙</div>';
+    } catch (e) {
+        document.getElementById('realityStats').textContent = '';
+    }
+    document.getElementById('realityOverlay').classList.add('active');
+}
+
+async function continuePlaying() {
+    try {
+        await api('limits/reality-check', {});
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+    }
+    closeOverlay('realityOverlay');
+}
+
+// The server took the last few spins for a script's, and wants the named
+// piece picked before the next one
+async function showChallenge() {
+    try {
+        renderChallenge((await api('challenge')).challenge, '');
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+    }
+}
+
+function renderChallenge(challenge, note) {
+    if (!challenge) {
+        closeOverlay('challengeOverlay');
+        return;
+    }
+    document.getElementById('challengeAsk').textContent = challenge.ask;
+    document.getElementById('challengePieces').innerHTML = challenge.pieces.map((piece, i) =>
+        '<button class="reset-btn challenge-piece" data-click="answerChallenge" data-args="' + i + '">' + piece + '</button>').join('');
+    document.getElementById('challengeNote').textContent = note;
+    document.getElementById('challengeOverlay').classList.add('active');
+}
+
+async function answerChallenge(i) {
+    let data;
+    try {
+        data = await api('challenge', { answer: parseInt(i, 10) });
+    } catch (e) {
+        document.getElementById('challengeNote').textContent = e.message;
+        return;
+    }
+    if (data.passed) {
+        closeOverlay('challengeOverlay');
+        showMessage('♞ Thanks! Spin away.', 'win');
+        return;
+    }
+    renderChallenge(data.challenge, 'Not that one. Try this board.');
+}
+
+function closeOverlay(id) {
+    document.getElementById(id).classList.remove('active');
+}
+
+function reviewLimits() {
+    closeOverlay('realityOverlay');
+    openLimits();
+}
+
+let leaderboardPeriod = 'daily';
+
+function setLeaderboardPeriod(period) {
+    leaderboardPeriod = period;
+    document.querySelectorAll('.period-btn').forEach(b => b.classList.toggle('active', b.dataset.period === period));
+    loadLeaderboard();
+}
+
+function formatMetric(metric, value) {
+    if (metric === 'multiplier') return 'x' + value.toFixed(1);
+    if (metric === 'streak') return value + (value === 1 ? ' win' : ' wins');
+    return value + ' 🪙';
+}
+
+async function loadLeaderboard() {
+    const metric = document.getElementById('leaderboardMetric').value;
+    let board;
+    try {
+        board = (await api('leaderboard?period=' + leaderboardPeriod + '&metric=' + metric)).boards[0];
+    } catch (e) {
+        return;
+    }
+    const list = document.getElementById('leaderboardList');
+    list.innerHTML = '';
+    board.entries.forEach(e => {
+        const li = document.createElement('li');
+        if (e.you) li.className = 'you';
+        li.textContent = e.rank + '. ' + (e.you ? 'You' : e.name);
+        const value = document.createElement('span');
+        value.textContent = formatMetric(metric, e.value);
+        li.appendChild(value);
+        list.appendChild(li);
+    });
+    if (board.entries.length === 0) {
+        list.innerHTML = '<li>No winners yet - be the first!</li>';
+    }
+    document.getElementById('leaderboardYou').textContent = board.you && board.you.rank > board.entries.length
+        ? 'You are #' + board.you.rank + ' with ' + formatMetric(metric, board.you.value) : '';
+}
+
+function showToast(icon, title, text) {
+    const toast = document.createElement('div');
+    toast.className = 'toast';
+    toast.innerHTML = '<span class="toast-icon"></span><div><strong></strong><div class="toast-desc"></div></div>';
+    toast.querySelector('.toast-icon').textContent = icon;
+    toast.querySelector('strong').textContent = title;
+    toast.querySelector('.toast-desc').textContent = text;
+    document.getElementById('toasts').appendChild(toast);
+    setTimeout(() => toast.classList.add('leaving'), 4500);
+    setTimeout(() => toast.remove(), 5000);
+}
+
+function showAchievements(list) {
+    (list || []).forEach((a, i) => setTimeout(() => showToast(a.icon, '🏅 ' + a.name, a.description), i * 700));
+}
+
+let bigWins = [];
+
+// Big wins, tournament rankings and announcements from every player.
+// EventSource reconnects by itself and the server replays what was missed.
+function listenForEvents() {
+    const events = new EventSource(BASE + '/api/events');
+    events.addEventListener('big_win', e => {
+        const w = JSON.parse(e.data);
+        const what = w.bonus ? 'the Pick-a-Piece bonus' : w.matchCount + '× ' + w.symbol;
+        bigWins.unshift((w.jackpot ? '🎉 JACKPOT ' : '🔥 ') + w.name + ' won ' + w.win + ' 🪙 with ' + what + (w.game ? ' in ' + w.game : '')
+            + (w.pool ? ' (' + w.pool + ' 🪙 from the pool)' : ''));
+        bigWins = bigWins.slice(0, 5);
+        document.getElementById('winTicker').textContent = bigWins.join('  ·  ');
+    });
+    // The progressive pool: sent on connect, as it grows and when it is won
+    events.addEventListener('jackpot', e => {
+        const j = JSON.parse(e.data);
+        document.getElementById('jackpot').textContent = j.amount;
+        document.getElementById('jackpotMeter').classList.add('active');
+    });
+    events.addEventListener('tournament', e => {
+        const t = JSON.parse(e.data);
+        // The feed is the same for everyone, so keep this player's own entry
+        tournaments = tournaments.map(old => old.id === t.id
+            ? Object.assign(t, { entry: old.entry, yourRank: old.yourRank }) : old);
+    });
+    events.addEventListener('duel', e => {
+        duel = JSON.parse(e.data);
+        showDuel();
+        if (duel.status === 'finished' || duel.status === 'cancelled') refreshBalance();
+    });
+    events.addEventListener('announcement', e => {
+        showToast('📣', 'Announcement', JSON.parse(e.data).message);
+        loadTournaments();
+    });
+    events.addEventListener('status', e => applyStatus(JSON.parse(e.data)));
+    events.addEventListener('paytable', e => applyPaytable(JSON.parse(e.data)));
+}
+
+// The operator loaded a new paytable for a game; spins from now on pay by it.
+// An A/B test starting or ending can put each player on a different one, so
+// the page asks for its own.
+async function applyPaytable(pt, quiet) {
+    if (pt.game !== game.id) return;
+    if (pt.refetch) {
+        const def = await api('game?id=' + encodeURIComponent(game.id));
+        if (def.paytableVersion === game.paytableVersion) return;
+        pt = def;
+    }
+    game.paytableVersion = pt.paytableVersion ?? pt.version;
+    pt.symbols.forEach((s, i) => {
+        Object.assign(symbols[i], s);
+        const item = document.querySelector('#paytableGrid [data-symbol="' + CSS.escape(s.symbol) + '"] .pay-value');
+        if (item) item.textContent = 'x' + s.payout;
+    });
+    game.scattersForBonus = pt.scattersForBonus;
+    document.querySelectorAll('#paytableGrid .pay-count').forEach(el => { el.textContent = pt.scattersForBonus; });
+    if (!quiet) showToast('💰', 'New paytable', 'The paytable changed. Your next spin uses it.');
+}
+
+// The operator flipped a feature or maintenance. Maintenance reloads into
+// the maintenance page, unless a bonus or duel is still open here: the
+// server lets those finish.
+function applyStatus(status) {
+    for (const [name, on] of Object.entries(status.features)) {
+        FEATURES[name] = on;
+        document.body.classList.toggle('no-' + name, !on);
+    }
+    if (!status.maintenance.enabled) return;
+    if (bonus || (duel && duel.status === 'active')) {
+        showMessage('🛠️ Maintenance is starting. Finish your ' + (bonus ? 'bonus' : 'duel') + '; new spins are paused.', 'lose');
+    } else {
+        location.reload();
+    }
+}
+
+async function openBadges() {
+    let data;
+    try {
+        data = await api('achievements');
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+        return;
+    }
+    const list = document.getElementById('badgeList');
+    list.innerHTML = '';
+    data.achievements.forEach(a => {
+        const item = document.createElement('div');
+        item.className = 'badge-item' + (a.earned ? ' earned' : '');
+        item.innerHTML = '<span class="toast-icon"></span><div><strong></strong><div class="toast-desc"></div></div><span class="badge-progress"></span>';
+        item.querySelector('.toast-icon').textContent = a.icon;
+        item.querySelector('strong').textContent = a.name;
+        item.querySelector('.toast-desc').textContent = a.description;
+        item.querySelector('.badge-progress').textContent = a.earned ? '✅' : a.progress + '/' + a.needed;
+        list.appendChild(item);
+    });
+    document.getElementById('badgesOverlay').classList.add('active');
+}
+
+let rewards = null;
+
+function formatWait(until) {
+    const secs = Math.max(0, Math.ceil((new Date(until) - Date.now()) / 1000));
+    const h = Math.floor(secs / 3600), m = Math.floor(secs % 3600 / 60), s = secs % 60;
+    return (h ? h + 'h ' : '') + String(m).padStart(2, '0') + 'm ' + String(s).padStart(2, '0') + 's';
+}
+
+// Redrawn every second so the countdowns tick
+function showRewards() {
+    if (!rewards) return;
+    const daily = rewards.daily, refill = rewards.refill;
+    const dailyBtn = document.getElementById('dailyBtn');
+    dailyBtn.disabled = !daily.available;
+    dailyBtn.textContent = daily.available ? '🎁 Daily Bonus +' + daily.amount : '🎁 Claimed';
+    let status = daily.streak > 0 ? '🔥 ' + daily.streak + '-day streak' : '';
+    if (!daily.available) status += (status ? ' · ' : '') + 'next in ' + formatWait(daily.nextAt);
+    document.getElementById('dailyStatus').textContent = status;
+    
+    const refillBtn = document.getElementById('refillBtn');
+    const ready = refill.pending && new Date(refill.at) <= Date.now();
+    refillBtn.style.display = ready ? 'inline-block' : 'none';
+    refillBtn.textContent = '🪙 Free Refill +' + refill.amount;
+    document.getElementById('refillStatus').textContent = refill.pending && !ready
+        ? '⏳ Refill in ' + formatWait(refill.at) : '';
+}
+
+async function refreshRewards() {
+    try {
+        rewards = await api('rewards');
+        showRewards();
+    } catch (e) {
+        // The next spin or reload will try again
+    }
+}
+
+async function claimReward(path) {
+    try {
+        const data = await api(path, {});
+        coins = data.balance;
+        rewards = data.rewards;
+        updateDisplay();
+        showRewards();
+        showMessage('🎁 +' + data.amount + ' coins!', 'win');
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+        refreshRewards();
+    }
+}
+
+function claimDaily() {
+    claimReward('rewards/daily');
+}
+
+function claimRefill() {
+    claimReward('rewards/refill');
+}
+
+setInterval(showRewards, 1000);
+
+let tournaments = [];
+
+function formatScore(rankBy, score) {
+    return rankBy === 'bestMultiplier' ? 'x' + score.toFixed(1) : score + ' 🪙';
+}
+
+async function loadTournaments() {
+    try {
+        tournaments = (await api('tournaments')).tournaments;
+    } catch (e) {
+        return;
+    }
+    showTournaments();
+}
+
+// Redrawn every second so the countdowns tick
+function showTournaments() {
+    const list = document.getElementById('tournamentList');
+    list.innerHTML = '';
+    tournaments.forEach(t => {
+        const item = document.createElement('div');
+        item.className = 'tournament-item';
+        item.innerHTML = '<div><strong></strong><div class="tournament-meta"></div></div>';
+        item.querySelector('strong').textContent = t.name;
+        const rank = t.rankBy === 'bestMultiplier' ? 'best multiplier' : 'total win';
+        let when = t.status === 'scheduled' ? 'registration opens in ' + formatWait(t.opensAt)
+            : t.status === 'registration' ? 'starts in ' + formatWait(t.startsAt)
+            : t.status === 'running' ? 'ends in ' + formatWait(t.endsAt) : 'finished';
+        if (t.status === 'finished' && t.ranking) when += ' · won by ' + t.ranking[0].name;
+        item.querySelector('.tournament-meta').textContent = when + ' · ' + t.credits + ' credits · ranked by ' + rank
+            + ' · prizes ' + t.prizes.join('/') + ' 🪙 · ' + t.entrants + ' playing';
+        
+        const btn = document.createElement('button');
+        btn.className = 'reward-btn';
+        if (!t.entry && t.status !== 'finished') {
+            btn.textContent = 'Join';
+            btn.disabled = t.status === 'scheduled';
+            btn.onclick = () => joinTournament(t.id);
+        } else if (t.entry) {
+            btn.textContent = t.status === 'finished'
+                ? (t.entry.prize ? '🏆 #' + t.yourRank + ' +' + t.entry.prize : '#' + (t.yourRank || '-'))
+                : (tournament && tournament.id === t.id ? 'Playing' : 'Play');
+            btn.disabled = t.status === 'finished' || (tournament && tournament.id === t.id);
+            btn.onclick = () => enterTournament(t);
+        } else {
+            return;
+        }
+        item.appendChild(btn);
+        list.appendChild(item);
+    });
+    if (list.children.length === 0) {
+        list.innerHTML = '<div class="tournament-meta">No tournaments open right now.</div>';
+    }
+    if (tournament) showTournamentRanking(tournament);
+}
+
+async function joinTournament(id) {
+    try {
+        const view = await api('tournaments/join', { id });
+        tournaments = tournaments.map(t => t.id === id ? view : t);
+        enterTournament(view);
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+        loadTournaments();
+    }
+}
+
+// Switches the reels over to the tournament wallet
+function enterTournament(t) {
+    if (autoplay || bonus || isSpinning) return;
+    tournament = t;
+    coins = t.entry.credits;
+    document.getElementById('balanceLabel').textContent = t.name + ' Credits';
+    document.getElementById('tournamentLive').classList.add('active');
+    showMessage(t.status === 'running' ? 'Good luck in the ' + t.name + '!' : 'You are registered - spins open when the tournament starts.', 'win');
+    updateDisplay();
+    showTournaments();
+    followRanking(t.id);
+}
+
+async function leaveTournament() {
+    if (isSpinning) return;
+    tournament = null;
+    document.getElementById('balanceLabel').textContent = 'Your Balance';
+    document.getElementById('tournamentLive').classList.remove('active');
+    showMessage('');
+    try {
+        coins = (await api('state')).balance;
+    } catch (e) {
+        // Keeps the tournament credits on screen until the next reload
+    }
+    updateDisplay();
+    loadTournaments();
+}
+
+function showTournamentRanking(t) {
+    document.getElementById('tournamentLiveTitle').textContent = '⏱️ ' + t.name;
+    document.getElementById('tournamentLiveMeta').textContent = t.status === 'registration' ? 'Starts in ' + formatWait(t.startsAt)
+        : t.status === 'running' ? 'Ends in ' + formatWait(t.endsAt) + (t.yourRank ? ' · you are #' + t.yourRank : '')
+        : 'Finished' + (t.yourRank ? ' · you placed #' + t.yourRank : '');
+    const list = document.getElementById('tournamentRanking');
+    list.innerHTML = '';
+    (t.ranking || []).forEach(e => {
+        const li = document.createElement('li');
+        if (e.you) li.className = 'you';
+        li.textContent = e.rank + '. ' + (e.you ? 'You' : e.name);
+        const value = document.createElement('span');
+        value.textContent = formatScore(t.rankBy, e.score) + (e.prize ? ' · 🏆 ' + e.prize : '');
+        li.appendChild(value);
+        list.appendChild(li);
+    });
+}
+
+// Long-polls the live ranking until the tournament ends or the
+// player goes back to the main game
+async function followRanking(id) {
+    let version = -1;
+    while (tournament && tournament.id === id) {
+        let view;
+        try {
+            view = await api('tournaments/ranking?id=' + encodeURIComponent(id) + '&since=' + version);
+        } catch (e) {
+            await new Promise(r => setTimeout(r, 5000));
+            continue;
+        }
+        if (!tournament || tournament.id !== id) return;
+        version = view.version;
+        tournament = view;
+        showTournamentRanking(view);
+        if (view.status === 'finished') {
+            const prize = view.entry && view.entry.prize;
+            showMessage(prize ? '🏆 You placed #' + view.yourRank + ' and won ' + prize + ' coins!' : view.name + ' has finished.', prize ? 'jackpot' : '');
+            loadTournaments();
+            return;
+        }
+        // Registered players start spinning as soon as it opens
+        if (view.status === 'running') updateDisplay();
+    }
+}
+
+setInterval(showTournaments, 1000);
+
+// Duel spins are paid for by the buy-in; regular spins cost coins
+function duelActive() {
+    return !tournament && !document.body.classList.contains('guest-game') && duel !== null && duel.status === 'active' && duel.you.spinsLeft > 0;
+}
+
+function spinCost() {
+    return duelActive() || freeSpinsLeft() ? 0 : SPIN_COST;
+}
+
+// Free spins play on the game they were won on, outside duels and
+// tournaments
+function freeSpinsLeft() {
+    if (!FEATURES.freespins || !freeSpins || freeSpins.gameId !== game.id || tournament || duelActive()) return 0;
+    return freeSpins.left;
+}
+
+// A paid win can be staked on the colour of the next piece until the
+// next spin
+function showGamble(offer) {
+    gamble = offer || null;
+    document.getElementById('gamblePanel').classList.toggle('active', gamble !== null);
+    if (gamble) document.getElementById('gambleStake').textContent = gamble.stake;
+}
+
+async function playGamble(pick) {
+    if (!gamble || isSpinning) return;
+    isSpinning = true;
+    updateDisplay();
+    try {
+        const res = await send('gamble', { pick }, 'gamble', { pick });
+        coins = res.balance;
+        showAchievements(res.achievements);
+        const piece = (res.drawn === 'white' ? '♙ ' : '♟ ') + res.drawn.toUpperCase();
+        showMessage(res.win > 0 ? piece + '! Doubled to ' + res.win + ' coins!' : piece + '... the ' + res.stake + ' coins are gone.', res.win > 0 ? 'win' : 'lose');
+        showGamble(res.offer);
+    } catch (e) {
+        showMessage((e.code ? '🛡️ ' : '⚠️ ') + e.message, 'lose');
+        if (!e.lost) showGamble(null);
+    }
+    isSpinning = false;
+    updateDisplay();
+}
+
+async function collectGamble() {
+    showGamble(null);
+    try {
+        coins = (await api('gamble', null, 'DELETE')).balance;
+        updateDisplay();
+    } catch (e) {
+        // The next spin drops the offer anyway
+    }
+}
+
+async function refreshBalance() {
+    if (isSpinning || tournament) return;
+    try {
+        coins = (await api('state')).balance;
+        updateDisplay();
+    } catch (e) {
+        // The next spin brings the balance back in line
+    }
+}
+
+async function loadDuel() {
+    try {
+        duel = (await api('duel')).duel;
+    } catch (e) {
+        return;
+    }
+    showDuel();
+}
+
+async function duelAction(method) {
+    try {
+        duel = (await api('duel', null, method)).duel;
+    } catch (e) {
+        showMessage((e.code ? '🛡️ ' : '⚠️ ') + e.message, 'lose');
+    }
+    refreshBalance();
+    showDuel();
+}
+
+function duelSide(label, side) {
+    const row = document.createElement('div');
+    row.className = 'duel-side';
+    row.innerHTML = '<div><strong></strong><div class="tournament-meta"></div></div><span class="duel-payline"></span>';
+    row.querySelector('strong').textContent = label;
+    row.querySelector('.tournament-meta').textContent = side.total + ' 🪙 won · ' + side.spinsLeft + ' spins left'
+        + (side.abandoned ? ' · walked away' : '');
+    row.querySelector('.duel-payline').textContent = side.lastSpin ? side.lastSpin.payline.join('') : '';
+    return row;
+}
+
+// Redrawn every second so the deadline ticks
+function showDuel() {
+    const panel = document.getElementById('duelPanel');
+    panel.innerHTML = '';
+    const meta = document.createElement('div');
+    meta.className = 'tournament-meta';
+    const btn = document.createElement('button');
+    btn.className = 'reward-btn';
+    const policy = game && game.duel;
+    if (!policy || !policy.spins) {
+        meta.textContent = 'Duels are not available right now.';
+        panel.appendChild(meta);
+        return;
+    }
+    
+    if (!duel || duel.status === 'finished' || duel.status === 'cancelled') {
+        if (duel && duel.status === 'finished') {
+            panel.appendChild(duelSide('You', duel.you));
+            panel.appendChild(duelSide(duel.opponent.name, duel.opponent));
+            meta.textContent = duel.result === 'won' ? '🏆 You won the ' + duel.pot + ' coin pot!'
+                : duel.result === 'draw' ? 'A draw - buy-ins refunded.' : 'You lost this duel.';
+        } else if (duel) {
+            meta.textContent = 'Nobody turned up - your buy-in was refunded.';
+        }
+        btn.textContent = '⚔️ Find an opponent (' + policy.buyIn + ' 🪙)';
+        btn.disabled = coins < policy.buyIn || tournament !== null;
+        btn.onclick = () => duelAction('POST');
+        panel.appendChild(meta);
+        panel.appendChild(btn);
+        const rules = document.createElement('div');
+        rules.className = 'tournament-meta';
+        rules.textContent = policy.spins + ' spins each - the bigger total win takes both buy-ins.';
+        panel.appendChild(rules);
+        return;
+    }
+    
+    if (duel.status === 'waiting') {
+        meta.textContent = 'Looking for an opponent... gives up in ' + formatWait(duel.deadline);
+        btn.textContent = 'Cancel';
+        btn.onclick = () => duelAction('DELETE');
+        panel.appendChild(meta);
+        panel.appendChild(btn);
+        return;
+    }
+    
+    panel.appendChild(duelSide('You', duel.you));
+    panel.appendChild(duelSide(duel.opponent.name, duel.opponent));
+    meta.textContent = 'Pot ' + duel.pot + ' 🪙 · ' + (duel.deadline
+        ? 'spin within ' + formatWait(duel.deadline) + ' or forfeit'
+        : 'waiting for your opponent to finish');
+    panel.appendChild(meta);
+    updateDisplay();
+}
+
+setInterval(showDuel, 1000);
+
+let liveView = null;
+
+function showLiveView() {
+    const link = document.getElementById('liveLink');
+    link.style.display = liveView.enabled ? 'block' : 'none';
+    link.value = liveView.enabled ? location.origin + BASE + '/' + game.id + '?watch=' + liveView.token : '';
+    document.getElementById('liveWatchers').textContent = liveView.enabled
+        ? '👁️ ' + liveView.watchers + (liveView.watchers === 1 ? ' person' : ' people') + ' watching' : 'Your live view is off.';
+    document.getElementById('liveToggle').textContent = liveView.enabled ? 'Turn off (ends the link)' : 'Turn on';
+}
+
+async function openLiveView() {
+    try {
+        liveView = await api('spectate');
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+        return;
+    }
+    showLiveView();
+    document.getElementById('liveViewOverlay').classList.add('active');
+}
+
+async function toggleLiveView() {
+    try {
+        liveView = await api('spectate', { enabled: !liveView.enabled });
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+        return;
+    }
+    showLiveView();
+}
+
+// Spectator layout: no controls, just the watched player's rounds
+// replayed one after another on the same reels
+function watch() {
+    document.body.classList.add('spectating');
+    document.getElementById('balanceLabel').textContent = 'Their Balance';
+    const queue = [];
+    let playing = false;
+    
+    async function drain() {
+        if (playing) return;
+        playing = true;
+        while (queue.length > 0) {
+            const item = queue.shift();
+            if (item.type === 'round') {
+                // They moved to another game; follow them to its page
+                if (item.data.game && item.data.game !== game.id) {
+                    events.close();
+                    location.replace(BASE + '/' + item.data.game + '?watch=' + encodeURIComponent(WATCH));
+                    return;
+                }
+                await playRound(item.data);
+            } else {
+                coins = item.data.balance;
+                updateDisplay();
+                showMessage(item.data.payout > 0 ? game.theme.icon + ' BONUS WIN! +' + item.data.payout + ' coins! ' + game.theme.icon : 'Captured! No bonus prize this time...', item.data.payout > 0 ? 'jackpot' : 'lose');
+                await new Promise(r => setTimeout(r, 1500));
+            }
+        }
+        playing = false;
+    }
+    
+    const events = new EventSource(BASE + '/api/spectate/watch?token=' + encodeURIComponent(WATCH));
+    events.addEventListener('snapshot', e => {
+        const snap = JSON.parse(e.data);
+        document.getElementById('subtitle').textContent = '👁️ Watching ' + snap.name + ' live';
+        if (!playing && queue.length === 0) {
+            coins = snap.balance;
+            updateDisplay();
+        }
+    });
+    ['round', 'bonus'].forEach(type => events.addEventListener(type, e => {
+        queue.push({ type, data: JSON.parse(e.data) });
+        drain();
+    }));
+    const ended = () => {
+        events.close();
+        showMessage('This live view has ended.', 'lose');
+    };
+    events.addEventListener('ended', ended);
+    events.onerror = () => {
+        if (events.readyState === EventSource.CLOSED) ended();
+    };
+}
+setInterval(loadTournaments, 30000);
+
+function squareLabel(prize) {
+    if (prize.kind === 'coins') return '🪙' + prize.value * SPIN_COST;
+    if (prize.kind === 'multiplier') return '+' + prize.value + 'x';
+    return '⚔️';
+}
+
+function revealSquare(square, prize, extraClass) {
+    const el = document.getElementById('square-' + square);
+    el.textContent = squareLabel(prize);
+    el.classList.add(prize.kind === 'captured' ? 'captured' : 'revealed');
+    if (extraClass) el.classList.add(extraClass);
+    el.disabled = true;
+}
+
+function openBonus(state) {
+    bonus = state;
+    updateDisplay();
+    const board = document.getElementById('chessboard');
+    board.innerHTML = '';
+    for (let i = 0; i < 64; i++) {
+        const btn = document.createElement('button');
+        btn.className = 'square' + ((Math.floor(i / 8) + i) % 2 === 0 ? ' light' : '');
+        btn.id = 'square-' + i;
+        btn.onclick = () => pickSquare(i);
+        board.appendChild(btn);
+    }
+    state.picks.forEach(p => revealSquare(p.square, p.prize));
+    document.getElementById('bonusCoins').textContent = state.coins;
+    document.getElementById('bonusMultiplier').textContent = state.multiplier;
+    document.getElementById('bonusProof').textContent = 'Board commitment: ' + state.commitment;
+    document.getElementById('bonusCollect').style.display = 'none';
+    document.getElementById('bonusOverlay').classList.add('active');
+}
+
+async function pickSquare(square) {
+    if (!bonus || bonus.finished) return;
+    let data;
+    try {
+        data = await send('bonus.pick', { square }, 'bonus/pick', { square });
+    } catch (e) {
+        showMessage('⚠️ ' + e.message, 'lose');
+        return;
+    }
+    bonus = data.bonus;
+    revealSquare(data.pick.square, data.pick.prize);
+    document.getElementById('bonusCoins').textContent = bonus.coins;
+    document.getElementById('bonusMultiplier').textContent = bonus.multiplier;
+    if (bonus.finished) {
+        coins = data.balance;
+        finishBonus(bonus);
+    }
+    showAchievements(data.achievements);
+}
+
+async function finishBonus(state) {
+    document.querySelectorAll('.square').forEach(s => s.disabled = true);
+    
+    // Show the rest of the board and prove it matches the commitment
+    const codes = state.board.split(',');
+    codes.forEach((code, i) => {
+        if (state.picks.some(p => p.square === i)) return;
+        const prize = code === 'x' ? { kind: 'captured' }
+            : { kind: code[0] === 'c' ? 'coins' : 'multiplier', value: parseInt(code.slice(1)) };
+        revealSquare(i, prize, 'unpicked');
+    });
+    const digest = await crypto.subtle.digest('SHA-256', new TextEncoder().encode(state.salt + '|' + state.board));
+    const hex = Array.from(new Uint8Array(digest)).map(b => b.toString(16).padStart(2, '0')).join('');
+    document.getElementById('bonusProof').textContent = hex === state.commitment
+        ? '✅ Board verified against commitment ' + state.commitment
+        : '❌ Board does not match commitment ' + state.commitment;
+    
+    showMessage(state.payout > 0 ? game.theme.icon + ' BONUS WIN! +' + state.payout + ' coins! ' + game.theme.icon : 'Captured! No bonus prize this time...', state.payout > 0 ? 'jackpot' : 'lose');
+    document.getElementById('bonusCollect').style.display = 'inline-block';
+}
+
+function closeBonus() {
+    bonus = null;
+    document.getElementById('bonusOverlay').classList.remove('active');
+    updateDisplay();
+    refreshRewards();
+    loadLeaderboard();
+}
+
+// The page has no inline handlers (the Content-Security-Policy forbids
+// them): data-click and data-change name the function to call and
+// data-args its comma-separated arguments
+['click', 'change'].forEach(type => document.addEventListener(type, e => {
+    const el = e.target.closest('[data-' + type + ']');
+    if (!el || el.disabled) return;
+    window[el.dataset[type]](...(el.dataset.args ? el.dataset.args.split(',') : []));
+}));
+document.getElementById('liveLink').addEventListener('click', e => e.target.select());
+
+// Initialize
+init();
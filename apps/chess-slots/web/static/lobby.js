@@ -0,0 +1,12 @@
+const BOOT = JSON.parse(document.getElementById('boot').textContent);
+
+// Themes are CSS backgrounds; they are applied here rather than in a style
+// attribute so the template never writes raw CSS
+document.querySelectorAll('.game-card').forEach(card => {
+    if (card.dataset.theme) card.style.background = card.dataset.theme;
+});
+
+fetch(BOOT.base + '/api/state')
+    .then(res => res.json())
+    .then(state => { document.getElementById('coins').textContent = state.balance; })
+    .catch(() => {});
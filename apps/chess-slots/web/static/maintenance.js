@@ -0,0 +1,20 @@
+// Shown instead of the game during maintenance. It asks the server now and
+// then whether maintenance is over, and reloads into the game when it is.
+const BOOT = JSON.parse(document.getElementById('boot').textContent);
+const POLL_MS = 15000;
+
+async function checkStatus() {
+    try {
+        const res = await fetch(BOOT.base + '/api/status');
+        const status = await res.json();
+        if (!status.maintenance.enabled) {
+            location.reload();
+            return;
+        }
+    } catch (e) {
+        // The server may be restarting; try again later
+    }
+    setTimeout(checkStatus, POLL_MS);
+}
+
+setTimeout(checkStatus, POLL_MS);
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, coding string
+		want           bool
+	}{
+		{header: "", coding: "gzip"},
+		{header: "gzip", coding: "gzip", want: true},
+		{header: "gzip, deflate, br", coding: "br", want: true},
+		{header: "GZIP", coding: "gzip", want: true},
+		{header: "br;q=0, gzip", coding: "br"},
+		{header: "br;q=0.5", coding: "br", want: true},
+		{header: "gzipped", coding: "gzip"},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
+
+func TestServeStatic(t *testing.T) {
+	// An asset with a Brotli copy, as the Docker build makes
+	plain := bytes.Repeat([]byte("console.log('chess');\n"), 50)
+	staticAssets["test.js"] = &staticAsset{contentType: "text/javascript; charset=utf-8", version: "abc123", body: plain, gzip: gzipped(t, plain), brotli: []byte("brotli bytes")}
+	t.Cleanup(func() { delete(staticAssets, "test.js") })
+	css := staticAssets["game.css"]
+
+	tests := []struct {
+		name        string
+		method      string
+		file        string
+		query       string
+		accept      string
+		ifNoneMatch string
+		status      int
+		encoding    string
+		etag        string
+		cache       string
+	}{
+		{name: "plain", file: "test.js", status: http.StatusOK, etag: `"abc123"`, cache: "no-cache"},
+		{name: "gzip", file: "test.js", accept: "gzip, deflate", status: http.StatusOK, encoding: "gzip", etag: `"abc123-gzip"`, cache: "no-cache"},
+		{name: "brotli first", file: "test.js", accept: "gzip, br", status: http.StatusOK, encoding: "br", etag: `"abc123-br"`, cache: "no-cache"},
+		{name: "brotli refused", file: "test.js", accept: "br;q=0, gzip", status: http.StatusOK, encoding: "gzip", etag: `"abc123-gzip"`, cache: "no-cache"},
+		{name: "no brotli copy", file: "game.css", accept: "br", status: http.StatusOK, etag: `"` + css.version + `"`, cache: "no-cache"},
+		{name: "current version", file: "test.js", query: "?v=abc123", status: http.StatusOK, etag: `"abc123"`, cache: "public, max-age=31536000, immutable"},
+		{name: "old version", file: "test.js", query: "?v=0ld", status: http.StatusOK, etag: `"abc123"`, cache: "no-cache"},
+		{name: "not modified", file: "test.js", accept: "gzip", ifNoneMatch: `"abc123-gzip"`, status: http.StatusNotModified, etag: `"abc123-gzip"`, cache: "no-cache"},
+		{name: "other encoding's tag", file: "test.js", ifNoneMatch: `"abc123-gzip"`, status: http.StatusOK, etag: `"abc123"`, cache: "no-cache"},
+		{name: "head", method: "HEAD", file: "test.js", accept: "gzip", status: http.StatusOK, encoding: "gzip", etag: `"abc123-gzip"`, cache: "no-cache"},
+		{name: "missing", file: "nope.js", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			r := httptest.NewRequest(method, "/static/"+tt.file+tt.query, nil)
+			r.SetPathValue("file", tt.file)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			serveStatic(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusNotFound {
+				return
+			}
+			h := w.Header()
+			if h.Get("Content-Encoding") != tt.encoding || h.Get("ETag") != tt.etag || h.Get("Cache-Control") != tt.cache {
+				t.Errorf("encoding %q, ETag %s, Cache-Control %q; want %q, %s, %q",
+					h.Get("Content-Encoding"), h.Get("ETag"), h.Get("Cache-Control"), tt.encoding, tt.etag, tt.cache)
+			}
+			if h.Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q", h.Get("Vary"))
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if method == "HEAD" {
+				if w.Body.Len() != 0 || h.Get("Content-Length") == "" {
+					t.Errorf("HEAD sent %d bytes, Content-Length %q", w.Body.Len(), h.Get("Content-Length"))
+				}
+				return
+			}
+			body := w.Body.Bytes()
+			if tt.encoding == "gzip" {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ = io.ReadAll(zr)
+			}
+			if tt.file == "test.js" && tt.encoding != "br" && !bytes.Equal(body, plain) {
+				t.Errorf("body doesn't match the file")
+			}
+		})
+	}
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStaticAssetsCompressed(t *testing.T) {
+	tests := []struct {
+		file string
+		gzip bool
+	}{
+		{file: "game.js", gzip: true},
+		{file: "game.css", gzip: true},
+	}
+	for _, tt := range tests {
+		a, ok := staticAssets[tt.file]
+		if !ok {
+			t.Errorf("%s is not embedded", tt.file)
+			continue
+		}
+		if (a.gzip != nil) != tt.gzip {
+			t.Errorf("%s gzipped = %v, want %v", tt.file, a.gzip != nil, tt.gzip)
+		}
+		if want := "/slots/static/" + tt.file + "?v=" + a.version; assetURL("/slots", tt.file) != want {
+			t.Errorf("assetURL = %q, want %q", assetURL("/slots", tt.file), want)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The WebSocket side of the API: a small RFC 6455 server (text frames
+// only) carrying a versioned JSON protocol. It plays through the same
+// Store as the REST handlers, so both share one wallet and round history.
+
+const (
+	wsProtocolVersion = 1
+	wsMaxMessage      = 64 << 10
+	wsPingInterval    = 30 * time.Second
+	wsReadTimeout     = 2 * wsPingInterval
+	// Rounds replayed to a client resuming after a dropped connection
+	wsMaxResume = 50
+	wsGUID      = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+var errWSProtocol = errors.New("websocket protocol error")
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	wmu  sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake. Headers already set
+// on w, such as a new player cookie, go out with the 101 response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("expected a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	// The player cookie is the only credential, so other sites' pages
+	// must not be able to open a socket with it
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return nil, errors.New("cross-origin websocket rejected")
+		}
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection cannot be upgraded")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+	for name, values := range w.Header() {
+		for _, v := range values {
+			b.WriteString(name + ": " + v + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text message, answering pings and
+// reassembling fragments on the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		var head [2]byte
+		if _, err := io.ReadFull(c.br, head[:]); err != nil {
+			return nil, err
+		}
+		fin, op := head[0]&0x80 != 0, head[0]&0x0F
+		masked, n := head[1]&0x80 != 0, uint64(head[1]&0x7F)
+		if !masked || head[0]&0x70 != 0 {
+			return nil, errWSProtocol
+		}
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > wsMaxMessage || uint64(len(msg))+n > wsMaxMessage {
+			c.close(1009, "message too big")
+			return nil, errWSProtocol
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+		case wsOpPong:
+		case wsOpClose:
+			c.close(1000, "")
+			return nil, io.EOF
+		case wsOpBinary:
+			c.close(1003, "text frames only")
+			return nil, errWSProtocol
+		case wsOpText, wsOpContinuation:
+			if (op == wsOpText) != (msg == nil) {
+				return nil, errWSProtocol
+			}
+			msg = append(msg, payload...)
+			if msg == nil {
+				msg = []byte{}
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, errWSProtocol
+		}
+	}
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	head := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xFFFF:
+		head = append(head, 126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(append(head, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) close(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	c.writeFrame(wsOpClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// wsRequest is a client message. ID is echoed on the reply so the client
+// can match it to the request.
+type wsRequest struct {
+	V          int    `json:"v"`
+	Type       string `json:"type"` // hello, state, spin, tournament.spin, bonus.pick, gamble
+	ID         string `json:"id,omitempty"`
+	ResumeFrom string `json:"resumeFrom,omitempty"`
+	Tournament string `json:"tournament,omitempty"`
+	Game       string `json:"game,omitempty"`
+	Square     *int   `json:"square,omitempty"`
+	Pick       string `json:"pick,omitempty"`
+}
+
+// wsReply is a server message: a reply to a request or a push (balance).
+type wsReply struct {
+	V    int    `json:"v"`
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Data any    `json:"data"`
+}
+
+type wsError struct {
+	Error  string     `json:"error"`
+	Status int        `json:"status"`
+	Code   string     `json:"code,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+type welcomeResponse struct {
+	Protocol int `json:"protocol"`
+	stateResponse
+	// Rounds settled after ResumeFrom that the client may not have seen
+	Missed []Round `json:"missed"`
+}
+
+func toWSError(err error) wsError {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return wsError{Error: limitErr.Message, Status: http.StatusForbidden, Code: limitErr.Code, Until: limitErr.Until}
+	}
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		until := time.Now().Add(rateErr.RetryAfter)
+		return wsError{Error: err.Error(), Status: http.StatusTooManyRequests, Code: "rate_limited", Until: &until}
+	}
+	return wsError{Error: err.Error(), Status: errorStatus(err)}
+}
+
+// watchBalance subscribes to playerID's balance. Only the latest balance
+// matters, so a slow reader just gets the newest value.
+func (s *Store) watchBalance(playerID string) (chan int, func()) {
+	c := make(chan int, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.balanceWatchers[playerID] == nil {
+		s.balanceWatchers[playerID] = map[chan int]bool{}
+	}
+	s.balanceWatchers[playerID][c] = true
+	return c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.balanceWatchers[playerID], c)
+		if len(s.balanceWatchers[playerID]) == 0 {
+			delete(s.balanceWatchers, playerID)
+		}
+	}
+}
+
+// notifyBalance pushes p's balance to their sockets. Callers must hold s.mu.
+func (s *Store) notifyBalance(p *Player) {
+	for c := range s.balanceWatchers[p.ID] {
+		select {
+		case <-c:
+		default:
+		}
+		c <- p.Balance
+	}
+}
+
+// roundsAfter returns playerID's rounds settled after roundID, oldest
+// first. Callers must hold s.mu.
+func (s *Store) roundsAfter(playerID, roundID string) []Round {
+	missed := []Round{}
+	if roundID == "" {
+		return missed
+	}
+	for i := len(s.rounds) - 1; i >= 0; i-- {
+		r := s.rounds[i]
+		if r.ID == roundID {
+			for l, h := 0, len(missed)-1; l < h; l, h = l+1, h-1 {
+				missed[l], missed[h] = missed[h], missed[l]
+			}
+			return missed
+		}
+		if r.PlayerID == playerID && len(missed) < wsMaxResume {
+			missed = append(missed, r)
+		}
+	}
+	// An unknown round (say, from before a restart) resumes nothing
+	return []Round{}
+}
+
+func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	id := playerID(r)
+	c, err := upgradeWebSocket(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer c.conn.Close()
+
+	balances, unwatch := s.store.watchBalance(id)
+	defer unwatch()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case b := <-balances:
+				c.writeJSON(wsReply{V: wsProtocolVersion, Type: "balance", Data: map[string]int{"balance": b}})
+			case <-ping.C:
+				c.writeFrame(wsOpPing, nil)
+			case <-s.closing:
+				// 1001: going away; the client reconnects elsewhere
+				c.close(1001, "server restarting")
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		data, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil || req.Type == "" {
+			c.writeJSON(wsReply{V: wsProtocolVersion, Type: "error", Data: wsError{Error: "invalid message", Status: http.StatusBadRequest}})
+			continue
+		}
+		if req.V != wsProtocolVersion {
+			c.writeJSON(wsReply{V: wsProtocolVersion, Type: "error", ID: req.ID, Data: wsError{
+				Error:  fmt.Sprintf("unsupported protocol version %d, this server speaks %d", req.V, wsProtocolVersion),
+				Status: http.StatusBadRequest,
+			}})
+			c.close(1002, "unsupported protocol version")
+			return
+		}
+		_, span := tracer.start(r.Context(), "ws "+req.Type, spanKindServer)
+		typ, reply, err := s.handleWSRequest(id, clientIP(r), req)
+		if err != nil {
+			typ, reply = "error", toWSError(err)
+			span.fail(err)
+		}
+		span.set("ws.message.type", req.Type)
+		span.end()
+		if c.writeJSON(wsReply{V: wsProtocolVersion, Type: typ, ID: req.ID, Data: reply}) != nil {
+			return
+		}
+	}
+}
+
+func (s *server) handleWSRequest(playerID, ip string, req wsRequest) (string, any, error) {
+	switch req.Type {
+	case "hello", "state":
+		var resp welcomeResponse
+		s.store.Update(playerID, func(p *Player) error {
+			resp = welcomeResponse{
+				Protocol: wsProtocolVersion,
+				stateResponse: stateResponse{
+					Balance:      p.Balance,
+					SpinCost:     s.store.game.SpinCost,
+					Bonus:        bonusView(p),
+					Autoplay:     autoplayView(p.Autoplay, -1),
+					Rewards:      s.store.rewardsStatus(p, time.Now()),
+					FreeSpins:    p.FreeSpins,
+					Gamble:       p.Gamble,
+					Achievements: s.store.takeNewBadges(p),
+				},
+				Missed: s.store.roundsAfter(p.ID, req.ResumeFrom),
+			}
+			return nil
+		})
+		return "welcome", resp, nil
+	case "spin":
+		if err := s.maintenance.check(); err != nil {
+			return "", nil, err
+		}
+		resp, err := s.playSpin(playerID, ip, req.Game)
+		return "spin.result", resp, err
+	case "tournament.spin":
+		if err := s.checkFeature("tournaments"); err != nil {
+			return "", nil, err
+		}
+		if err := s.maintenance.check(); err != nil {
+			return "", nil, err
+		}
+		resp, err := s.playTournamentSpin(playerID, ip, req.Tournament)
+		return "tournament.spin.result", resp, err
+	case "gamble":
+		if err := s.checkFeature("gamble"); err != nil {
+			return "", nil, err
+		}
+		if err := s.maintenance.check(); err != nil {
+			return "", nil, err
+		}
+		resp, err := s.playGamble(playerID, ip, req.Pick)
+		return "gamble.result", resp, err
+	case "bonus.pick":
+		if req.Square == nil {
+			return "", nil, errInvalidSquare
+		}
+		resp, err := s.playPick(playerID, *req.Square)
+		return "bonus.pick.result", resp, err
+	default:
+		return "", nil, fmt.Errorf("%w: unknown message type %q", errWSProtocol, req.Type)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// wsFrame is a client frame: masked unless told otherwise, as RFC 6455
+// requires of clients.
+func wsFrame(op byte, fin, masked bool, payload []byte) []byte {
+	b := []byte{op}
+	if fin {
+		b[0] |= 0x80
+	}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xFFFF:
+		b = append(b, maskBit|126, byte(n>>8), byte(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if !masked {
+		return append(b, payload...)
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask[:]...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+func TestReadMessage(t *testing.T) {
+	text := func(s string) []byte { return wsFrame(wsOpText, true, true, []byte(s)) }
+	// A frame that only claims to be too big; the server stops at the header
+	oversize := append([]byte{0x80 | wsOpText, 0x80 | 127}, binary.BigEndian.AppendUint64(nil, wsMaxMessage+1)...)
+	half := bytes.Repeat([]byte("a"), wsMaxMessage/2+1)
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   string
+		err    error
+	}{
+		{name: "masked text", frames: [][]byte{text("hello")}, want: "hello"},
+		{name: "empty text", frames: [][]byte{text("")}, want: ""},
+		{name: "16-bit length", frames: [][]byte{text(string(bytes.Repeat([]byte("b"), 300)))}, want: string(bytes.Repeat([]byte("b"), 300))},
+		{name: "unmasked", frames: [][]byte{wsFrame(wsOpText, true, false, []byte("hello"))}, err: errWSProtocol},
+		{name: "reserved bit set", frames: [][]byte{append([]byte{0xC0 | wsOpText}, text("hello")[1:]...)}, err: errWSProtocol},
+		{
+			name: "fragments around a ping",
+			frames: [][]byte{
+				wsFrame(wsOpText, false, true, []byte("hel")),
+				wsFrame(wsOpPing, true, true, []byte("p")),
+				wsFrame(wsOpContinuation, true, true, []byte("lo")),
+			},
+			want: "hello",
+		},
+		{name: "continuation first", frames: [][]byte{wsFrame(wsOpContinuation, true, true, []byte("lo"))}, err: errWSProtocol},
+		{name: "oversize frame", frames: [][]byte{oversize}, err: errWSProtocol},
+		{
+			name: "oversize across fragments",
+			frames: [][]byte{
+				wsFrame(wsOpText, false, true, half),
+				wsFrame(wsOpContinuation, true, true, half),
+			},
+			err: errWSProtocol,
+		},
+		{name: "binary", frames: [][]byte{wsFrame(wsOpBinary, true, true, []byte{1})}, err: errWSProtocol},
+		{name: "close", frames: [][]byte{wsFrame(wsOpClose, true, true, nil)}, err: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			// net.Pipe is unbuffered, so write the frames and take the
+			// server's pongs and close frames side by side
+			go func() {
+				for _, f := range tt.frames {
+					if _, err := client.Write(f); err != nil {
+						return
+					}
+				}
+			}()
+			go io.Copy(io.Discard, client)
+			c := &wsConn{conn: server, br: bufio.NewReader(server)}
+
+			msg, err := c.readMessage()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && string(msg) != tt.want {
+				t.Errorf("message = %.40q, want %.40q", msg, tt.want)
+			}
+		})
+	}
+}